@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const accountKeyPrefix = "account_"
+
+var (
+	errNoEncryptionKey = errors.New("no encryption key is configured")
+	// errAccountUnusable is returned for linked accounts whose credentials cannot be used
+	// anymore, instead of acting with the API key of the instance on behalf of the user.
+	errAccountUnusable = errors.New("your Redmine account is disconnected, connect it again with `/redmine connect`")
+)
+
+// userAccount is the Redmine account a Mattermost user linked with /redmine connect.
+type userAccount struct {
+	// Instance is the URL of the Redmine instance.
+	Instance string `json:"instance"`
+	// EncryptedAPIKey is the personal API key of the user, encrypted with the EncryptionKey
+	// setting.
+	EncryptedAPIKey []byte `json:"encrypted_api_key,omitempty"`
+	// EncryptedToken is the JSON encoded OAuth2 token of the user, encrypted like the API
+	// key. Accounts have either an API key or a token.
+	EncryptedToken []byte `json:"encrypted_token,omitempty"`
+	RedmineUserID  int    `json:"redmine_user_id"`
+	Login          string `json:"login"`
+	Name           string `json:"name"`
+}
+
+func newUserAccount(instance *redmineInstance, user *redmine.User) *userAccount {
+	return &userAccount{
+		Instance:      instance.URL,
+		RedmineUserID: user.ID,
+		Login:         user.Login,
+		Name:          user.Name(),
+	}
+}
+
+// setAPIKey encrypts the personal API key of the account.
+func (a *userAccount) setAPIKey(secret, apiKey string) error {
+	encryptedAPIKey, err := encrypt(secret, apiKey)
+	if err != nil {
+		return err
+	}
+
+	a.EncryptedAPIKey = encryptedAPIKey
+	a.EncryptedToken = nil
+	return nil
+}
+
+// setToken encrypts the OAuth2 token of the account.
+func (a *userAccount) setToken(secret string, token *redmine.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	encryptedToken, err := encrypt(secret, string(data))
+	if err != nil {
+		return err
+	}
+
+	a.EncryptedToken = encryptedToken
+	a.EncryptedAPIKey = nil
+	return nil
+}
+
+// token decrypts the OAuth2 token of the account.
+func (a *userAccount) token(secret string) (*redmine.Token, error) {
+	data, err := decrypt(secret, a.EncryptedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var token redmine.Token
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// accountKey identifies the account of a user on an instance.
+func accountKey(userID, instanceURL string) string {
+	return accountKeyPrefix + userID + "_" + instanceKeyHash(instanceURL)
+}
+
+// getUserAccount returns the linked account of a user, or nil when there is none.
+func (p *Plugin) getUserAccount(userID string, instance *redmineInstance) (*userAccount, error) {
+	if p.kvStore == nil || userID == "" {
+		return nil, nil
+	}
+
+	var account *userAccount
+	if err := p.kvStore.Get(accountKey(userID, instance.URL), &account); err != nil {
+		return nil, fmt.Errorf("failed to load account: %w", err)
+	}
+
+	return account, nil
+}
+
+// saveUserAccount links a Redmine account.
+func (p *Plugin) saveUserAccount(userID string, account *userAccount) error {
+	if p.kvStore == nil {
+		return errors.New("the plugin is not activated")
+	}
+
+	if _, err := p.kvStore.Set(accountKey(userID, account.Instance), account); err != nil {
+		return fmt.Errorf("failed to save account: %w", err)
+	}
+
+	return nil
+}
+
+// deleteUserAccount unlinks the account of a user.
+func (p *Plugin) deleteUserAccount(userID string, instance *redmineInstance) error {
+	if p.kvStore == nil {
+		return nil
+	}
+
+	return p.kvStore.Delete(accountKey(userID, instance.URL))
+}
+
+// disconnectUserAccount unlinks the account of a user, revoking its OAuth2 token if any. It
+// returns the removed account, or nil when none was linked.
+func (p *Plugin) disconnectUserAccount(userID string, instance *redmineInstance) (*userAccount, error) {
+	account, err := p.getUserAccount(userID, instance)
+	if err != nil || account == nil {
+		return nil, err
+	}
+
+	if account.EncryptedToken != nil && instance.oauthEnabled() {
+		if err := p.revokeUserToken(instance, account); err != nil {
+			p.API.LogWarn("Failed to revoke Redmine OAuth2 token", "user_id", userID, "instance", instance.URL, "err", err.Error())
+		}
+	}
+
+	if err := p.deleteUserAccount(userID, instance); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// encryptionKey derives the AES-256 key from the EncryptionKey setting.
+func encryptionKey(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, errNoEncryptionKey
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:], nil
+}
+
+// encrypt seals plaintext with AES-GCM, prefixing the random nonce.
+func encrypt(secret, plaintext string) ([]byte, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+// decrypt opens a value sealed by encrypt.
+func decrypt(secret string, ciphertext []byte) (string, error) {
+	gcm, err := newGCM(secret)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func newGCM(secret string) (cipher.AEAD, error) {
+	key, err := encryptionKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// userClient is a Redmine client acting on behalf of a Mattermost user.
+type userClient struct {
+	*redmine.Client
+
+	// account is the linked account of the user, nil when the user has not connected one.
+	account *userAccount
+	// shared reports whether the client uses the API key configured for the instance. Only
+	// issues fetched with it are cached, since they are visible to every user alike.
+	shared bool
+	// authenticated reports whether requests carry an API key at all.
+	authenticated bool
+}
+
+func (p *Plugin) newRedmineClient(instance *redmineInstance, apiKey string) (*redmine.Client, error) {
+	redmineURL, _ := getRedmineInstanceURL(instance.URL)
+	if redmineURL == "" {
+		return nil, fmt.Errorf("invalid Redmine instance URL %q", instance.URL)
+	}
+
+	return redmine.NewClient(redmineURL,
+		redmine.WithAPIKey(apiKey),
+		redmine.WithHTTPClient(p.httpClient),
+	)
+}
+
+// getUserClient returns a client using the OAuth2 token or personal API key the user linked.
+// Users without a linked account get the API key configured for the instance when AllowGlobalAPIKey is set,
+// and anonymous access otherwise.
+func (p *Plugin) getUserClient(instance *redmineInstance, userID string) (*userClient, error) {
+	client, err := p.getLinkedClient(instance, userID)
+	if err != nil || client != nil {
+		return client, err
+	}
+
+	apiKey := ""
+	if p.getConfiguration().AllowGlobalAPIKey {
+		apiKey = instance.APIKey
+	}
+
+	redmineClient, err := p.newRedmineClient(instance, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userClient{Client: redmineClient, shared: apiKey == instance.APIKey, authenticated: apiKey != ""}, nil
+}
+
+// getPersonalClient returns a client using the account the user linked, or anonymous access
+// for users without one. Unlike getUserClient, it never uses the API key of the instance.
+func (p *Plugin) getPersonalClient(instance *redmineInstance, userID string) (*userClient, error) {
+	client, err := p.getLinkedClient(instance, userID)
+	if err != nil || client != nil {
+		return client, err
+	}
+
+	redmineClient, err := p.newRedmineClient(instance, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &userClient{Client: redmineClient}, nil
+}
+
+// getLinkedClient returns a client using the OAuth2 token or personal API key the user linked,
+// or nil when the user has no linked account. Linked accounts whose token cannot be refreshed or
+// whose API key cannot be decrypted are reported as disconnected with errAccountUnusable.
+func (p *Plugin) getLinkedClient(instance *redmineInstance, userID string) (*userClient, error) {
+	account, err := p.getUserAccount(userID, instance)
+	if err != nil {
+		p.API.LogWarn("Failed to load Redmine account", "user_id", userID, "instance", instance.URL, "err", err.Error())
+	}
+
+	if account != nil && account.EncryptedToken != nil {
+		token, err := p.getUserToken(userID, instance, account)
+		if err != nil {
+			p.API.LogWarn("Failed to get Redmine OAuth2 token", "user_id", userID, "instance", instance.URL, "err", err.Error())
+			return nil, errAccountUnusable
+		}
+		client, err := p.newTokenClient(instance, token)
+		if err != nil {
+			return nil, err
+		}
+		return &userClient{Client: client, account: account, authenticated: true}, nil
+	} else if account != nil {
+		apiKey, err := decrypt(p.getConfiguration().EncryptionKey, account.EncryptedAPIKey)
+		if err != nil {
+			p.API.LogWarn("Failed to decrypt Redmine API key", "user_id", userID, "instance", instance.URL, "err", err.Error())
+			return nil, errAccountUnusable
+		}
+		client, err := p.newRedmineClient(instance, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return &userClient{Client: client, account: account, authenticated: true}, nil
+	}
+
+	return nil, nil
+}
+
+// ensureGeneratedSettings generates the EncryptionKey and WebhookSecret settings when they
+// are empty. Saving the configuration triggers OnConfigurationChange.
+func (p *Plugin) ensureGeneratedSettings() error {
+	configuration := p.getConfiguration()
+	if configuration.EncryptionKey != "" && configuration.WebhookSecret != "" {
+		return nil
+	}
+
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		return err
+	}
+	var settings map[string]any
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	if configuration.EncryptionKey == "" {
+		settings["EncryptionKey"] = model.NewRandomString(32)
+	}
+	if configuration.WebhookSecret == "" {
+		settings["WebhookSecret"] = model.NewRandomString(32)
+	}
+
+	if appErr := p.API.SavePluginConfig(settings); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestEncrypt(t *testing.T) {
+	ciphertext, err := encrypt("secret", "api-key")
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "api-key")
+
+	plaintext, err := decrypt("secret", ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "api-key", plaintext)
+
+	_, err = decrypt("other", ciphertext)
+	assert.Error(t, err)
+
+	_, err = encrypt("", "api-key")
+	assert.ErrorIs(t, err, errNoEncryptionKey)
+}
+
+func newAccountsTestServer(t *testing.T) *redminetest.Server {
+	server := redminetest.NewServer(t)
+	server.SetAPIKey("global-key")
+	server.AddUser(redmine.User{ID: 5, Login: "jdoe", Firstname: "Jane", Lastname: "Doe"})
+	server.AddUserAPIKey("jane-key", 5)
+	server.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Public issue"})
+	server.AddIssue(redmine.Issue{ID: 2, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Private issue", IsPrivate: true, AssignedTo: redmine.IssueProperty{ID: 5}})
+
+	return server
+}
+
+func newAccountsTestPlugin(server *redminetest.Server, allowGlobalAPIKey bool) *Plugin {
+	return &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL:   "https://redmine.example.com",
+			RedmineAPIKey:        "global-key",
+			AllowGlobalAPIKey:    allowGlobalAPIKey,
+			EncryptionKey:        "secret",
+			IssueCacheTTLMinutes: 5,
+			TooltipTemplate:      "{{.Status.Name}}",
+		},
+		httpClient: server.HTTPClient(),
+		kvStore:    &pluginapi.MemoryStore{},
+		issueCache: newIssueCache(&pluginapi.MemoryStore{}, issueCacheCapacity, 5*time.Minute),
+		botUserID:  "bot-id",
+	}
+}
+
+func TestConnectDialog(t *testing.T) {
+	server := newAccountsTestServer(t)
+
+	api := &plugintest.API{}
+	var opened model.OpenDialogRequest
+	api.On("OpenInteractiveDialog", mock.AnythingOfType("model.OpenDialogRequest")).Run(func(args mock.Arguments) {
+		opened = args.Get(0).(model.OpenDialogRequest)
+	}).Return(nil)
+	var confirmation *model.Post
+	api.On("SendEphemeralPost", "user-id", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		confirmation = args.Get(1).(*model.Post)
+	}).Return(&model.Post{})
+
+	plugin := newAccountsTestPlugin(server, false)
+	plugin.SetAPI(api)
+
+	submit := func(apiKey string) model.SubmitDialogResponse {
+		body, err := json.Marshal(model.SubmitDialogRequest{
+			UserId:     "user-id",
+			ChannelId:  "channel-id",
+			State:      opened.Dialog.State,
+			Submission: map[string]any{"api_key": apiKey},
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, routeConnectDialog, bytes.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", "user-id")
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response model.SubmitDialogResponse
+		if w.Body.Len() > 0 {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return response
+	}
+
+	response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine connect", UserId: "user-id", TriggerId: "trigger-id"})
+	require.Nil(t, appErr)
+	assert.Empty(t, response.Text)
+	assert.Equal(t, "/plugins/"+manifest.Id+"/api/v1/dialog/connect", opened.URL)
+	assert.Equal(t, "password", opened.Dialog.Elements[0].SubType)
+	assert.Contains(t, opened.Dialog.IntroductionText, "https://redmine.example.com/my/account")
+
+	t.Run("Rejected API key", func(t *testing.T) {
+		assert.Equal(t, map[string]string{"api_key": "Redmine rejected the API key."}, submit("wrong-key").Errors)
+	})
+
+	t.Run("Connects the account", func(t *testing.T) {
+		assert.Equal(t, model.SubmitDialogResponse{}, submit(" jane-key "))
+		require.NotNil(t, confirmation)
+		assert.Equal(t, "Your Redmine account Jane Doe (jdoe) is now connected to https://redmine.example.com.", confirmation.Message)
+
+		account, err := plugin.getUserAccount("user-id", plugin.getConfiguration().getInstances()[0])
+		require.NoError(t, err)
+		require.NotNil(t, account)
+		assert.Equal(t, 5, account.RedmineUserID)
+		assert.NotContains(t, string(account.EncryptedAPIKey), "jane-key")
+	})
+
+	t.Run("Disconnects the account", func(t *testing.T) {
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine disconnect", UserId: "user-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Your Redmine account jdoe was disconnected from https://redmine.example.com.", response.Text)
+
+		response, appErr = plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine disconnect", UserId: "user-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "No Redmine account is connected on https://redmine.example.com.", response.Text)
+	})
+}
+
+func TestMessageWillBePostedWithLinkedAccount(t *testing.T) {
+	message := "https://redmine.example.com/issues/1 and https://redmine.example.com/issues/2"
+	publicLink := `[Bug#1: Public issue](https://redmine.example.com/issues/1 "")`
+	privateLink := `[Bug#2: Private issue](https://redmine.example.com/issues/2 "")`
+	// expectAnonymous expects the anonymous request of users without credentials to be rejected
+	expectAnonymous := func(plugin *Plugin) {
+		api := &plugintest.API{}
+		api.On("LogDebug", "Redmine requires authentication to fetch issues", "instance", "https://redmine.example.com", "issue_ids", "1,2").Return()
+		plugin.SetAPI(api)
+	}
+
+	t.Run("Personal API key", func(t *testing.T) {
+		server := newAccountsTestServer(t)
+		plugin := newAccountsTestPlugin(server, false)
+		account := newUserAccount(plugin.getConfiguration().getInstances()[0], &redmine.User{ID: 5, Login: "jdoe"})
+		require.NoError(t, account.setAPIKey("secret", "jane-key"))
+		require.NoError(t, plugin.saveUserAccount("user-id", account))
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: message})
+		assert.Equal(t, publicLink+" and "+privateLink, post.Message)
+
+		// Issues fetched with a personal API key are not cached for other users.
+		expectAnonymous(plugin)
+		post, _ = plugin.MessageWillBePosted(nil, &model.Post{UserId: "other-user-id", Message: message})
+		assert.Equal(t, message, post.Message)
+		assert.Len(t, server.Requests(), 2)
+	})
+
+	t.Run("Falls back to the global API key", func(t *testing.T) {
+		server := newAccountsTestServer(t)
+		plugin := newAccountsTestPlugin(server, true)
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: message})
+		assert.Equal(t, publicLink+" and "+privateLink, post.Message)
+	})
+
+	t.Run("Without fallback to the global API key", func(t *testing.T) {
+		server := newAccountsTestServer(t)
+		plugin := newAccountsTestPlugin(server, false)
+		expectAnonymous(plugin)
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: message})
+		assert.Equal(t, message, post.Message)
+	})
+}
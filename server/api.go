@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	apiPrefix = "/api/v1"
+
+	routeCreateIssueDialog = apiPrefix + "/dialog/create"
+	routeConnectDialog     = apiPrefix + "/dialog/connect"
+	routeIssueDetails      = apiPrefix + "/issue"
+
+	routeOAuthConnect    = "/oauth/connect"
+	routeOAuthCallback   = "/oauth/callback"
+	routeOAuthDisconnect = "/oauth/disconnect"
+
+	routeWebhook = "/webhook"
+)
+
+// pluginURL returns the path the Mattermost server routes to ServeHTTP for the given route.
+func pluginURL(route string) string {
+	return "/plugins/" + manifest.Id + route
+}
+
+// ServeHTTP handles HTTP requests to /plugins/<plugin id>.
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(routeCreateIssueDialog, p.requireUser(p.handleCreateIssueDialog))
+	mux.HandleFunc(routeConnectDialog, p.requireUser(p.handleConnectDialog))
+	mux.HandleFunc(routeIssueDetails, p.requireUser(p.handleIssueDetails))
+	mux.HandleFunc(routeOAuthConnect, p.requireUser(p.handleOAuthConnect))
+	mux.HandleFunc(routeOAuthCallback, p.requireUser(p.handleOAuthCallback))
+	mux.HandleFunc(routeOAuthDisconnect, p.requireUser(p.handleOAuthDisconnect))
+	mux.HandleFunc(routeWebhook, p.handleWebhook)
+
+	mux.ServeHTTP(w, r)
+}
+
+// requireUser rejects requests that were not made by a logged in Mattermost user. The server
+// sets the Mattermost-User-ID header for authenticated requests.
+func (p *Plugin) requireUser(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mattermost-User-ID") == "" {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+// Values of the LinkDisplayMode setting.
+const (
+	linkDisplayInline      = "inline"
+	linkDisplayAttachments = "attachments"
+	linkDisplayBoth        = "both"
+	linkDisplayPreview     = "preview"
+)
+
+const (
+	defaultMaxAttachments = 5
+
+	// attachmentCountProp records how many of the trailing attachments of a post were added
+	// by the plugin, so that they can be replaced when the post is edited.
+	attachmentCountProp = "redmine_attachment_count"
+
+	progressBarWidth = 10
+)
+
+const (
+	colorClosed   = "#8F8F8F"
+	colorDefault  = "#2389D7"
+	colorProgress = "#FFBC1F"
+	colorResolved = "#3DB887"
+	colorFeedback = "#E07315"
+	colorRejected = "#D24B4E"
+)
+
+// statusColors maps the names of the default Redmine statuses to attachment colours.
+var statusColors = map[string]string{
+	"new":         colorDefault,
+	"in progress": colorProgress,
+	"resolved":    colorResolved,
+	"feedback":    colorFeedback,
+	"rejected":    colorRejected,
+	"closed":      colorClosed,
+}
+
+// referencedIssue is an issue linked in a post together with the instance it belongs to.
+type referencedIssue struct {
+	redmine.Issue
+	instance *redmineInstance
+	// note is the journal linked by the first reference to the issue, if any.
+	note *linkNote
+}
+
+// statusColor returns the attachment colour of an issue status. Closed statuses are grey.
+func statusColor(status redmine.Status) string {
+	if status.IsClosed {
+		return colorClosed
+	}
+	if color, ok := statusColors[strings.ToLower(status.Name)]; ok {
+		return color
+	}
+
+	return colorDefault
+}
+
+// progressBar renders a done ratio such as 30 as ▰▰▰▱▱▱▱▱▱▱ 30%.
+func progressBar(doneRatio int) string {
+	doneRatio = max(0, min(doneRatio, 100))
+	done := doneRatio * progressBarWidth / 100
+
+	return strings.Repeat("▰", done) + strings.Repeat("▱", progressBarWidth-done) + " " + strconv.Itoa(doneRatio) + "%"
+}
+
+// issueAttachment builds the card shown below a post for a referenced issue.
+func issueAttachment(issue referencedIssue, dates dateFormatter) *model.SlackAttachment {
+	redmineURL, _ := getRedmineInstanceURL(issue.instance.URL)
+	title := fmt.Sprintf("%s #%d: %s", issue.Tracker.Name, issue.ID, issue.Subject)
+
+	assignee := issue.AssignedTo.Name
+	if assignee == "" {
+		assignee = "Unassigned"
+	}
+
+	fields := []*model.SlackAttachmentField{
+		{Title: "Status", Value: issue.Status.Name, Short: true},
+		{Title: "Assignee", Value: assignee, Short: true},
+		{Title: "Priority", Value: issue.Priority.Name, Short: true},
+		{Title: "Project", Value: issue.Project.Name, Short: true},
+		{Title: "Progress", Value: progressBar(issue.DoneRatio), Short: true},
+	}
+	if issue.DueDate != nil && *issue.DueDate != "" {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Due date", Value: *issue.DueDate, Short: true})
+	}
+
+	if note := issue.note; note != nil {
+		fields = append(fields, noteField(note, dates))
+	}
+
+	attachment := &model.SlackAttachment{
+		Fallback:   title,
+		Color:      statusColor(issue.Status),
+		AuthorName: issue.instance.Label,
+		Title:      title,
+		TitleLink:  fmt.Sprintf("%sissues/%d", redmineURL, issue.ID),
+		Fields:     fields,
+	}
+	if issue.UpdatedOn != "" {
+		attachment.Footer = "Last update: " + dates.format(issue.UpdatedOn)
+	}
+
+	return attachment
+}
+
+// setIssueAttachments replaces the attachments previously added to the post by the plugin
+// with cards for the given issues, keeping any other attachments of the post.
+func setIssueAttachments(post *model.Post, issues []referencedIssue, maxAttachments int, dates dateFormatter) {
+	attachments := post.Attachments()
+	if previous := attachmentCount(post); previous > 0 && previous <= len(attachments) {
+		attachments = attachments[:len(attachments)-previous]
+	}
+
+	if maxAttachments <= 0 {
+		maxAttachments = defaultMaxAttachments
+	}
+	if len(issues) > maxAttachments {
+		issues = issues[:maxAttachments]
+	}
+	for _, issue := range issues {
+		attachments = append(attachments, issueAttachment(issue, dates))
+	}
+
+	if len(attachments) == 0 {
+		post.DelProp("attachments")
+	} else {
+		model.ParseSlackAttachment(post, attachments)
+	}
+
+	if len(issues) == 0 {
+		post.DelProp(attachmentCountProp)
+	} else {
+		post.AddProp(attachmentCountProp, len(issues))
+	}
+}
+
+// attachmentCount returns the value of attachmentCountProp, which is a float64 once the post
+// has been stored.
+func attachmentCount(post *model.Post) int {
+	switch count := post.GetProp(attachmentCountProp).(type) {
+	case int:
+		return count
+	case float64:
+		return int(count)
+	default:
+		return 0
+	}
+}
+
+// noteField shows the linked note of an issue with an excerpt of its text and its changes.
+func noteField(note *linkNote, dates dateFormatter) *model.SlackAttachmentField {
+	var lines []string
+	if note.Notes != "" {
+		lines = append(lines, truncate(maxNoteExcerptLength, note.Notes))
+	}
+	lines = append(lines, journalChangeLines(note.Changes)...)
+
+	return &model.SlackAttachmentField{
+		Title: fmt.Sprintf("Note #%d by %s, %s", note.Number, note.Author, dates.format(note.CreatedOn)),
+		Value: strings.Join(lines, "\n"),
+	}
+}
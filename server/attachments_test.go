@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestProgressBar(t *testing.T) {
+	assert.Equal(t, "▱▱▱▱▱▱▱▱▱▱ 0%", progressBar(0))
+	assert.Equal(t, "▰▰▰▱▱▱▱▱▱▱ 30%", progressBar(30))
+	assert.Equal(t, "▰▰▰▰▰▰▰▰▰▰ 100%", progressBar(100))
+	assert.Equal(t, "▰▰▰▰▰▰▰▰▰▰ 100%", progressBar(120))
+}
+
+func TestStatusColor(t *testing.T) {
+	assert.Equal(t, colorClosed, statusColor(redmine.Status{IssueProperty: redmine.IssueProperty{Name: "Done"}, IsClosed: true}))
+	assert.Equal(t, colorProgress, statusColor(redmine.Status{IssueProperty: redmine.IssueProperty{Name: "In Progress"}}))
+	assert.Equal(t, colorDefault, statusColor(redmine.Status{IssueProperty: redmine.IssueProperty{Name: "Waiting for QA"}}))
+}
+
+func TestMessageWillBePostedAttachments(t *testing.T) {
+	dueDate := "2024-06-01"
+	server := redminetest.NewServer(t)
+	for id := 1; id <= 3; id++ {
+		server.AddIssue(redmine.Issue{
+			ID:         id,
+			Project:    redmine.IssueProperty{Name: "Website"},
+			Tracker:    redmine.IssueProperty{Name: "Bug"},
+			Status:     redmine.Status{IssueProperty: redmine.IssueProperty{Name: "In Progress"}},
+			Priority:   redmine.IssueProperty{Name: "High"},
+			AssignedTo: redmine.IssueProperty{Name: "Jane Doe"},
+			Subject:    fmt.Sprintf("Issue %d", id),
+			DueDate:    &dueDate,
+			DoneRatio:  50,
+			UpdatedOn:  "2024-05-20T08:15:00Z",
+		})
+	}
+
+	newPlugin := func(mode string) *Plugin {
+		return &Plugin{
+			configuration: &configuration{
+				RedmineInstanceURL: "https://redmine.example.com",
+				LinkDisplayMode:    mode,
+				MaxAttachments:     2,
+			},
+			httpClient: server.HTTPClient(),
+		}
+	}
+	message := "See https://redmine.example.com/issues/1, https://redmine.example.com/issues/2 " +
+		"and https://redmine.example.com/issues/3 (again https://redmine.example.com/issues/1)"
+
+	t.Run("Attachments only", func(t *testing.T) {
+		newPost, _ := newPlugin(linkDisplayAttachments).MessageWillBePosted(nil, &model.Post{Message: message})
+
+		assert.Equal(t, message, newPost.Message)
+		attachments := newPost.Attachments()
+		require.Len(t, attachments, 2)
+		assert.Equal(t, &model.SlackAttachment{
+			Fallback:  "Bug #1: Issue 1",
+			Color:     colorProgress,
+			Title:     "Bug #1: Issue 1",
+			TitleLink: "https://redmine.example.com/issues/1",
+			Fields: []*model.SlackAttachmentField{
+				{Title: "Status", Value: "In Progress", Short: true},
+				{Title: "Assignee", Value: "Jane Doe", Short: true},
+				{Title: "Priority", Value: "High", Short: true},
+				{Title: "Project", Value: "Website", Short: true},
+				{Title: "Progress", Value: "▰▰▰▰▰▱▱▱▱▱ 50%", Short: true},
+				{Title: "Due date", Value: "2024-06-01", Short: true},
+			},
+			Footer: "Last update: Mon, 20 May 2024 08:15:00 UTC",
+		}, attachments[0])
+		assert.Equal(t, "Bug #2: Issue 2", attachments[1].Title)
+		assert.Equal(t, 2, attachmentCount(newPost))
+	})
+
+	t.Run("Inline links and attachments", func(t *testing.T) {
+		newPost, _ := newPlugin(linkDisplayBoth).MessageWillBePosted(nil, &model.Post{Message: message})
+
+		assert.True(t, strings.HasPrefix(newPost.Message, "See [Bug#1: Issue 1](https://redmine.example.com/issues/1 "))
+		assert.Len(t, newPost.Attachments(), 2)
+	})
+
+	t.Run("Inline links only", func(t *testing.T) {
+		newPost, _ := newPlugin(linkDisplayInline).MessageWillBePosted(nil, &model.Post{Message: message})
+
+		assert.Empty(t, newPost.Attachments())
+		assert.Nil(t, newPost.GetProp(attachmentCountProp))
+	})
+
+	t.Run("Editing replaces the attachments of the plugin", func(t *testing.T) {
+		plugin := newPlugin(linkDisplayAttachments)
+		post := &model.Post{Message: message}
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{{Text: "From an integration"}})
+
+		newPost, _ := plugin.MessageWillBePosted(nil, post)
+		require.Len(t, newPost.Attachments(), 3)
+
+		newPost.Message = "Only https://redmine.example.com/issues/3 now"
+		updatedPost, _ := plugin.MessageWillBeUpdated(nil, newPost, post)
+		attachments := updatedPost.Attachments()
+		require.Len(t, attachments, 2)
+		assert.Equal(t, "From an integration", attachments[0].Text)
+		assert.Equal(t, "Bug #3: Issue 3", attachments[1].Title)
+
+		updatedPost.Message = "No issues"
+		updatedPost, _ = plugin.MessageWillBeUpdated(nil, updatedPost, newPost)
+		require.Len(t, updatedPost.Attachments(), 1)
+		assert.Nil(t, updatedPost.GetProp(attachmentCountProp))
+	})
+}
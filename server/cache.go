@@ -0,0 +1,266 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	// issueCacheCapacity is the maximum number of issues kept in memory.
+	issueCacheCapacity = 1000
+	// resourceCacheCapacity is the maximum number of rendered resource links kept in memory.
+	resourceCacheCapacity = 500
+
+	issueCacheKeyPrefix = "issue_cache_"
+)
+
+// KVStore is the subset of pluginapi.KVService used by the plugin. pluginapi.MemoryStore
+// implements it as well, which makes it easy to use in tests.
+type KVStore interface {
+	Set(key string, value interface{}, options ...pluginapi.KVSetOption) (bool, error)
+	Get(key string, o interface{}) error
+	Delete(key string) error
+	ListKeys(page, count int, options ...pluginapi.ListKeysOption) ([]string, error)
+	SetAtomicWithRetries(key string, valueFunc func(oldValue []byte) (newValue interface{}, err error)) error
+}
+
+// CacheStats counts issue cache lookups since the plugin was activated.
+type CacheStats struct {
+	Hits      int64
+	KVHits    int64
+	Misses    int64
+	Evictions int64
+}
+
+type cachedIssue struct {
+	Key       string        `json:"-"`
+	Issue     redmine.Issue `json:"issue"`
+	FetchedAt time.Time     `json:"fetched_at"`
+}
+
+// issueCache keeps recently fetched issues in an in-memory LRU backed by the KV store, so
+// repeated links to the same issue do not reach Redmine until the TTL expires. The KV
+// store makes entries survive restarts and shares them between cluster nodes.
+type issueCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	store    KVStore
+	stats    CacheStats
+}
+
+func newIssueCache(store KVStore, capacity int, ttl time.Duration) *issueCache {
+	return &issueCache{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		store:    store,
+	}
+}
+
+// issueCacheKey identifies an issue of a given Redmine instance. The instance URL is hashed to
+// keep the key within the KV store length limit.
+func issueCacheKey(instanceURL string, issueID int) string {
+	sum := sha256.Sum256([]byte(instanceURL))
+	return issueCacheKeyPrefix + hex.EncodeToString(sum[:8]) + "_" + strconv.Itoa(issueID)
+}
+
+// enabled reports whether caching is turned on, i.e. the TTL is positive.
+func (c *issueCache) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ttl > 0
+}
+
+// setTTL changes the TTL and drops every in-memory entry, as the configuration they were
+// fetched with may no longer apply.
+func (c *issueCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Get returns the cached issue if it has not expired.
+func (c *issueCache) Get(instanceURL string, issueID int) (redmine.Issue, bool) {
+	key := issueCacheKey(instanceURL, issueID)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cachedIssue)
+		if time.Since(entry.FetchedAt) < c.ttl {
+			c.order.MoveToFront(elem)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return entry.Issue, true
+		}
+		c.removeElement(elem)
+	}
+	ttl := c.ttl
+	c.mu.Unlock()
+
+	if c.store != nil {
+		var entry cachedIssue
+		if err := c.store.Get(key, &entry); err == nil && !entry.FetchedAt.IsZero() && time.Since(entry.FetchedAt) < ttl {
+			entry.Key = key
+			c.mu.Lock()
+			c.stats.KVHits++
+			c.add(&entry)
+			c.mu.Unlock()
+			return entry.Issue, true
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	return redmine.Issue{}, false
+}
+
+// Set stores a freshly fetched issue in memory and in the KV store.
+func (c *issueCache) Set(instanceURL string, issue redmine.Issue) error {
+	entry := &cachedIssue{
+		Key:       issueCacheKey(instanceURL, issue.ID),
+		Issue:     issue,
+		FetchedAt: time.Now(),
+	}
+
+	c.mu.Lock()
+	c.add(entry)
+	ttl := c.ttl
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+
+	_, err := c.store.Set(entry.Key, entry, pluginapi.SetExpiry(ttl))
+	return err
+}
+
+// Invalidate drops a single issue, e.g. after it was changed in Redmine.
+func (c *issueCache) Invalidate(instanceURL string, issueID int) error {
+	key := issueCacheKey(instanceURL, issueID)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+
+	return c.store.Delete(key)
+}
+
+// Stats returns a snapshot of the lookup counters.
+func (c *issueCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// add inserts or refreshes an entry and evicts the least recently used ones above capacity.
+// The caller must hold the lock.
+func (c *issueCache) add(entry *cachedIssue) {
+	if elem, ok := c.entries[entry.Key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removeElement drops an entry from memory. The caller must hold the lock.
+func (c *issueCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cachedIssue).Key)
+}
+
+type cachedResource struct {
+	key       string
+	link      resourceLink
+	fetchedAt time.Time
+}
+
+// resourceCache keeps the recently rendered links to resources other than issues in an
+// in-memory LRU, so repeated links to the same project, version or wiki page do not reach
+// Redmine until the TTL expires. Links are rendered for a viewer, so the key must include
+// every option they were rendered with.
+type resourceCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+func newResourceCache(capacity int) *resourceCache {
+	return &resourceCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached link if it was rendered less than ttl ago.
+func (c *resourceCache) Get(key string, ttl time.Duration) (resourceLink, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return resourceLink{}, false
+	}
+	entry := elem.Value.(*cachedResource)
+	if time.Since(entry.fetchedAt) >= ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return resourceLink{}, false
+	}
+	c.order.MoveToFront(elem)
+
+	return entry.link, true
+}
+
+// Set stores a freshly rendered link and evicts the least recently used ones above capacity.
+func (c *resourceCache) Set(key string, link resourceLink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cachedResource{key: key, link: link, fetchedAt: time.Now()}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResource).key)
+	}
+}
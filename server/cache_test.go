@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const testInstanceURL = "https://www.redmine.org/"
+
+func TestIssueCache(t *testing.T) {
+	t.Run("Memory hit and miss", func(t *testing.T) {
+		cache := newIssueCache(nil, 10, time.Minute)
+
+		_, ok := cache.Get(testInstanceURL, 1)
+		assert.False(t, ok)
+
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 1, Subject: "Cached"}))
+		issue, ok := cache.Get(testInstanceURL, 1)
+		require.True(t, ok)
+		assert.Equal(t, "Cached", issue.Subject)
+
+		_, ok = cache.Get("https://other.example.com/", 1)
+		assert.False(t, ok)
+
+		assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, cache.Stats())
+	})
+
+	t.Run("Least recently used entries are evicted", func(t *testing.T) {
+		cache := newIssueCache(nil, 2, time.Minute)
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 1}))
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 2}))
+		_, _ = cache.Get(testInstanceURL, 1)
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 3}))
+
+		_, ok := cache.Get(testInstanceURL, 2)
+		assert.False(t, ok)
+		_, ok = cache.Get(testInstanceURL, 1)
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, cache.Stats().Evictions)
+	})
+
+	t.Run("Expired entries are ignored", func(t *testing.T) {
+		cache := newIssueCache(nil, 10, time.Nanosecond)
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 1}))
+		time.Sleep(time.Millisecond)
+
+		_, ok := cache.Get(testInstanceURL, 1)
+		assert.False(t, ok)
+	})
+
+	t.Run("KV store survives a restart", func(t *testing.T) {
+		store := &pluginapi.MemoryStore{}
+		require.NoError(t, newIssueCache(store, 10, time.Minute).Set(testInstanceURL, redmine.Issue{ID: 1, Subject: "Persisted"}))
+
+		cache := newIssueCache(store, 10, time.Minute)
+		issue, ok := cache.Get(testInstanceURL, 1)
+		require.True(t, ok)
+		assert.Equal(t, "Persisted", issue.Subject)
+		assert.EqualValues(t, 1, cache.Stats().KVHits)
+	})
+
+	t.Run("Invalidate removes memory and KV entries", func(t *testing.T) {
+		store := &pluginapi.MemoryStore{}
+		cache := newIssueCache(store, 10, time.Minute)
+		require.NoError(t, cache.Set(testInstanceURL, redmine.Issue{ID: 1}))
+		require.NoError(t, cache.Invalidate(testInstanceURL, 1))
+
+		_, ok := cache.Get(testInstanceURL, 1)
+		assert.False(t, ok)
+		keys, err := store.ListKeys(0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+}
+
+func TestMessageWillBePostedUsesCache(t *testing.T) {
+	server := newTestRedmineServer(t)
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL:   "https://www.redmine.org",
+			IssueCacheTTLMinutes: 5,
+		},
+		httpClient: server.HTTPClient(),
+		issueCache: newIssueCache(&pluginapi.MemoryStore{}, issueCacheCapacity, 5*time.Minute),
+	}
+	message := "https://www.redmine.org/issues/40556 and https://www.redmine.org/issues/40559"
+
+	first, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+	second, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+
+	assert.Equal(t, first.Message, second.Message)
+	assert.NotEqual(t, message, second.Message)
+	assert.Len(t, server.Requests(), 1)
+	assert.EqualValues(t, 2, plugin.issueCache.Stats().Hits)
+}
+
+func TestOnConfigurationChangeKeepsCache(t *testing.T) {
+	settings := configuration{RedmineInstanceURL: "https://www.redmine.org", IssueCacheTTLMinutes: 5}
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.AnythingOfType("*main.configuration")).Run(func(args mock.Arguments) {
+		*args.Get(0).(*configuration) = settings
+	}).Return(nil)
+
+	plugin := &Plugin{issueCache: newIssueCache(nil, issueCacheCapacity, 0)}
+	plugin.SetAPI(api)
+	require.NoError(t, plugin.OnConfigurationChange())
+	require.NoError(t, plugin.issueCache.Set(testInstanceURL, redmine.Issue{ID: 1}))
+
+	settings.TooltipTemplate = "{{.Status.Name}}"
+	require.NoError(t, plugin.OnConfigurationChange())
+	_, ok := plugin.issueCache.Get(testInstanceURL, 1)
+	assert.True(t, ok)
+
+	settings.IssueCacheTTLMinutes = 10
+	require.NoError(t, plugin.OnConfigurationChange())
+	_, ok = plugin.issueCache.Get(testInstanceURL, 1)
+	assert.False(t, ok)
+}
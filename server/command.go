@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	commandTrigger = "redmine"
+
+	// commandResultLimit is the number of issues listed per instance by search and mine.
+	commandResultLimit = 10
+
+	commandHelp = `* |/redmine view <issue>| - Show an issue, given by its ID, URL or a short reference
+* |/redmine create [project]| - Create an issue
+* |/redmine search <text>| - Search issues by subject
+* |/redmine mine| - List the open issues assigned to you
+* |/redmine connect [instance]| - Link your Redmine account with your personal API key
+* |/redmine disconnect [instance]| - Unlink your Redmine account
+* |/redmine subscribe <project> [--tracker=Bug] [--status=closed] [--priority=High]| - Post the issue changes of a project into this channel
+* |/redmine subscribe list| - List the subscriptions of this channel
+* |/redmine unsubscribe <project>| - Stop posting the issue changes of a project
+* |/redmine cache| - Show the issue cache statistics, for system administrators
+* |/redmine help| - Show this help`
+)
+
+var (
+	errRedmineUserNotFound = errors.New("no Redmine account matches your email, connect yours with `/redmine connect`")
+	errEmailNotVerified    = errors.New("your email is not verified, connect your Redmine account with `/redmine connect`")
+)
+
+func getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Redmine",
+		Description:      "Look up Redmine issues.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: view, search, mine, create, connect, disconnect, subscribe, unsubscribe, cache, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAutocompleteData(),
+	}
+}
+
+func getAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: view, search, mine, create, connect, disconnect, subscribe, unsubscribe, cache, help")
+
+	view := model.NewAutocompleteData("view", "[issue]", "Show an issue")
+	view.AddTextArgument("Issue ID, URL or short reference, e.g. 1234", "[issue]", "")
+	command.AddCommand(view)
+
+	search := model.NewAutocompleteData("search", "[text]", "Search issues by subject")
+	search.AddTextArgument("Text the issue subject contains", "[text]", "")
+	command.AddCommand(search)
+
+	command.AddCommand(model.NewAutocompleteData("mine", "", "List the open issues assigned to you"))
+
+	create := model.NewAutocompleteData("create", "[project]", "Create an issue")
+	create.AddTextArgument("Identifier of the project to preselect", "[project]", "")
+	command.AddCommand(create)
+
+	connect := model.NewAutocompleteData("connect", "[instance]", "Link your Redmine account")
+	connect.AddTextArgument("URL or label of the instance, the first one by default", "[instance]", "")
+	command.AddCommand(connect)
+
+	disconnect := model.NewAutocompleteData("disconnect", "[instance]", "Unlink your Redmine account")
+	disconnect.AddTextArgument("URL or label of the instance, the first one by default", "[instance]", "")
+	command.AddCommand(disconnect)
+
+	subscribe := model.NewAutocompleteData("subscribe", "[project|list]", "Post the issue changes of a project into this channel")
+	subscribe.AddTextArgument("Project identifier, followed by --tracker, --status, --priority or --instance filters, or list", "[project] [--tracker=Bug] [--status=closed] [--priority=High]", "")
+	command.AddCommand(subscribe)
+
+	unsubscribe := model.NewAutocompleteData("unsubscribe", "[project]", "Stop posting the issue changes of a project")
+	unsubscribe.AddTextArgument("Project identifier, optionally followed by --instance", "[project]", "")
+	command.AddCommand(unsubscribe)
+
+	cache := model.NewAutocompleteData("cache", "", "Show the issue cache statistics")
+	cache.RoleID = model.SystemAdminRoleId
+	command.AddCommand(cache)
+
+	command.AddCommand(model.NewAutocompleteData("help", "", "Show help"))
+
+	return command
+}
+
+// ExecuteCommand handles the /redmine slash command. All replies are ephemeral.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return nil, model.NewAppError("ExecuteCommand", "plugin.command.unknown", nil, "unknown command "+args.Command, 400)
+	}
+
+	subcommand := ""
+	if len(fields) > 1 {
+		subcommand = fields[1]
+	}
+	parameters := []string{}
+	if len(fields) > 2 {
+		parameters = fields[2:]
+	}
+
+	switch subcommand {
+	case "view":
+		return p.executeViewCommand(args, parameters), nil
+	case "search":
+		return p.executeSearchCommand(args, parameters), nil
+	case "mine":
+		return p.executeMineCommand(args), nil
+	case "create":
+		return p.executeCreateCommand(args, parameters), nil
+	case "connect":
+		return p.executeConnectCommand(args, parameters), nil
+	case "disconnect":
+		return p.executeDisconnectCommand(args, parameters), nil
+	case "subscribe":
+		return p.executeSubscribeCommand(args, parameters), nil
+	case "unsubscribe":
+		return p.executeUnsubscribeCommand(args, parameters), nil
+	case "cache":
+		return p.executeCacheCommand(args), nil
+	case "", "help":
+		return ephemeralResponse(getHelpText()), nil
+	default:
+		return ephemeralResponse(fmt.Sprintf("Unknown command `%s`.\n%s", subcommand, getHelpText())), nil
+	}
+}
+
+func getHelpText() string {
+	return "###### Redmine Slash Command Help\n" + strings.ReplaceAll(commandHelp, "|", "`")
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+func (p *Plugin) executeViewCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	if len(parameters) != 1 {
+		return ephemeralResponse("Please specify an issue, e.g. `/redmine view 1234`.")
+	}
+
+	scope := p.newChannelScope(args.ChannelId)
+	instances := p.getInstancesForScope(scope)
+	if len(instances) == 0 {
+		return ephemeralResponse("No Redmine instance is configured for this channel.")
+	}
+
+	// The issue may be given as a link or a short reference of any instance, or as an ID of
+	// the first one.
+	reference := parameters[0]
+	references := p.findIssueReferences(reference, scope)
+	if len(references) != 1 || references[0].start != 0 || references[0].end != len(reference) {
+		issueID := strings.TrimPrefix(reference, "#")
+		if _, err := strconv.Atoi(issueID); err != nil {
+			return ephemeralResponse(fmt.Sprintf("`%s` is not a valid issue ID.", reference))
+		}
+
+		redmineURL, _ := getRedmineInstanceURL(instances[0].URL)
+		references = []issueReference{{
+			end:      len(reference),
+			text:     reference,
+			issueID:  issueID,
+			url:      redmineURL + "issues/" + issueID,
+			instance: instances[0],
+		}}
+	}
+
+	dates := p.getLiveDateFormatter(args.UserId)
+	text, issues := p.transformMessageLinks(reference, references, issueViewer{userID: args.UserId}, dates)
+	if len(issues) == 0 {
+		return ephemeralResponse(fmt.Sprintf("Issue `%s` was not found.", reference))
+	}
+
+	response := ephemeralResponse(text)
+	response.Attachments = []*model.SlackAttachment{issueAttachment(issues[0], dates)}
+	return response
+}
+
+// executeCreateCommand opens the create issue dialog. The webapp runs it with --post <post ID>
+// to create an issue from a message.
+func (p *Plugin) executeCreateCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	project, postID := "", ""
+	for i := 0; i < len(parameters); i++ {
+		switch {
+		case parameters[i] == "--post" && i+1 < len(parameters):
+			postID = parameters[i+1]
+			i++
+		case project == "":
+			project = parameters[i]
+		default:
+			return ephemeralResponse("Usage: `/redmine create [project]`.")
+		}
+	}
+
+	if text := p.openCreateIssueDialog(args, project, postID); text != "" {
+		return ephemeralResponse(text)
+	}
+
+	return &model.CommandResponse{}
+}
+
+func (p *Plugin) executeSearchCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	text := strings.Join(parameters, " ")
+	if text == "" {
+		return ephemeralResponse("Please specify the text to search for, e.g. `/redmine search login`.")
+	}
+
+	return p.listIssues(args, fmt.Sprintf("Issues matching **%s**", text), func(*userClient) (url.Values, error) {
+		return url.Values{
+			"subject":   {"~" + text},
+			"status_id": {"*"},
+			"sort":      {"updated_on:desc"},
+		}, nil
+	})
+}
+
+func (p *Plugin) executeMineCommand(args *model.CommandArgs) *model.CommandResponse {
+	user, appErr := p.API.GetUser(args.UserId)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get user", "user_id", args.UserId, "err", appErr.Error())
+		return ephemeralResponse("Failed to look up your Mattermost account.")
+	}
+
+	return p.listIssues(args, "Open issues assigned to you", func(client *userClient) (url.Values, error) {
+		// Linked accounts are queried as themselves, other users are looked up by verified email.
+		assignee := "me"
+		if client.account == nil {
+			redmineUser, err := findRedmineUser(context.Background(), client.Client, user)
+			if err != nil {
+				return nil, err
+			}
+			assignee = strconv.Itoa(redmineUser.ID)
+		}
+
+		return url.Values{
+			"assigned_to_id": {assignee},
+			"status_id":      {"o"},
+			"sort":           {"updated_on:desc"},
+		}, nil
+	})
+}
+
+// listIssues runs an issues.json query against every instance enabled in the channel and
+// lists the rendered issues.
+func (p *Plugin) listIssues(args *model.CommandArgs, title string, buildQuery func(*userClient) (url.Values, error)) *model.CommandResponse {
+	instances := p.getInstancesForScope(p.newChannelScope(args.ChannelId))
+	if len(instances) == 0 {
+		return ephemeralResponse("No Redmine instance is configured for this channel.")
+	}
+
+	dates := p.getLiveDateFormatter(args.UserId)
+	var lines []string
+	for _, instance := range instances {
+		if len(instances) > 1 {
+			lines = append(lines, "", "**"+instance.displayName()+"**")
+		}
+
+		issues, total, err := p.queryIssues(instance, args.UserId, buildQuery)
+		if err != nil {
+			p.API.LogWarn("Failed to list issues", "instance", instance.URL, "err", err.Error())
+			lines = append(lines, fmt.Sprintf("Failed to list issues of %s: %s.", instance.displayName(), err.Error()))
+			continue
+		}
+		if len(issues) == 0 {
+			lines = append(lines, "No issues found.")
+			continue
+		}
+
+		redmineURL, _ := getRedmineInstanceURL(instance.URL)
+		for _, issue := range issues {
+			link, err := p.renderIssueLink(issue, instance, fmt.Sprintf("%sissues/%d", redmineURL, issue.ID), "", nil, dates)
+			if err != nil {
+				p.API.LogWarn("Failed to render issue link", "issue_id", issue.ID, "err", err.Error())
+				continue
+			}
+			lines = append(lines, "- "+link)
+		}
+		if total > len(issues) {
+			lines = append(lines, fmt.Sprintf("…and %d more.", total-len(issues)))
+		}
+	}
+
+	return ephemeralResponse(title + ":\n" + strings.Join(lines, "\n"))
+}
+
+func (p *Plugin) queryIssues(instance *redmineInstance, userID string, buildQuery func(*userClient) (url.Values, error)) ([]redmine.Issue, int, error) {
+	client, err := p.getUserClient(instance, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query, err := buildQuery(client)
+	if err != nil {
+		return nil, 0, err
+	}
+	query.Set("limit", strconv.Itoa(commandResultLimit))
+
+	resp, err := client.ListIssues(context.Background(), query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if client.shared {
+		p.cacheIssues(client.BaseURL(), resp.Issues)
+	}
+
+	return resp.Issues, resp.TotalCount, nil
+}
+
+// executeCacheCommand shows the lookup counters of the issue cache to system administrators.
+func (p *Plugin) executeCacheCommand(args *model.CommandArgs) *model.CommandResponse {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return ephemeralResponse("Only system administrators can view the issue cache statistics.")
+	}
+	if p.issueCache == nil || !p.issueCache.enabled() {
+		return ephemeralResponse("The issue cache is disabled, see the **Issue Cache TTL** setting.")
+	}
+
+	stats := p.issueCache.Stats()
+	return ephemeralResponse(fmt.Sprintf("Since the plugin was activated, the issue cache had %d hits in memory, %d hits in the KV store, %d misses and %d evictions.",
+		stats.Hits, stats.KVHits, stats.Misses, stats.Evictions))
+}
+
+// findRedmineUser finds the Redmine account of a Mattermost user by email. Usernames are chosen
+// by the users themselves, so only a verified email is trusted. Listing users requires an
+// administrator API key.
+func findRedmineUser(ctx context.Context, client *redmine.Client, user *model.User) (*redmine.User, error) {
+	if user.Email == "" || !user.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	resp, err := client.ListUsers(ctx, url.Values{"name": {user.Email}})
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Users {
+		if strings.EqualFold(resp.Users[i].Mail, user.Email) {
+			return &resp.Users[i], nil
+		}
+	}
+
+	return nil, errRedmineUserNotFound
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestExecuteCommand(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.AddUser(redmine.User{ID: 5, Login: "jdoe", Firstname: "Jane", Lastname: "Doe", Mail: "jane@example.com"})
+	server.AddIssue(redmine.Issue{
+		ID:         12,
+		Tracker:    redmine.IssueProperty{Name: "Bug"},
+		Status:     redmine.Status{IssueProperty: redmine.IssueProperty{Name: "New"}},
+		AssignedTo: redmine.IssueProperty{ID: 5, Name: "Jane Doe"},
+		Subject:    "Login fails",
+	})
+	server.AddIssue(redmine.Issue{
+		ID:      34,
+		Tracker: redmine.IssueProperty{Name: "Feature"},
+		Status:  redmine.Status{IssueProperty: redmine.IssueProperty{Name: "New"}},
+		Subject: "Login with SSO",
+	})
+	server.AddIssue(redmine.Issue{
+		ID:         56,
+		Tracker:    redmine.IssueProperty{Name: "Bug"},
+		Status:     redmine.Status{IssueProperty: redmine.IssueProperty{Name: "Closed"}, IsClosed: true},
+		AssignedTo: redmine.IssueProperty{ID: 5, Name: "Jane Doe"},
+		Subject:    "Crash on logout",
+	})
+
+	api := &plugintest.API{}
+	api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "jane", Email: "jane@example.com", EmailVerified: true}, nil)
+	api.On("GetUser", "stranger-id").Return(&model.User{Id: "stranger-id", Username: "stranger", Email: "stranger@example.com", EmailVerified: true}, nil)
+	api.On("GetUser", "impostor-id").Return(&model.User{Id: "impostor-id", Username: "jdoe", Email: "impostor@example.com", EmailVerified: true}, nil)
+	api.On("GetUser", "unverified-id").Return(&model.User{Id: "unverified-id", Username: "jane", Email: "jane@example.com"}, nil)
+	api.On("LogWarn", "Failed to list issues", "instance", "https://redmine.example.com", "err", "no Redmine account matches your email, connect yours with `/redmine connect`")
+	api.On("LogWarn", "Failed to list issues", "instance", "https://redmine.example.com", "err", "your email is not verified, connect your Redmine account with `/redmine connect`")
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			TooltipTemplate:    "{{.Status.Name}}",
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(api)
+
+	for _, tc := range []struct {
+		Description  string
+		Command      string
+		UserID       string
+		ExpectedText string
+	}{
+		{
+			Description:  "View by ID",
+			Command:      "/redmine view 12",
+			ExpectedText: `[Bug#12: Login fails](https://redmine.example.com/issues/12 "New")`,
+		},
+		{
+			Description:  "View by hash ID",
+			Command:      "/redmine view #34",
+			ExpectedText: `[Feature#34: Login with SSO](https://redmine.example.com/issues/34 "New")`,
+		},
+		{
+			Description:  "View by URL",
+			Command:      "/redmine view https://redmine.example.com/issues/12#note-1",
+			ExpectedText: `[Bug#12: Login fails#note-1](https://redmine.example.com/issues/12#note-1 "New")`,
+		},
+		{
+			Description:  "View unknown issue",
+			Command:      "/redmine view 99",
+			ExpectedText: "Issue `99` was not found.",
+		},
+		{
+			Description:  "View invalid issue",
+			Command:      "/redmine view abc",
+			ExpectedText: "`abc` is not a valid issue ID.",
+		},
+		{
+			Description: "Search",
+			Command:     "/redmine search login",
+			ExpectedText: "Issues matching **login**:\n" +
+				`- [Bug#12: Login fails](https://redmine.example.com/issues/12 "New")` + "\n" +
+				`- [Feature#34: Login with SSO](https://redmine.example.com/issues/34 "New")`,
+		},
+		{
+			Description:  "Search without results",
+			Command:      "/redmine search printer",
+			ExpectedText: "Issues matching **printer**:\nNo issues found.",
+		},
+		{
+			Description: "Mine",
+			Command:     "/redmine mine",
+			UserID:      "user-id",
+			ExpectedText: "Open issues assigned to you:\n" +
+				`- [Bug#12: Login fails](https://redmine.example.com/issues/12 "New")`,
+		},
+		{
+			Description:  "Mine without a Redmine account",
+			Command:      "/redmine mine",
+			UserID:       "stranger-id",
+			ExpectedText: "Open issues assigned to you:\nFailed to list issues of https://redmine.example.com: no Redmine account matches your email, connect yours with `/redmine connect`.",
+		},
+		{
+			Description:  "Mine with the login of another Redmine account",
+			Command:      "/redmine mine",
+			UserID:       "impostor-id",
+			ExpectedText: "Open issues assigned to you:\nFailed to list issues of https://redmine.example.com: no Redmine account matches your email, connect yours with `/redmine connect`.",
+		},
+		{
+			Description:  "Mine with an unverified email",
+			Command:      "/redmine mine",
+			UserID:       "unverified-id",
+			ExpectedText: "Open issues assigned to you:\nFailed to list issues of https://redmine.example.com: your email is not verified, connect your Redmine account with `/redmine connect`.",
+		},
+		{
+			Description:  "Help",
+			Command:      "/redmine",
+			ExpectedText: getHelpText(),
+		},
+		{
+			Description:  "Unknown subcommand",
+			Command:      "/redmine frobnicate",
+			ExpectedText: "Unknown command `frobnicate`.\n" + getHelpText(),
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: tc.Command, UserId: tc.UserID})
+			require.Nil(t, appErr)
+			assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+			assert.Equal(t, tc.ExpectedText, response.Text)
+		})
+	}
+
+	t.Run("Cache statistics", func(t *testing.T) {
+		api.On("HasPermissionTo", "admin-id", model.PermissionManageSystem).Return(true)
+		api.On("HasPermissionTo", "user-id", model.PermissionManageSystem).Return(false)
+
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine cache", UserId: "user-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Only system administrators can view the issue cache statistics.", response.Text)
+
+		response, appErr = plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine cache", UserId: "admin-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "The issue cache is disabled, see the **Issue Cache TTL** setting.", response.Text)
+
+		plugin.issueCache = newIssueCache(nil, issueCacheCapacity, time.Minute)
+		defer func() { plugin.issueCache = nil }()
+		plugin.issueCache.Get("https://redmine.example.com", 12)
+		response, appErr = plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine cache", UserId: "admin-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, "Since the plugin was activated, the issue cache had 0 hits in memory, 0 hits in the KV store, 1 misses and 0 evictions.", response.Text)
+	})
+
+	t.Run("View attaches the issue card", func(t *testing.T) {
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine view 12"})
+		require.Nil(t, appErr)
+		require.Len(t, response.Attachments, 1)
+		assert.Equal(t, "Bug #12: Login fails", response.Attachments[0].Title)
+	})
+}
+
+func TestGetAutocompleteData(t *testing.T) {
+	command := getCommand()
+	require.NoError(t, command.AutocompleteData.IsValid())
+
+	var subcommands []string
+	for _, subcommand := range command.AutocompleteData.SubCommands {
+		subcommands = append(subcommands, subcommand.Trigger)
+	}
+	assert.Equal(t, []string{"view", "search", "mine", "create", "connect", "disconnect", "subscribe", "unsubscribe", "cache", "help"}, subcommands)
+}
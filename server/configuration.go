@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -18,8 +19,39 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	RedmineAPIKey      string
-	RedmineInstanceURL string
+	RedmineAPIKey            string
+	RedmineInstanceURL       string
+	RedmineOAuthClientID     string
+	RedmineOAuthClientSecret string
+	RedmineInstances         string
+	RedmineInstanceSecrets   string
+	IssueCacheTTLMinutes     int
+	DisplayTimezone          string
+	UsePosterTimezone        bool
+	DateFormat               string
+	RelativeDates            bool
+	LinkTextTemplate         string
+	TooltipTemplate          string
+	LinkDisplayMode          string
+	MaxAttachments           int
+	ShortReferences          string
+	EncryptionKey            string
+	AllowGlobalAPIKey        bool
+	IssueDetailPolicy        string
+	IssueDetailChannels      string
+	WebhookSecret            string
+	SubscriptionPollMinutes  int
+	RefreshPosts             bool
+
+	// instances is computed from RedmineInstanceURL, RedmineAPIKey, RedmineInstances and
+	// RedmineInstanceSecrets.
+	instances []*redmineInstance
+
+	// shortReferences is parsed from ShortReferences.
+	shortReferences []*shortReference
+
+	// linkTemplates is parsed from LinkTextTemplate and TooltipTemplate.
+	linkTemplates *linkTemplates
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -29,6 +61,31 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// issueCacheTTL returns how long fetched issues are cached. Zero disables the cache.
+func (c *configuration) issueCacheTTL() time.Duration {
+	if c.IssueCacheTTLMinutes <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.IssueCacheTTLMinutes) * time.Minute
+}
+
+// showInlineLinks reports whether issue links in messages are rewritten.
+func (c *configuration) showInlineLinks() bool {
+	return c.LinkDisplayMode != linkDisplayAttachments && c.LinkDisplayMode != linkDisplayPreview
+}
+
+// showPreviews reports whether issue links are rendered by the webapp when posts are displayed,
+// leaving the message untouched.
+func (c *configuration) showPreviews() bool {
+	return c.LinkDisplayMode == linkDisplayPreview
+}
+
+// showAttachments reports whether cards are attached to posts for referenced issues.
+func (c *configuration) showAttachments() bool {
+	return c.LinkDisplayMode == linkDisplayAttachments || c.LinkDisplayMode == linkDisplayBoth
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -79,7 +136,47 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	instances, err := configuration.parseInstances()
+	if err != nil {
+		return errors.Wrap(err, "invalid Redmine instances configuration")
+	}
+	configuration.instances = instances
+
+	shortReferences, err := configuration.parseShortReferences(instances)
+	if err != nil {
+		return errors.Wrap(err, "invalid short references configuration")
+	}
+	configuration.shortReferences = shortReferences
+
+	if _, err := time.LoadLocation(configuration.DisplayTimezone); err != nil {
+		return errors.Wrapf(err, "invalid display timezone %q", configuration.DisplayTimezone)
+	}
+
+	switch configuration.LinkDisplayMode {
+	case "", linkDisplayInline, linkDisplayAttachments, linkDisplayBoth, linkDisplayPreview:
+	default:
+		return errors.Errorf("invalid link display mode %q", configuration.LinkDisplayMode)
+	}
+
+	switch configuration.IssueDetailPolicy {
+	case "", issueDetailsAll, issueDetailsHidePrivate, issueDetailsLinkedAccount, issueDetailsChannelAllowlist:
+	default:
+		return errors.Errorf("invalid issue detail policy %q", configuration.IssueDetailPolicy)
+	}
+
+	linkTemplates, err := parseLinkTemplates(configuration.LinkTextTemplate, configuration.TooltipTemplate)
+	if err != nil {
+		return errors.Wrap(err, "invalid link templates")
+	}
+	configuration.linkTemplates = linkTemplates
+
+	previous := p.getConfiguration()
 	p.setConfiguration(configuration)
 
+	// Changing the TTL drops the cached issues, so other settings keep them.
+	if p.issueCache != nil && configuration.issueCacheTTL() != previous.issueCacheTTL() {
+		p.issueCache.setTTL(configuration.issueCacheTTL())
+	}
+
 	return nil
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const connectCallbackID = "connect"
+
+// connectState is kept in the connect dialog between opening and submitting it.
+type connectState struct {
+	// Instance is the URL of the instance the account is linked on.
+	Instance string `json:"instance"`
+}
+
+// commandInstance returns the instance named by the first parameter, or the first instance
+// enabled in the channel.
+func (p *Plugin) commandInstance(args *model.CommandArgs, parameters []string) (*redmineInstance, string) {
+	if len(parameters) > 0 {
+		instance := findInstance(p.getConfiguration().getInstances(), parameters[0])
+		if instance == nil {
+			return nil, fmt.Sprintf("Redmine instance `%s` is not configured.", parameters[0])
+		}
+		return instance, ""
+	}
+
+	instances := p.getInstancesForScope(p.newChannelScope(args.ChannelId))
+	if len(instances) == 0 {
+		return nil, "No Redmine instance is configured for this channel."
+	}
+
+	return instances[0], ""
+}
+
+// executeConnectCommand opens the dialog asking for the personal API key of the user, or
+// links to the OAuth2 authorization when the instance has an OAuth2 application.
+func (p *Plugin) executeConnectCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	instance, text := p.commandInstance(args, parameters)
+	if instance == nil {
+		return ephemeralResponse(text)
+	}
+	if p.getConfiguration().EncryptionKey == "" {
+		return ephemeralResponse("Linking Redmine accounts is not available: no encryption key is configured.")
+	}
+
+	if instance.oauthEnabled() {
+		connectURL, err := p.oauthConnectURL(instance)
+		if err != nil {
+			return ephemeralResponse("Linking Redmine accounts is not available: " + err.Error() + ".")
+		}
+		return ephemeralResponse(fmt.Sprintf("[Click here to connect your Redmine account](%s) on %s.", connectURL, instance.displayName()))
+	}
+
+	state, err := json.Marshal(connectState{Instance: instance.URL})
+	if err != nil {
+		return ephemeralResponse("Failed to open the dialog.")
+	}
+
+	redmineURL, _ := getRedmineInstanceURL(instance.URL)
+	dialog := model.Dialog{
+		CallbackId: connectCallbackID,
+		Title:      "Connect Redmine account",
+		IntroductionText: fmt.Sprintf("Paste the API access key shown on [your account page](%smy/account) of %s. "+
+			"It is stored encrypted and used for your lookups and actions.", redmineURL, instance.displayName()),
+		Elements: []model.DialogElement{
+			{DisplayName: "API key", Name: "api_key", Type: "text", SubType: "password"},
+		},
+		SubmitLabel: "Connect",
+		State:       string(state),
+	}
+
+	if appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: args.TriggerId,
+		URL:       pluginURL(routeConnectDialog),
+		Dialog:    dialog,
+	}); appErr != nil {
+		p.API.LogWarn("Failed to open the connect dialog", "err", appErr.Error())
+		return ephemeralResponse("Failed to open the dialog.")
+	}
+
+	return &model.CommandResponse{}
+}
+
+// executeDisconnectCommand unlinks the Redmine account of the user.
+func (p *Plugin) executeDisconnectCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	instance, text := p.commandInstance(args, parameters)
+	if instance == nil {
+		return ephemeralResponse(text)
+	}
+
+	account, err := p.disconnectUserAccount(args.UserId, instance)
+	if err != nil {
+		p.API.LogWarn("Failed to disconnect Redmine account", "user_id", args.UserId, "instance", instance.URL, "err", err.Error())
+		return ephemeralResponse("Failed to disconnect your Redmine account.")
+	}
+	if account == nil {
+		return ephemeralResponse(fmt.Sprintf("No Redmine account is connected on %s.", instance.displayName()))
+	}
+
+	return ephemeralResponse(fmt.Sprintf("Your Redmine account %s was disconnected from %s.", account.Login, instance.displayName()))
+}
+
+// handleConnectDialog verifies the submitted API key against Redmine and links the account
+// it belongs to.
+func (p *Plugin) handleConnectDialog(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.UserId != r.Header.Get("Mattermost-User-ID") {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		return
+	}
+
+	var state connectState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+	instance := findInstance(p.getConfiguration().getInstances(), state.Instance)
+	if instance == nil || state.Instance == "" {
+		writeJSON(w, model.SubmitDialogResponse{Error: "The Redmine instance is no longer configured."})
+		return
+	}
+
+	apiKey := strings.TrimSpace(submissionString(request.Submission, "api_key"))
+	if apiKey == "" {
+		writeJSON(w, model.SubmitDialogResponse{Errors: map[string]string{"api_key": "API key cannot be blank."}})
+		return
+	}
+
+	client, err := p.newRedmineClient(instance, apiKey)
+	if err != nil {
+		writeJSON(w, model.SubmitDialogResponse{Error: err.Error()})
+		return
+	}
+	user, err := client.GetCurrentUser(r.Context())
+	if errors.Is(err, redmine.ErrUnauthorized) {
+		writeJSON(w, model.SubmitDialogResponse{Errors: map[string]string{"api_key": "Redmine rejected the API key."}})
+		return
+	}
+	if err != nil {
+		p.API.LogWarn("Failed to verify Redmine API key", "instance", instance.URL, "err", err.Error())
+		writeJSON(w, model.SubmitDialogResponse{Error: "Failed to verify the API key: " + err.Error()})
+		return
+	}
+
+	account := newUserAccount(instance, user)
+	err = account.setAPIKey(p.getConfiguration().EncryptionKey, apiKey)
+	if err == nil {
+		err = p.saveUserAccount(request.UserId, account)
+	}
+	if err != nil {
+		p.API.LogWarn("Failed to save Redmine account", "user_id", request.UserId, "instance", instance.URL, "err", err.Error())
+		writeJSON(w, model.SubmitDialogResponse{Error: "Failed to save your Redmine account."})
+		return
+	}
+
+	p.API.SendEphemeralPost(request.UserId, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: request.ChannelId,
+		Message:   fmt.Sprintf("Your Redmine account %s (%s) is now connected to %s.", user.Name(), user.Login, instance.displayName()),
+	})
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	createIssueCallbackID = "create_issue"
+
+	// Limits of Mattermost interactive dialog fields.
+	dialogTextMaxLength     = 150
+	dialogTextareaMaxLength = 3000
+
+	subjectMaxLength = 255
+
+	connectRequiredMessage = "Connect your Redmine account with `/redmine connect` to create issues."
+)
+
+// createIssueState is kept in the dialog between opening and submitting it.
+type createIssueState struct {
+	// Instance is the URL of the instance the issue is created in.
+	Instance string `json:"instance"`
+	// RootID is the thread the created issue is announced in.
+	RootID string `json:"root_id,omitempty"`
+	// Project is the ID of the preselected project, whose members are listed as assignees.
+	Project string `json:"project,omitempty"`
+}
+
+// openCreateIssueDialog opens the dialog creating an issue in the first instance enabled in
+// the channel. The project is preselected when given, and the dialog is prefilled from the
+// post with the given ID, if any.
+func (p *Plugin) openCreateIssueDialog(args *model.CommandArgs, project, postID string) string {
+	instances := p.getInstancesForScope(p.newChannelScope(args.ChannelId))
+	if len(instances) == 0 {
+		return "No Redmine instance is configured for this channel."
+	}
+	instance := instances[0]
+
+	client, err := p.getUserClient(instance, args.UserId)
+	if err != nil {
+		return err.Error()
+	}
+	if !client.authenticated {
+		return connectRequiredMessage
+	}
+
+	state := createIssueState{Instance: instance.URL, RootID: args.RootId}
+	var post *model.Post
+	if postID != "" {
+		var appErr *model.AppError
+		post, appErr = p.API.GetPost(postID)
+		if appErr != nil || !p.API.HasPermissionToChannel(args.UserId, post.ChannelId, model.PermissionReadChannel) {
+			return "The message was not found."
+		}
+
+		state.RootID = post.Id
+		if post.RootId != "" {
+			state.RootID = post.RootId
+		}
+	}
+
+	dialog, projectID, err := p.buildCreateIssueDialog(client.Client, project, post)
+	if err != nil {
+		p.API.LogWarn("Failed to build the create issue dialog", "instance", instance.URL, "err", err.Error())
+		return fmt.Sprintf("Failed to load the projects of %s: %s.", instance.displayName(), err.Error())
+	}
+
+	state.Project = projectID
+	encodedState, err := json.Marshal(state)
+	if err != nil {
+		return "Failed to open the dialog."
+	}
+	dialog.State = string(encodedState)
+
+	if appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: args.TriggerId,
+		URL:       pluginURL(routeCreateIssueDialog),
+		Dialog:    *dialog,
+	}); appErr != nil {
+		p.API.LogWarn("Failed to open the create issue dialog", "err", appErr.Error())
+		return "Failed to open the dialog."
+	}
+
+	return ""
+}
+
+// buildCreateIssueDialog builds the dialog with the projects, trackers and priorities visible to
+// the client. The members of the preselected project, if any, are listed as assignees, since
+// dialog options cannot follow the project chosen in the dialog. It also returns the ID of the
+// preselected project.
+func (p *Plugin) buildCreateIssueDialog(client *redmine.Client, projectIdentifier string, post *model.Post) (*model.Dialog, string, error) {
+	ctx := context.Background()
+
+	projects, err := client.ListAllProjects(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(projects) == 0 {
+		return nil, "", errors.New("no projects are visible")
+	}
+
+	project, projectName := "", ""
+	projectOptions := make([]*model.PostActionOptions, 0, len(projects))
+	for _, candidate := range projects {
+		value := strconv.Itoa(candidate.ID)
+		if projectIdentifier != "" && (candidate.Identifier == projectIdentifier || value == projectIdentifier) {
+			project, projectName = value, candidate.Name
+		}
+		projectOptions = append(projectOptions, &model.PostActionOptions{Text: candidate.Name, Value: value})
+	}
+	if projectIdentifier != "" && project == "" {
+		return nil, "", fmt.Errorf("project %q was not found", projectIdentifier)
+	}
+
+	trackers, err := client.ListTrackers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	tracker := ""
+	trackerOptions := make([]*model.PostActionOptions, 0, len(trackers))
+	for _, candidate := range trackers {
+		if tracker == "" {
+			tracker = strconv.Itoa(candidate.ID)
+		}
+		trackerOptions = append(trackerOptions, &model.PostActionOptions{Text: candidate.Name, Value: strconv.Itoa(candidate.ID)})
+	}
+
+	priorities, err := client.ListIssuePriorities(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	priority := ""
+	priorityOptions := make([]*model.PostActionOptions, 0, len(priorities))
+	for _, candidate := range priorities {
+		if candidate.IsDefault {
+			priority = strconv.Itoa(candidate.ID)
+		}
+		priorityOptions = append(priorityOptions, &model.PostActionOptions{Text: candidate.Name, Value: strconv.Itoa(candidate.ID)})
+	}
+
+	subject, description := "", ""
+	if post != nil {
+		description = truncate(dialogTextareaMaxLength, post.Message)
+		subject, _, _ = strings.Cut(strings.TrimSpace(post.Message), "\n")
+		subject = truncate(dialogTextMaxLength, subject)
+	}
+
+	projectHelp := ""
+	if project == "" {
+		projectHelp = "Run `/redmine create <project>` to assign the issue to a member of the project."
+	}
+	elements := []model.DialogElement{
+		{DisplayName: "Project", Name: "project_id", Type: "select", Default: project, Options: projectOptions, HelpText: projectHelp},
+		{DisplayName: "Tracker", Name: "tracker_id", Type: "select", Default: tracker, Options: trackerOptions},
+		{DisplayName: "Subject", Name: "subject", Type: "text", Default: subject, MaxLength: dialogTextMaxLength},
+		{DisplayName: "Description", Name: "description", Type: "textarea", Default: description, Optional: true, MaxLength: dialogTextareaMaxLength},
+		{DisplayName: "Priority", Name: "priority_id", Type: "select", Default: priority, Options: priorityOptions, Optional: true},
+	}
+	if assigneeOptions := p.listAssigneeOptions(ctx, client, project); len(assigneeOptions) > 0 {
+		elements = append(elements, model.DialogElement{
+			DisplayName: "Assignee",
+			Name:        "assigned_to_id",
+			Type:        "select",
+			Options:     assigneeOptions,
+			Optional:    true,
+			HelpText:    fmt.Sprintf("Members of %s.", projectName),
+		})
+	}
+
+	return &model.Dialog{
+		CallbackId:  createIssueCallbackID,
+		Title:       "Create Redmine issue",
+		Elements:    elements,
+		SubmitLabel: "Create",
+	}, project, nil
+}
+
+// listAssigneeOptions lists the members of the project, if any. Errors only leave the assignee
+// out of the dialog.
+func (p *Plugin) listAssigneeOptions(ctx context.Context, client *redmine.Client, project string) []*model.PostActionOptions {
+	if project == "" {
+		return nil
+	}
+
+	memberships, err := client.ListAllMemberships(ctx, project)
+	if err != nil {
+		p.API.LogDebug("Failed to list project members", "project", project, "err", err.Error())
+		return nil
+	}
+
+	var options []*model.PostActionOptions
+	for _, membership := range memberships {
+		if membership.User != nil {
+			options = append(options, &model.PostActionOptions{Text: membership.User.Name, Value: strconv.Itoa(membership.User.ID)})
+		}
+	}
+
+	return options
+}
+
+// handleCreateIssueDialog creates the issue submitted through the dialog and announces it in
+// the channel.
+func (p *Plugin) handleCreateIssueDialog(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.UserId != r.Header.Get("Mattermost-User-ID") {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		return
+	}
+
+	var state createIssueState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil {
+		http.Error(w, "Invalid dialog state", http.StatusBadRequest)
+		return
+	}
+	instance := findInstance(p.getConfiguration().getInstances(), state.Instance)
+	if instance == nil || state.Instance == "" {
+		writeJSON(w, model.SubmitDialogResponse{Error: "The Redmine instance is no longer configured."})
+		return
+	}
+	if message := p.checkAnnouncementTarget(request.UserId, request.ChannelId, state.RootID); message != "" {
+		writeJSON(w, model.SubmitDialogResponse{Error: message})
+		return
+	}
+
+	newIssue := redmine.NewIssue{
+		ProjectID:    submissionInt(request.Submission, "project_id"),
+		TrackerID:    submissionInt(request.Submission, "tracker_id"),
+		PriorityID:   submissionInt(request.Submission, "priority_id"),
+		AssignedToID: submissionInt(request.Submission, "assigned_to_id"),
+		Subject:      strings.TrimSpace(submissionString(request.Submission, "subject")),
+		Description:  submissionString(request.Submission, "description"),
+	}
+	if newIssue.Subject == "" {
+		writeJSON(w, model.SubmitDialogResponse{Errors: map[string]string{"subject": "Subject cannot be blank."}})
+		return
+	}
+	if newIssue.AssignedToID != 0 && strconv.Itoa(newIssue.ProjectID) != state.Project {
+		writeJSON(w, model.SubmitDialogResponse{Errors: map[string]string{"assigned_to_id": "The assignee is a member of another project. Clear it, or run `/redmine create <project>` to list the members of the chosen project."}})
+		return
+	}
+	if len([]rune(newIssue.Subject)) > subjectMaxLength {
+		writeJSON(w, model.SubmitDialogResponse{Errors: map[string]string{"subject": fmt.Sprintf("Subject cannot be longer than %d characters.", subjectMaxLength)}})
+		return
+	}
+
+	client, err := p.getUserClient(instance, request.UserId)
+	if err != nil {
+		writeJSON(w, model.SubmitDialogResponse{Error: err.Error()})
+		return
+	}
+	if !client.authenticated {
+		writeJSON(w, model.SubmitDialogResponse{Error: connectRequiredMessage})
+		return
+	}
+	issue, err := client.CreateIssue(r.Context(), newIssue)
+	if err != nil {
+		var apiErr *redmine.APIError
+		if errors.As(err, &apiErr) && len(apiErr.Errors) > 0 {
+			writeJSON(w, model.SubmitDialogResponse{Error: strings.Join(apiErr.Errors, "\n")})
+			return
+		}
+
+		p.API.LogWarn("Failed to create issue", "instance", instance.URL, "err", err.Error())
+		writeJSON(w, model.SubmitDialogResponse{Error: "Failed to create the issue: " + err.Error()})
+		return
+	}
+	if client.shared {
+		p.cacheIssues(client.BaseURL(), []redmine.Issue{*issue})
+	}
+
+	p.announceCreatedIssue(request, state, instance, *issue)
+}
+
+// checkAnnouncementTarget returns an error message unless the user may post in the channel the
+// created issue is announced in, and the thread root sent back in the dialog state belongs to it.
+func (p *Plugin) checkAnnouncementTarget(userID, channelID, rootID string) string {
+	if channelID == "" || !p.API.HasPermissionToChannel(userID, channelID, model.PermissionCreatePost) {
+		return "You cannot post in this channel."
+	}
+	if rootID == "" {
+		return ""
+	}
+
+	root, appErr := p.API.GetPost(rootID)
+	if appErr != nil || root.ChannelId != channelID {
+		return "The message the issue is created from is no longer available."
+	}
+
+	return ""
+}
+
+// announceCreatedIssue replies in the thread the dialog was opened from with a link to the
+// created issue. The link is rendered like the links the user posts in the channel, within the
+// limits of the Issue Detail Policy, and is left bare when the policy hides the issue.
+func (p *Plugin) announceCreatedIssue(request model.SubmitDialogRequest, state createIssueState, instance *redmineInstance, issue redmine.Issue) {
+	redmineURL, _ := getRedmineInstanceURL(instance.URL)
+	link := fmt.Sprintf("%sissues/%d", redmineURL, issue.ID)
+	if viewer, ok := p.postViewer(request.UserId, p.newChannelScope(request.ChannelId)); ok {
+		reference := issueReference{
+			end:      len(link),
+			text:     link,
+			issueID:  strconv.Itoa(issue.ID),
+			url:      link,
+			instance: instance,
+		}
+		link, _ = p.transformMessageLinks(link, []issueReference{reference}, viewer, p.getDateFormatter(request.UserId))
+	}
+
+	message := "Created " + link
+	if user, appErr := p.API.GetUser(request.UserId); appErr == nil {
+		message = fmt.Sprintf("@%s created %s", user.Username, link)
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: request.ChannelId,
+		RootId:    state.RootID,
+		Message:   message,
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.API.LogWarn("Failed to announce the created issue", "issue_id", issue.ID, "err", appErr.Error())
+	}
+}
+
+func submissionString(submission map[string]any, name string) string {
+	value, _ := submission[name].(string)
+	return value
+}
+
+func submissionInt(submission map[string]any, name string) int {
+	value, _ := strconv.Atoi(submissionString(submission, name))
+	return value
+}
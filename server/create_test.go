@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func newCreateIssueTestServer(t *testing.T) *redminetest.Server {
+	server := redminetest.NewServer(t)
+	server.AddProject(redmine.Project{ID: 1, Name: "Website", Identifier: "website"})
+	server.AddProject(redmine.Project{ID: 2, Name: "Mobile", Identifier: "mobile"})
+	server.AddTracker(redmine.Tracker{ID: 1, Name: "Bug"})
+	server.AddTracker(redmine.Tracker{ID: 2, Name: "Feature"})
+	server.AddPriority(redmine.Enumeration{ID: 3, Name: "Low"})
+	server.AddPriority(redmine.Enumeration{ID: 4, Name: "Normal", IsDefault: true})
+	server.AddUser(redmine.User{ID: 5, Login: "jdoe", Firstname: "Jane", Lastname: "Doe"})
+	server.AddMembership(redmine.Membership{ID: 1, Project: redmine.IssueProperty{ID: 2}, User: &redmine.IssueProperty{ID: 5, Name: "Jane Doe"}})
+	server.SetCurrentUser(5)
+	server.SetAPIKey("key")
+
+	return server
+}
+
+func TestCreateIssueDialog(t *testing.T) {
+	server := newCreateIssueTestServer(t)
+
+	api := &plugintest.API{}
+	api.On("GetPost", "post-id").Return(&model.Post{Id: "post-id", ChannelId: "channel-id", RootId: "root-id", Message: "Export fails\nStack trace follows"}, nil)
+	api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionReadChannel).Return(true)
+	var opened model.OpenDialogRequest
+	api.On("OpenInteractiveDialog", mock.AnythingOfType("model.OpenDialogRequest")).Run(func(args mock.Arguments) {
+		opened = args.Get(0).(model.OpenDialogRequest)
+	}).Return(nil)
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			RedmineAPIKey:      "key",
+			AllowGlobalAPIKey:  true,
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(api)
+
+	t.Run("From a message", func(t *testing.T) {
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{
+			Command:   "/redmine create mobile --post post-id",
+			UserId:    "user-id",
+			ChannelId: "channel-id",
+			TriggerId: "trigger-id",
+		})
+		require.Nil(t, appErr)
+		assert.Empty(t, response.Text)
+
+		assert.Equal(t, "trigger-id", opened.TriggerId)
+		assert.Equal(t, "/plugins/"+manifest.Id+"/api/v1/dialog/create", opened.URL)
+		assert.JSONEq(t, `{"instance": "https://redmine.example.com", "root_id": "root-id", "project": "2"}`, opened.Dialog.State)
+
+		elements := map[string]model.DialogElement{}
+		for _, element := range opened.Dialog.Elements {
+			elements[element.Name] = element
+		}
+		assert.Equal(t, "2", elements["project_id"].Default)
+		assert.Len(t, elements["project_id"].Options, 2)
+		assert.Equal(t, "1", elements["tracker_id"].Default)
+		assert.Equal(t, "Export fails", elements["subject"].Default)
+		assert.Equal(t, "Export fails\nStack trace follows", elements["description"].Default)
+		assert.Equal(t, "4", elements["priority_id"].Default)
+		assert.Equal(t, []*model.PostActionOptions{{Text: "Jane Doe", Value: "5"}}, elements["assigned_to_id"].Options)
+	})
+
+	t.Run("Without a project", func(t *testing.T) {
+		for i := 3; i <= redmine.MaxPageSize+20; i++ {
+			server.AddProject(redmine.Project{ID: i, Name: fmt.Sprintf("Project %d", i), Identifier: fmt.Sprintf("project-%d", i)})
+		}
+
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine create", UserId: "user-id", TriggerId: "trigger-id"})
+		require.Nil(t, appErr)
+		assert.Empty(t, response.Text)
+		assert.JSONEq(t, `{"instance": "https://redmine.example.com"}`, opened.Dialog.State)
+
+		elements := map[string]model.DialogElement{}
+		for _, element := range opened.Dialog.Elements {
+			elements[element.Name] = element
+		}
+		assert.Len(t, elements["project_id"].Options, redmine.MaxPageSize+20)
+		assert.Empty(t, elements["project_id"].Default)
+		assert.NotContains(t, elements, "assigned_to_id")
+	})
+
+	t.Run("Unknown project", func(t *testing.T) {
+		api.On("LogWarn", "Failed to build the create issue dialog", "instance", "https://redmine.example.com", "err", `project "intranet" was not found`).Once()
+
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine create intranet", UserId: "user-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, `Failed to load the projects of https://redmine.example.com: project "intranet" was not found.`, response.Text)
+	})
+
+	t.Run("Without credentials", func(t *testing.T) {
+		anonymous := &Plugin{
+			configuration: &configuration{RedmineInstanceURL: "https://redmine.example.com", RedmineAPIKey: "key"},
+			httpClient:    server.HTTPClient(),
+		}
+		anonymous.SetAPI(api)
+
+		response, appErr := anonymous.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine create", UserId: "user-id"})
+		require.Nil(t, appErr)
+		assert.Equal(t, connectRequiredMessage, response.Text)
+	})
+}
+
+func TestHandleCreateIssueDialog(t *testing.T) {
+	server := newCreateIssueTestServer(t)
+
+	api := &plugintest.API{}
+	api.On("GetUser", "user-id").Return(&model.User{Id: "user-id", Username: "jane"}, nil)
+	api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionCreatePost).Return(true)
+	api.On("HasPermissionToChannel", "user-id", "other-channel-id", model.PermissionCreatePost).Return(false)
+	api.On("GetPost", "root-id").Return(&model.Post{Id: "root-id", ChannelId: "channel-id"}, nil)
+	api.On("GetPost", "foreign-root-id").Return(&model.Post{Id: "foreign-root-id", ChannelId: "private-channel-id"}, nil)
+	var created *model.Post
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		created = args.Get(0).(*model.Post)
+	}).Return(&model.Post{}, nil)
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			RedmineAPIKey:      "key",
+			AllowGlobalAPIKey:  true,
+			TooltipTemplate:    "{{.Status.Name}}",
+		},
+		httpClient: server.HTTPClient(),
+		botUserID:  "bot-id",
+	}
+	plugin.SetAPI(api)
+
+	submit := func(userID string, request model.SubmitDialogRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, routeCreateIssueDialog, bytes.NewReader(body))
+		if userID != "" {
+			r.Header.Set("Mattermost-User-ID", userID)
+		}
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+	request := model.SubmitDialogRequest{
+		UserId:    "user-id",
+		ChannelId: "channel-id",
+		State:     `{"instance": "https://redmine.example.com", "root_id": "root-id", "project": "2"}`,
+		Submission: map[string]any{
+			"project_id":     "2",
+			"tracker_id":     "1",
+			"subject":        "Export fails",
+			"description":    "Stack trace follows",
+			"priority_id":    "4",
+			"assigned_to_id": "5",
+		},
+	}
+
+	t.Run("Creates the issue and replies in the thread", func(t *testing.T) {
+		w := submit("user-id", request)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String())
+
+		require.NotNil(t, created)
+		assert.Equal(t, &model.Post{
+			UserId:    "bot-id",
+			ChannelId: "channel-id",
+			RootId:    "root-id",
+			Message:   `@jane created [Bug#1: Export fails](https://redmine.example.com/issues/1 "New")`,
+		}, created)
+	})
+
+	t.Run("Private issue hidden by the Issue Detail Policy", func(t *testing.T) {
+		server.SetPrivateIssues(true)
+		plugin.configuration.IssueDetailPolicy = issueDetailsHidePrivate
+		defer func() {
+			server.SetPrivateIssues(false)
+			plugin.configuration.IssueDetailPolicy = issueDetailsAll
+		}()
+
+		w := submit("user-id", request)
+		assert.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, created)
+		assert.Equal(t, "@jane created https://redmine.example.com/issues/2", created.Message)
+	})
+
+	t.Run("Validation errors", func(t *testing.T) {
+		invalid := request
+		invalid.Submission = map[string]any{"project_id": "9", "subject": "Export fails"}
+
+		w := submit("user-id", invalid)
+		var response model.SubmitDialogResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "Project cannot be blank", response.Error)
+	})
+
+	t.Run("Blank subject", func(t *testing.T) {
+		invalid := request
+		invalid.Submission = map[string]any{"project_id": "2", "subject": "  "}
+
+		w := submit("user-id", invalid)
+		var response model.SubmitDialogResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, map[string]string{"subject": "Subject cannot be blank."}, response.Errors)
+	})
+
+	t.Run("Assignee of another project", func(t *testing.T) {
+		created = nil
+		invalid := request
+		invalid.State = `{"instance": "https://redmine.example.com", "root_id": "root-id", "project": "1"}`
+
+		w := submit("user-id", invalid)
+		var response model.SubmitDialogResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Contains(t, response.Errors, "assigned_to_id")
+		assert.Nil(t, created)
+	})
+
+	t.Run("Announcement target", func(t *testing.T) {
+		for _, tc := range []struct {
+			Description string
+			ChannelID   string
+			State       string
+			Expected    string
+		}{
+			{
+				Description: "Channel the user cannot post in",
+				ChannelID:   "other-channel-id",
+				State:       `{"instance": "https://redmine.example.com"}`,
+				Expected:    "You cannot post in this channel.",
+			},
+			{
+				Description: "Thread of another channel",
+				ChannelID:   "channel-id",
+				State:       `{"instance": "https://redmine.example.com", "root_id": "foreign-root-id"}`,
+				Expected:    "The message the issue is created from is no longer available.",
+			},
+		} {
+			t.Run(tc.Description, func(t *testing.T) {
+				created = nil
+				invalid := request
+				invalid.ChannelId = tc.ChannelID
+				invalid.State = tc.State
+
+				w := submit("user-id", invalid)
+				var response model.SubmitDialogResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Equal(t, tc.Expected, response.Error)
+				assert.Nil(t, created)
+			})
+		}
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, submit("", request).Code)
+		assert.Equal(t, http.StatusUnauthorized, submit("other-user-id", request).Code)
+	})
+}
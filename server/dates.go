@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// dateFormatter renders Redmine timestamps in issue tooltips.
+type dateFormatter struct {
+	location *time.Location
+	layout   string
+	relative bool
+	now      func() time.Time
+}
+
+// defaultDateFormatter renders absolute UTC dates in the RFC 1123 layout.
+var defaultDateFormatter = dateFormatter{
+	location: time.UTC,
+	layout:   time.RFC1123,
+	now:      time.Now,
+}
+
+// format renders an RFC 3339 timestamp as returned by the Redmine API. Values that cannot be
+// parsed are returned unchanged.
+func (f dateFormatter) format(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+
+	if f.relative {
+		now := time.Now
+		if f.now != nil {
+			now = f.now
+		}
+		return relativeTime(now().Sub(t))
+	}
+
+	return t.In(f.location).Format(f.layout)
+}
+
+// relativeTime renders a duration in the past as e.g. "3 hours ago".
+func relativeTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	const day = 24 * time.Hour
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < day:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*day:
+		return plural(int(d/day), "day")
+	case d < 365*day:
+		return plural(int(d/(30*day)), "month")
+	default:
+		return plural(int(d/(365*day)), "year")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s ago", unit)
+	}
+
+	return fmt.Sprintf("%d %ss ago", n, unit)
+}
+
+// getDateFormatter builds the formatter configured by the administrator for posted messages.
+// When enabled, the Mattermost timezone of userID, usually the author of the post, takes
+// precedence over the configured one. Dates are always absolute, since posted messages are not
+// updated as time passes.
+func (p *Plugin) getDateFormatter(userID string) dateFormatter {
+	configuration := p.getConfiguration()
+
+	formatter := defaultDateFormatter
+	if configuration.DateFormat != "" {
+		formatter.layout = configuration.DateFormat
+	}
+	if location, err := time.LoadLocation(configuration.DisplayTimezone); err == nil {
+		formatter.location = location
+	}
+
+	if configuration.UsePosterTimezone && userID != "" {
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			if location, err := time.LoadLocation(user.GetPreferredTimezone()); err == nil && user.GetPreferredTimezone() != "" {
+				formatter.location = location
+			}
+		}
+	}
+
+	return formatter
+}
+
+// getLiveDateFormatter is getDateFormatter for text rendered when it is shown, such as command
+// replies and hover cards, where dates are relative when configured.
+func (p *Plugin) getLiveDateFormatter(userID string) dateFormatter {
+	formatter := p.getDateFormatter(userID)
+	formatter.relative = p.getConfiguration().RelativeDates
+
+	return formatter
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateFormatter(t *testing.T) {
+	now := func() time.Time { return time.Date(2024, 4, 29, 22, 23, 49, 0, time.UTC) }
+
+	for _, tc := range []struct {
+		Description string
+		Formatter   dateFormatter
+		Value       string
+		Expected    string
+	}{
+		{Description: "Default", Formatter: defaultDateFormatter, Value: "2024-04-29T19:23:49Z", Expected: "Mon, 29 Apr 2024 19:23:49 UTC"},
+		{
+			Description: "Custom layout",
+			Formatter:   dateFormatter{location: time.UTC, layout: "2006-01-02 15:04"},
+			Value:       "2024-04-29T19:23:49Z",
+			Expected:    "2024-04-29 19:23",
+		},
+		{Description: "Relative hours", Formatter: dateFormatter{relative: true, now: now}, Value: "2024-04-29T19:23:49Z", Expected: "3 hours ago"},
+		{Description: "Relative minute", Formatter: dateFormatter{relative: true, now: now}, Value: "2024-04-29T22:22:00Z", Expected: "1 minute ago"},
+		{Description: "Relative just now", Formatter: dateFormatter{relative: true, now: now}, Value: "2024-04-29T22:23:40Z", Expected: "just now"},
+		{Description: "Relative months", Formatter: dateFormatter{relative: true, now: now}, Value: "2024-01-01T00:00:00Z", Expected: "3 months ago"},
+		{Description: "Invalid value", Formatter: defaultDateFormatter, Value: "yesterday", Expected: "yesterday"},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, tc.Formatter.format(tc.Value))
+		})
+	}
+}
+
+func TestGetDateFormatter(t *testing.T) {
+	value := "2024-04-29T19:23:49Z"
+
+	t.Run("Configured timezone and layout", func(t *testing.T) {
+		plugin := &Plugin{configuration: &configuration{DisplayTimezone: "America/New_York", DateFormat: "Jan 2 15:04 MST"}}
+		assert.Equal(t, "Apr 29 15:23 EDT", plugin.getDateFormatter("").format(value))
+	})
+
+	t.Run("Poster timezone", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user-id").Return(&model.User{
+			Id:       "user-id",
+			Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "Asia/Tokyo"},
+		}, nil)
+		plugin := &Plugin{configuration: &configuration{DisplayTimezone: "America/New_York", UsePosterTimezone: true}}
+		plugin.SetAPI(api)
+
+		assert.Equal(t, "Tue, 30 Apr 2024 04:23:49 JST", plugin.getDateFormatter("user-id").format(value))
+	})
+
+	t.Run("Poster without a timezone", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user-id").Return(&model.User{Id: "user-id"}, nil)
+		plugin := &Plugin{configuration: &configuration{DisplayTimezone: "America/New_York", UsePosterTimezone: true}}
+		plugin.SetAPI(api)
+
+		assert.Equal(t, "Mon, 29 Apr 2024 15:23:49 EDT", plugin.getDateFormatter("user-id").format(value))
+	})
+
+	t.Run("Relative dates are not posted", func(t *testing.T) {
+		plugin := &Plugin{configuration: &configuration{RelativeDates: true}}
+
+		assert.Equal(t, "Mon, 29 Apr 2024 19:23:49 UTC", plugin.getDateFormatter("").format(value))
+		assert.True(t, plugin.getLiveDateFormatter("").relative)
+	})
+}
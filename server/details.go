@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+// maxNoteLength is the number of characters of the last note shown in the hover card.
+const maxNoteLength = 300
+
+// issueDetails is the issue shown in the hover card of an issue link.
+type issueDetails struct {
+	Instance  string       `json:"instance"`
+	URL       string       `json:"url"`
+	ID        int          `json:"id"`
+	Tracker   string       `json:"tracker"`
+	Subject   string       `json:"subject"`
+	Project   string       `json:"project"`
+	Status    string       `json:"status"`
+	IsClosed  bool         `json:"is_closed"`
+	Color     string       `json:"color"`
+	Assignee  string       `json:"assignee"`
+	Priority  string       `json:"priority"`
+	DoneRatio int          `json:"done_ratio"`
+	DueDate   string       `json:"due_date,omitempty"`
+	UpdatedOn string       `json:"updated_on,omitempty"`
+	LastNote  *journalNote `json:"last_note,omitempty"`
+}
+
+// journalNote is a note added to the history of an issue.
+type journalNote struct {
+	Author    string `json:"author"`
+	Notes     string `json:"notes"`
+	CreatedOn string `json:"created_on"`
+}
+
+func newIssueDetails(issue redmine.Issue, instance *redmineInstance, link string, dates dateFormatter) *issueDetails {
+	details := &issueDetails{
+		Instance:  instance.displayName(),
+		URL:       link,
+		ID:        issue.ID,
+		Tracker:   issue.Tracker.Name,
+		Subject:   issue.Subject,
+		Project:   issue.Project.Name,
+		Status:    issue.Status.Name,
+		IsClosed:  issue.Status.IsClosed,
+		Color:     statusColor(issue.Status),
+		Assignee:  issue.AssignedTo.Name,
+		Priority:  issue.Priority.Name,
+		DoneRatio: issue.DoneRatio,
+	}
+	if issue.DueDate != nil {
+		details.DueDate = *issue.DueDate
+	}
+	if issue.UpdatedOn != "" {
+		details.UpdatedOn = dates.format(issue.UpdatedOn)
+	}
+
+	// Journals are listed oldest first. Private notes are left out, as in channel events.
+	for i := len(issue.Journals) - 1; i >= 0; i-- {
+		journal := issue.Journals[i]
+		if journal.Notes == "" || journal.PrivateNotes {
+			continue
+		}
+		details.LastNote = &journalNote{
+			Author:    journal.User.Name,
+			Notes:     truncate(maxNoteLength, journal.Notes),
+			CreatedOn: dates.format(journal.CreatedOn),
+		}
+		break
+	}
+
+	return details
+}
+
+// handleIssueDetails returns the issue linked by the url parameter as seen by the requesting
+// user: with their linked Redmine account, or anonymously for users without one, within the
+// limits of the IssueDetailPolicy setting. The API key of the instance is never used, so that
+// the card cannot disclose more than the user may see in Redmine. The channel_id parameter
+// gives the channel the link is shown in, which the user must be able to read and whose scope
+// selects the instances and the policy. Issues the user may not see are reported as not found.
+func (p *Plugin) handleIssueDetails(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-ID")
+	channelID := r.URL.Query().Get("channel_id")
+	if channelID == "" {
+		http.Error(w, "Missing channel_id", http.StatusBadRequest)
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		http.Error(w, "Not authorized", http.StatusForbidden)
+		return
+	}
+
+	scope := p.newChannelScope(channelID)
+	link := r.URL.Query().Get("url")
+	references := p.findIssueReferences(link, scope)
+	if len(references) == 0 || references[0].text != link {
+		http.Error(w, "Not a Redmine issue link", http.StatusNotFound)
+		return
+	}
+	reference := references[0]
+	issueID, _ := strconv.Atoi(reference.issueID)
+
+	viewer, ok := p.postViewer(userID, scope)
+	if !ok {
+		http.Error(w, "Issue not found", http.StatusNotFound)
+		return
+	}
+	client, err := p.getPersonalClient(reference.instance, userID)
+	if err != nil {
+		p.API.LogWarn("Failed to create Redmine client", "user_id", userID, "instance", reference.instance.URL, "err", err.Error())
+		http.Error(w, "Failed to reach Redmine", http.StatusBadGateway)
+		return
+	}
+	if viewer.linkedOnly && client.account == nil {
+		http.Error(w, "Issue not found", http.StatusNotFound)
+		return
+	}
+
+	issue, err := client.GetIssue(r.Context(), issueID, "journals")
+	if errors.Is(err, redmine.ErrNotFound) || errors.Is(err, redmine.ErrForbidden) || errors.Is(err, redmine.ErrUnauthorized) {
+		http.Error(w, "Issue not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			p.API.LogWarn("Failed to fetch issue", "user_id", userID, "instance", reference.instance.URL, "issue_id", issueID, "err", err.Error())
+		}
+		http.Error(w, "Failed to reach Redmine", http.StatusBadGateway)
+		return
+	}
+	if viewer.hidePrivate && issue.IsPrivate {
+		http.Error(w, "Issue not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, newIssueDetails(*issue, reference.instance, reference.url, p.getLiveDateFormatter(userID)))
+}
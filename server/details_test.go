@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+func TestHandleIssueDetails(t *testing.T) {
+	server := newAccountsTestServer(t)
+	dueDate := "2024-06-30"
+	server.AddIssue(redmine.Issue{
+		ID:         1,
+		Project:    redmine.IssueProperty{ID: 2, Name: "Website"},
+		Tracker:    redmine.IssueProperty{Name: "Bug"},
+		Status:     redmine.Status{IssueProperty: redmine.IssueProperty{ID: 2, Name: "In Progress"}},
+		Priority:   redmine.IssueProperty{ID: 4, Name: "High"},
+		AssignedTo: redmine.IssueProperty{ID: 5, Name: "Jane Doe"},
+		Subject:    "Public issue",
+		DueDate:    &dueDate,
+		DoneRatio:  40,
+		UpdatedOn:  "2024-06-01T10:00:00Z",
+		Journals: []redmine.Journal{
+			{ID: 1, User: redmine.IssueProperty{Name: "John Smith"}, Notes: "Reproduced on staging", CreatedOn: "2024-05-30T09:00:00Z"},
+			{ID: 2, User: redmine.IssueProperty{Name: "Jane Doe"}, Notes: "Security details", CreatedOn: "2024-05-31T09:00:00Z", PrivateNotes: true},
+			{ID: 3, User: redmine.IssueProperty{Name: "Jane Doe"}, CreatedOn: "2024-06-01T10:00:00Z",
+				Details: []redmine.JournalDetail{{Property: "attr", Name: "done_ratio", OldValue: "0", NewValue: "40"}}},
+		},
+	})
+
+	api := &plugintest.API{}
+	api.On("HasPermissionToChannel", mock.Anything, "channel-id", model.PermissionReadChannel).Return(true)
+	api.On("HasPermissionToChannel", "user-id", "other-channel-id", model.PermissionReadChannel).Return(false)
+
+	// The API key of the instance is allowed for links, but never used for hover cards
+	plugin := newAccountsTestPlugin(server, true)
+	plugin.SetAPI(api)
+
+	account := newUserAccount(plugin.getConfiguration().getInstances()[0], &redmine.User{ID: 5, Login: "jdoe"})
+	require.NoError(t, account.setAPIKey("secret", "jane-key"))
+	require.NoError(t, plugin.saveUserAccount("user-id", account))
+
+	get := func(userID, link, channelID string) *httptest.ResponseRecorder {
+		query := url.Values{"url": {link}}
+		if channelID != "" {
+			query.Set("channel_id", channelID)
+		}
+		r := httptest.NewRequest(http.MethodGet, routeIssueDetails+"?"+query.Encode(), nil)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-ID", userID)
+		}
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("Issue details", func(t *testing.T) {
+		w := get("user-id", "https://redmine.example.com/issues/1", "channel-id")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var details issueDetails
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&details))
+		assert.Equal(t, issueDetails{
+			Instance:  "https://redmine.example.com",
+			URL:       "https://redmine.example.com/issues/1",
+			ID:        1,
+			Tracker:   "Bug",
+			Subject:   "Public issue",
+			Project:   "Website",
+			Status:    "In Progress",
+			Color:     colorProgress,
+			Assignee:  "Jane Doe",
+			Priority:  "High",
+			DoneRatio: 40,
+			DueDate:   "2024-06-30",
+			UpdatedOn: "Sat, 01 Jun 2024 10:00:00 UTC",
+			LastNote: &journalNote{
+				Author:    "John Smith",
+				Notes:     "Reproduced on staging",
+				CreatedOn: "Thu, 30 May 2024 09:00:00 UTC",
+			},
+		}, details)
+	})
+
+	for _, tc := range []struct {
+		Description  string
+		UserID       string
+		Link         string
+		ChannelID    string
+		ExpectedCode int
+	}{
+		{
+			Description:  "Private issue visible to the linked account",
+			UserID:       "user-id",
+			Link:         "https://redmine.example.com/issues/2",
+			ChannelID:    "channel-id",
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Description:  "Private issue of a user without linked account",
+			UserID:       "other-user-id",
+			Link:         "https://redmine.example.com/issues/2",
+			ChannelID:    "channel-id",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Description:  "Public issue of a user without linked account",
+			UserID:       "other-user-id",
+			Link:         "https://redmine.example.com/issues/1",
+			ChannelID:    "channel-id",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Description:  "Unknown issue",
+			UserID:       "user-id",
+			Link:         "https://redmine.example.com/issues/404",
+			ChannelID:    "channel-id",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Description:  "Link of another site",
+			UserID:       "user-id",
+			Link:         "https://example.com/issues/1",
+			ChannelID:    "channel-id",
+			ExpectedCode: http.StatusNotFound,
+		},
+		{
+			Description:  "Missing channel",
+			UserID:       "user-id",
+			Link:         "https://redmine.example.com/issues/1",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Description:  "Channel the user cannot read",
+			UserID:       "user-id",
+			Link:         "https://redmine.example.com/issues/1",
+			ChannelID:    "other-channel-id",
+			ExpectedCode: http.StatusForbidden,
+		},
+		{
+			Description:  "Anonymous request",
+			Link:         "https://redmine.example.com/issues/1",
+			ExpectedCode: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			w := get(tc.UserID, tc.Link, tc.ChannelID)
+			assert.Equal(t, tc.ExpectedCode, w.Code)
+		})
+	}
+}
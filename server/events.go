@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+// Kinds of issue events.
+const (
+	issueEventCreated = "created"
+	issueEventUpdated = "updated"
+	issueEventClosed  = "closed"
+)
+
+// issueEvent is a change of an issue reported by Redmine.
+type issueEvent struct {
+	kind     string
+	issue    redmine.Issue
+	instance *redmineInstance
+	// actor is the name of the user who made the change, if known.
+	actor string
+	// notes is the comment added with the change. Private notes are never included.
+	notes string
+	// changes describes the changed fields, e.g. "**Status**: In Progress".
+	changes []string
+}
+
+// issueURL returns the link to the issue of the event.
+func (e *issueEvent) issueURL() string {
+	redmineURL, _ := getRedmineInstanceURL(e.instance.URL)
+	return fmt.Sprintf("%sissues/%d", redmineURL, e.issue.ID)
+}
+
+// eventAllowedInChannel applies the IssueDetailPolicy setting to events, which have no poster
+// whose account could be checked: private issues are only posted when all issues may be
+// rendered, and the channel allowlist is honoured.
+func (p *Plugin) eventAllowedInChannel(event *issueEvent, scope *channelScope) bool {
+	configuration := p.getConfiguration()
+
+	switch configuration.IssueDetailPolicy {
+	case "", issueDetailsAll:
+		return true
+	case issueDetailsChannelAllowlist:
+		channels := configuration.getIssueDetailChannels()
+		return len(channels) > 0 && scope.allows(nil, channels)
+	default:
+		return !event.issue.IsPrivate
+	}
+}
+
+// handleIssueEvent posts the event into the given channels and refreshes the posts referring
+// to the issue. It returns the number of channels the event was posted in.
+func (p *Plugin) handleIssueEvent(event *issueEvent, channelIDs []string) int {
+	posted := p.publishIssueEvent(event, channelIDs)
+	p.refreshIssuePosts(event.instance, event.issue.ID)
+
+	return posted
+}
+
+// publishIssueEvent posts the event as the bot into the given channels.
+func (p *Plugin) publishIssueEvent(event *issueEvent, channelIDs []string) int {
+	if len(channelIDs) == 0 {
+		return 0
+	}
+
+	dates := p.getDateFormatter("")
+	message := p.formatIssueEvent(event, dates)
+
+	posted := 0
+	for _, channelID := range channelIDs {
+		if !p.eventAllowedInChannel(event, p.newChannelScope(channelID)) {
+			continue
+		}
+
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: channelID,
+			Message:   message,
+		}
+		if event.kind == issueEventCreated {
+			setIssueAttachments(post, []referencedIssue{{Issue: event.issue, instance: event.instance}}, 1, dates)
+		}
+
+		if _, appErr := p.API.CreatePost(post); appErr != nil {
+			p.API.LogWarn("Failed to post issue event", "channel_id", channelID, "instance", event.instance.URL, "issue_id", event.issue.ID, "err", appErr.Error())
+			continue
+		}
+		posted++
+	}
+
+	return posted
+}
+
+// formatIssueEvent renders the message of an event, e.g. "Jane Doe closed [Bug#1: Crash](...)".
+func (p *Plugin) formatIssueEvent(event *issueEvent, dates dateFormatter) string {
+	issueURL := event.issueURL()
+	link, err := p.renderIssueLink(event.issue, event.instance, issueURL, "", nil, dates)
+	if err != nil {
+		link = issueURL
+	}
+
+	actor := event.actor
+	if actor == "" {
+		actor = "Someone"
+	}
+
+	lines := []string{fmt.Sprintf("%s %s %s", actor, event.kind, link)}
+	for _, change := range event.changes {
+		lines = append(lines, "- "+change)
+	}
+	if notes := strings.TrimSpace(event.notes); notes != "" {
+		lines = append(lines, "", "> "+strings.ReplaceAll(notes, "\n", "\n> "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// journalAttributeLabels names the issue attributes whose changes are listed in events.
+var journalAttributeLabels = map[string]string{
+	"status_id":       "Status",
+	"assigned_to_id":  "Assignee",
+	"priority_id":     "Priority",
+	"tracker_id":      "Tracker",
+	"subject":         "Subject",
+	"done_ratio":      "% Done",
+	"start_date":      "Start date",
+	"due_date":        "Due date",
+	"estimated_hours": "Estimated time",
+}
+
+// journalChange is an attribute change recorded in a journal.
+type journalChange struct {
+	Label string
+	Value string
+}
+
+// String renders the change as plain text, e.g. in link tooltips.
+func (c journalChange) String() string {
+	if c.Value == "" {
+		return c.Label + ": none"
+	}
+
+	return c.Label + ": " + c.Value
+}
+
+// journalChanges describes the attribute changes of a journal in markdown.
+func journalChanges(issue redmine.Issue, details []redmine.JournalDetail) []string {
+	return journalChangeLines(describeJournalDetails(issue, details, nil))
+}
+
+// journalChangeLines renders changes in markdown.
+func journalChangeLines(changes []journalChange) []string {
+	var lines []string
+	for _, change := range changes {
+		value := change.Value
+		if value == "" {
+			value = "_none_"
+		}
+		lines = append(lines, fmt.Sprintf("**%s**: %s", change.Label, value))
+	}
+
+	return lines
+}
+
+// describeJournalDetails lists the attribute changes of a journal. Attributes stored as IDs are
+// shown with the current name from the issue, since journals do not resolve the old ones. When
+// a later journal changed them again, as listed in superseded, they are only reported as changed.
+func describeJournalDetails(issue redmine.Issue, details []redmine.JournalDetail, superseded map[string]bool) []journalChange {
+	var changes []journalChange
+	for _, detail := range details {
+		if detail.Property != "attr" {
+			continue
+		}
+		label, ok := journalAttributeLabels[detail.Name]
+		if !ok {
+			continue
+		}
+
+		var value string
+		switch {
+		case detail.Name == "status_id" && !superseded[detail.Name]:
+			value = issue.Status.Name
+		case detail.Name == "assigned_to_id" && !superseded[detail.Name]:
+			value = issue.AssignedTo.Name
+		case detail.Name == "priority_id" && !superseded[detail.Name]:
+			value = issue.Priority.Name
+		case detail.Name == "tracker_id" && !superseded[detail.Name]:
+			value = issue.Tracker.Name
+		case strings.HasSuffix(detail.Name, "_id"):
+			value = "changed"
+		default:
+			value = detail.NewValue
+			if detail.OldValue != "" {
+				value = detail.OldValue + " → " + value
+			}
+		}
+		changes = append(changes, journalChange{Label: label, Value: value})
+	}
+
+	return changes
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// redmineInstance is a Redmine server whose issue links the plugin expands.
+type redmineInstance struct {
+	// URL is the base URL of the instance, e.g. https://www.redmine.org/.
+	URL string `json:"url"`
+	// APIKey authenticates requests to the instance. Optional for public instances.
+	APIKey string `json:"api_key"`
+	// Label is a human readable name shown in issue tooltips. Optional.
+	Label string `json:"label"`
+	// Teams restricts link expansion to the given team names or IDs. Empty means all teams.
+	Teams []string `json:"teams"`
+	// OAuthClientID and OAuthClientSecret identify the OAuth2 application registered in
+	// Redmine. When set, users connect their account through OAuth2 instead of API keys.
+	OAuthClientID     string `json:"oauth_client_id"`
+	OAuthClientSecret string `json:"oauth_client_secret"`
+}
+
+// instanceSecrets are the credentials of an additional instance, given in the
+// RedmineInstanceSecrets setting so that the System Console does not show them.
+type instanceSecrets struct {
+	APIKey            string `json:"api_key"`
+	OAuthClientSecret string `json:"oauth_client_secret"`
+}
+
+// instanceKeyHash identifies an instance in KV store keys. The URL is hashed to keep the keys
+// within the KV store length limit.
+func instanceKeyHash(instanceURL string) string {
+	redmineURL, _ := getRedmineInstanceURL(instanceURL)
+	sum := sha256.Sum256([]byte(redmineURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// displayName returns the label of the instance, or its URL when it has none.
+func (i *redmineInstance) displayName() string {
+	if i.Label != "" {
+		return i.Label
+	}
+
+	return i.URL
+}
+
+// oauthEnabled reports whether users connect their account through OAuth2.
+func (i *redmineInstance) oauthEnabled() bool {
+	return i.OAuthClientID != "" && i.OAuthClientSecret != ""
+}
+
+// parseInstances builds the instance list from the single-instance settings, kept for
+// backwards compatibility, followed by the entries of the RedmineInstances JSON setting with
+// the credentials of the RedmineInstanceSecrets setting. Credentials still given in
+// RedmineInstances are used unless overridden.
+func (c *configuration) parseInstances() ([]*redmineInstance, error) {
+	var instances []*redmineInstance
+
+	if c.RedmineInstanceURL != "" {
+		instances = append(instances, &redmineInstance{
+			URL:               c.RedmineInstanceURL,
+			APIKey:            c.RedmineAPIKey,
+			OAuthClientID:     c.RedmineOAuthClientID,
+			OAuthClientSecret: c.RedmineOAuthClientSecret,
+		})
+	}
+
+	if strings.TrimSpace(c.RedmineInstances) != "" {
+		var extra []*redmineInstance
+		if err := json.Unmarshal([]byte(c.RedmineInstances), &extra); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Redmine instances")
+		}
+		instances = append(instances, extra...)
+	}
+
+	seen := make(map[string]bool, len(instances))
+	for i, instance := range instances {
+		redmineURL, _ := getRedmineInstanceURL(instance.URL)
+		if redmineURL == "" {
+			return nil, fmt.Errorf("redmine instance #%d has an invalid URL %q", i+1, instance.URL)
+		}
+		if seen[redmineURL] {
+			return nil, fmt.Errorf("redmine instance %s is configured more than once", redmineURL)
+		}
+		seen[redmineURL] = true
+	}
+
+	if strings.TrimSpace(c.RedmineInstanceSecrets) != "" {
+		var secrets map[string]instanceSecrets
+		if err := json.Unmarshal([]byte(c.RedmineInstanceSecrets), &secrets); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Redmine instance secrets")
+		}
+		for instanceURL, secret := range secrets {
+			instance := findInstance(instances, instanceURL)
+			if instance == nil || instanceURL == "" {
+				return nil, fmt.Errorf("secrets are given for the unknown Redmine instance %q", instanceURL)
+			}
+			if secret.APIKey != "" {
+				instance.APIKey = secret.APIKey
+			}
+			if secret.OAuthClientSecret != "" {
+				instance.OAuthClientSecret = secret.OAuthClientSecret
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// getInstances returns the configured Redmine instances.
+func (c *configuration) getInstances() []*redmineInstance {
+	if c.instances != nil {
+		return c.instances
+	}
+
+	instances, _ := c.parseInstances()
+	return instances
+}
+
+// getInstancesForScope returns the instances whose links should be expanded in a channel.
+func (p *Plugin) getInstancesForScope(scope *channelScope) []*redmineInstance {
+	instances := p.getConfiguration().getInstances()
+
+	enabled := make([]*redmineInstance, 0, len(instances))
+	for _, instance := range instances {
+		if scope.allows(instance.Teams, nil) {
+			enabled = append(enabled, instance)
+		}
+	}
+
+	return enabled
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestParseInstances(t *testing.T) {
+	t.Run("Legacy settings come first", func(t *testing.T) {
+		config := &configuration{
+			RedmineInstanceURL: "https://www.redmine.org",
+			RedmineAPIKey:      "key",
+			RedmineInstances:   `[{"url": "https://redmine.example.com", "api_key": "other", "label": "Internal", "teams": ["dev"]}]`,
+		}
+
+		instances, err := config.parseInstances()
+		require.NoError(t, err)
+		require.Len(t, instances, 2)
+		assert.Equal(t, &redmineInstance{URL: "https://www.redmine.org", APIKey: "key"}, instances[0])
+		assert.Equal(t, &redmineInstance{URL: "https://redmine.example.com", APIKey: "other", Label: "Internal", Teams: []string{"dev"}}, instances[1])
+	})
+
+	t.Run("Secrets of additional instances", func(t *testing.T) {
+		config := &configuration{
+			RedmineInstances:       `[{"url": "https://redmine.example.com", "api_key": "legacy", "oauth_client_id": "client"}, {"url": "https://www.redmine.org", "api_key": "kept"}]`,
+			RedmineInstanceSecrets: `{"https://redmine.example.com/": {"api_key": "key", "oauth_client_secret": "secret"}}`,
+		}
+
+		instances, err := config.parseInstances()
+		require.NoError(t, err)
+		require.Len(t, instances, 2)
+		assert.Equal(t, &redmineInstance{URL: "https://redmine.example.com", APIKey: "key", OAuthClientID: "client", OAuthClientSecret: "secret"}, instances[0])
+		assert.Equal(t, &redmineInstance{URL: "https://www.redmine.org", APIKey: "kept"}, instances[1])
+
+		config.RedmineInstanceSecrets = `{"https://tracker.example.com/": {"api_key": "key"}}`
+		_, err = config.parseInstances()
+		assert.ErrorContains(t, err, "unknown Redmine instance")
+
+		config.RedmineInstanceSecrets = `[]`
+		_, err = config.parseInstances()
+		assert.Error(t, err)
+	})
+
+	t.Run("No instances", func(t *testing.T) {
+		instances, err := (&configuration{}).parseInstances()
+		require.NoError(t, err)
+		assert.Empty(t, instances)
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		_, err := (&configuration{RedmineInstances: `{"url": "https://www.redmine.org"}`}).parseInstances()
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing URL", func(t *testing.T) {
+		_, err := (&configuration{RedmineInstances: `[{"label": "Nowhere"}]`}).parseInstances()
+		assert.Error(t, err)
+	})
+
+	t.Run("Duplicate instance", func(t *testing.T) {
+		_, err := (&configuration{
+			RedmineInstanceURL: "https://www.redmine.org",
+			RedmineInstances:   `[{"url": "https://www.redmine.org/"}]`,
+		}).parseInstances()
+		assert.Error(t, err)
+	})
+}
+
+func TestMessageWillBePostedMultipleInstances(t *testing.T) {
+	upstream := redminetest.NewServer(t)
+	upstream.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Defect"}, Subject: "Upstream issue"})
+
+	internal := redminetest.NewServer(t)
+	internal.SetAPIKey("internal-key")
+	internal.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Task"}, Subject: "Internal issue"})
+
+	customer := redminetest.NewServer(t)
+	customer.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Customer issue"})
+
+	api := &plugintest.API{}
+	api.On("GetChannel", "channel-id").Return(&model.Channel{Id: "channel-id", TeamId: "team-id"}, nil)
+	api.On("GetTeam", "team-id").Return(&model.Team{Id: "team-id", Name: "dev"}, nil)
+	defer api.AssertExpectations(t)
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://www.redmine.org",
+			AllowGlobalAPIKey:  true,
+			RedmineInstances: `[
+				{"url": "https://redmine.example.com", "api_key": "internal-key", "label": "Internal", "teams": ["dev"]},
+				{"url": "https://tracker.customer.com", "label": "Customer", "teams": ["support"]}
+			]`,
+		},
+		httpClient: redminetest.RoutingHTTPClient(map[string]*redminetest.Server{
+			"www.redmine.org":      upstream,
+			"redmine.example.com":  internal,
+			"tracker.customer.com": customer,
+		}),
+	}
+	plugin.SetAPI(api)
+
+	newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{
+		ChannelId: "channel-id",
+		Message:   "https://www.redmine.org/issues/1 https://redmine.example.com/issues/1 https://tracker.customer.com/issues/1",
+	})
+
+	assert.Contains(t, newPost.Message, "[Defect#1: Upstream issue](https://www.redmine.org/issues/1 \"Assignee")
+	assert.Contains(t, newPost.Message, "[Task#1: Internal issue](https://redmine.example.com/issues/1 \"Instance: Internal&#013;")
+	assert.Contains(t, newPost.Message, " https://tracker.customer.com/issues/1")
+	assert.Empty(t, customer.Requests())
+}
+
+func TestGetRedmineInstanceURL(t *testing.T) {
+	for _, tc := range []struct {
+		URL          string
+		ExpectedURL  string
+		ExpectedHost string
+	}{
+		{URL: "https://www.redmine.org", ExpectedURL: "https://www.redmine.org/", ExpectedHost: "www.redmine.org"},
+		{URL: "www.redmine.org/", ExpectedURL: "https://www.redmine.org/", ExpectedHost: "www.redmine.org"},
+		{URL: "https://corp.example.com/redmine/", ExpectedURL: "https://corp.example.com/redmine/", ExpectedHost: "corp.example.com/redmine"},
+		{URL: "http://corp.example.com:8080/tools/redmine", ExpectedURL: "http://corp.example.com:8080/tools/redmine/", ExpectedHost: "corp.example.com:8080/tools/redmine"},
+		{URL: "corp.example.com:8080/redmine", ExpectedURL: "https://corp.example.com:8080/redmine/", ExpectedHost: "corp.example.com:8080/redmine"},
+		{URL: "https://www.redmine.org:443/", ExpectedURL: "https://www.redmine.org/", ExpectedHost: "www.redmine.org"},
+		{URL: "", ExpectedURL: "", ExpectedHost: ""},
+	} {
+		t.Run(tc.URL, func(t *testing.T) {
+			redmineURL, redmineHost := getRedmineInstanceURL(tc.URL)
+			assert.Equal(t, tc.ExpectedURL, redmineURL)
+			assert.Equal(t, tc.ExpectedHost, redmineHost)
+		})
+	}
+}
+
+func TestMessageWillBePostedSubPathInstance(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.SetPathPrefix("/redmine")
+	server.AddIssue(redmine.Issue{ID: 7, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Behind a proxy"})
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "http://corp.example.com:8080/redmine/",
+		},
+		httpClient: server.HTTPClient(),
+	}
+
+	newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{
+		Message: "http://corp.example.com:8080/redmine/issues/7 corp.example.com:8080/redmine/issues/7#note-1 http://corp.example.com:8080/issues/7",
+	})
+
+	assert.Contains(t, newPost.Message, "[Bug#7: Behind a proxy](http://corp.example.com:8080/redmine/issues/7 ")
+	assert.Contains(t, newPost.Message, "[Bug#7: Behind a proxy#note-1](corp.example.com:8080/redmine/issues/7#note-1 ")
+	assert.True(t, strings.HasSuffix(newPost.Message, " http://corp.example.com:8080/issues/7"))
+	assert.Equal(t, []string{
+		"/redmine/issues.json?issue_id=7&limit=100&offset=0&status_id=%2A",
+		// The history of the issue, for the linked note.
+		"/redmine/issues/7.json?include=journals",
+	}, server.Requests())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	// maxQueryIssues is the number of matching issues listed in the tooltip of query links.
+	maxQueryIssues = 5
+	// maxQueryPages caps the pages of issues counted by status for query links, so that large
+	// queries cost a bounded number of requests.
+	maxQueryPages = 5
+	// queryCacheTTL caps how long the counts and names of query links are cached, as they
+	// change with every matching issue.
+	queryCacheTTL = time.Minute
+)
+
+// ignoredQueryParams are the parameters of issue list URLs that are not forwarded to the API:
+// paging and layout options of the page, and API keys pasted along with a URL.
+var ignoredQueryParams = []string{"key", "limit", "offset", "page", "per_page", "format", "utf8", "c[]", "t[]", "group_by"}
+
+// statusCount is the number of matching issues in a status.
+type statusCount struct {
+	name  string
+	count int
+}
+
+// fetchIssueQueryLink runs the saved query or the filters of an issue list URL, such as
+// issues?query_id=42 or issues?set_filter=1&status_id=o, and renders the number of matching
+// issues by status. The first issues are listed in the tooltip, in the order of the query.
+// Queries matching more than maxQueryPages pages of issues are counted by status over the first
+// pages only, which the link text states.
+func fetchIssueQueryLink(ctx context.Context, client *redmine.Client, match []string, options resourceOptions) (*resourceLink, error) {
+	// Like Redmine, skip the malformed parameters and keep the others
+	query, _ := url.ParseQuery(match[1])
+	for _, param := range ignoredQueryParams {
+		query.Del(param)
+	}
+	if match[0] != "" {
+		query.Set("project_id", match[0])
+	}
+
+	fetched, total, err := listQueryIssues(ctx, client, query)
+	if err != nil {
+		return nil, err
+	}
+	partial := len(fetched) < total
+
+	issues := make([]redmine.Issue, 0, len(fetched))
+	for _, issue := range fetched {
+		if !options.hidePrivate || !issue.IsPrivate {
+			issues = append(issues, issue)
+		}
+	}
+	// The private issues of the pages not fetched are unknown, so the total is then a lower bound.
+	atLeast := partial && options.hidePrivate
+	if options.hidePrivate {
+		total = len(issues)
+	}
+
+	name := "Issues"
+	if id := query.Get("query_id"); id != "" {
+		name = getQueryName(ctx, client, id)
+	}
+	text := fmt.Sprintf("%s: %d %s", name, total, pluralIssues(total))
+	if atLeast {
+		text = fmt.Sprintf("%s: at least %d %s", name, total, pluralIssues(total))
+	}
+	statuses := countStatuses(issues)
+	if len(statuses) > 0 {
+		counts := make([]string, 0, len(statuses))
+		for _, status := range statuses {
+			counts = append(counts, fmt.Sprintf("%d %s", status.count, status.name))
+		}
+		breakdown := strings.Join(counts, ", ")
+		if partial {
+			breakdown = fmt.Sprintf("first %d: %s", len(issues), breakdown)
+		}
+		text += " (" + breakdown + ")"
+	}
+
+	var tooltip []string
+	if match[0] != "" {
+		tooltip = append(tooltip, "Project: "+match[0])
+	}
+	for i, issue := range issues {
+		if i == maxQueryIssues {
+			break
+		}
+		tooltip = append(tooltip, truncate(maxResourceDescriptionLength,
+			fmt.Sprintf("#%d %s: %s (%s)", issue.ID, issue.Tracker.Name, issue.Subject, issue.Status.Name)))
+	}
+	if more := total - min(len(issues), maxQueryIssues); atLeast {
+		tooltip = append(tooltip, fmt.Sprintf("and at least %d more", more))
+	} else if more > 0 {
+		tooltip = append(tooltip, fmt.Sprintf("and %d more", more))
+	}
+
+	return &resourceLink{text: text, tooltip: tooltip}, nil
+}
+
+// listQueryIssues pages through the issues matching the query, up to maxQueryPages pages, and
+// returns them along with the total number of matching issues.
+func listQueryIssues(ctx context.Context, client *redmine.Client, query url.Values) ([]redmine.Issue, int, error) {
+	query.Set("limit", strconv.Itoa(redmine.MaxPageSize))
+
+	var issues []redmine.Issue
+	for page := 0; page < maxQueryPages; page++ {
+		query.Set("offset", strconv.Itoa(len(issues)))
+
+		resp, err := client.ListIssues(ctx, query)
+		if err != nil {
+			return nil, 0, err
+		}
+		issues = append(issues, resp.Issues...)
+		if len(resp.Issues) == 0 || len(issues) >= resp.TotalCount || page == maxQueryPages-1 {
+			return issues, max(resp.TotalCount, len(issues)), nil
+		}
+	}
+
+	return issues, len(issues), nil
+}
+
+// getQueryName returns the name of the saved query with the given ID, or a placeholder when the
+// query is not listed for the user.
+func getQueryName(ctx context.Context, client *redmine.Client, id string) string {
+	resp, err := client.ListQueries(ctx, url.Values{"limit": {strconv.Itoa(redmine.MaxPageSize)}})
+	if err == nil {
+		for _, query := range resp.Queries {
+			if strconv.Itoa(query.ID) == id {
+				return query.Name
+			}
+		}
+	}
+
+	return "Query #" + id
+}
+
+// countStatuses counts the issues by status, the most frequent status first.
+func countStatuses(issues []redmine.Issue) []statusCount {
+	var counts []statusCount
+	index := map[string]int{}
+	for _, issue := range issues {
+		i, ok := index[issue.Status.Name]
+		if !ok {
+			i = len(counts)
+			index[issue.Status.Name] = i
+			counts = append(counts, statusCount{name: issue.Status.Name})
+		}
+		counts[i].count++
+	}
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].count > counts[j].count
+	})
+
+	return counts
+}
+
+func pluralIssues(count int) string {
+	if count == 1 {
+		return "issue"
+	}
+
+	return "issues"
+}
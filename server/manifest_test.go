@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestSecretSettings(t *testing.T) {
+	require.NotNil(t, manifest.SettingsSchema)
+
+	secret := map[string]bool{}
+	for _, setting := range manifest.SettingsSchema.Settings {
+		secret[setting.Key] = setting.Secret
+	}
+
+	// Credentials and keys are masked in the System Console and in configuration exports
+	for _, key := range []string{
+		"RedmineAPIKey",
+		"RedmineOAuthClientSecret",
+		"RedmineInstanceSecrets",
+		"EncryptionKey",
+		"WebhookSecret",
+	} {
+		assert.True(t, secret[key], key)
+	}
+}
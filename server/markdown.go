@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/shared/markdown"
+)
+
+// textSpan is a part of a message rendered as plain text, given by its byte offsets.
+type textSpan struct {
+	start, end int
+	// autolink reports whether the span is a bare URL, which Mattermost renders as a link.
+	autolink bool
+}
+
+// contains reports whether a reference between the byte offsets start and end may be replaced
+// by a link. Bare URLs are only replaced as a whole.
+func (s textSpan) contains(start, end int) bool {
+	if s.autolink {
+		return s.start == start && s.end == end
+	}
+
+	return s.start <= start && end <= s.end
+}
+
+// plainTextSpans returns the parts of a message in which references are expanded, as parsed by
+// the Mattermost markdown parser: text and bare URLs of paragraphs, but nothing in code spans,
+// code blocks, block quotes, links, images or autolinks in angle brackets.
+func plainTextSpans(message string) []textSpan {
+	var spans []textSpan
+
+	markdown.Inspect(message, func(node any) bool {
+		switch node := node.(type) {
+		case *markdown.BlockQuote:
+			return false
+		case *markdown.InlineLink, *markdown.InlineImage, *markdown.ReferenceLink, *markdown.ReferenceImage:
+			return false
+		case *markdown.Autolink:
+			start, end := node.RawDestination.Position, node.RawDestination.End
+			if start == 0 || end == len(message) || message[start-1] != '<' || message[end] != '>' {
+				spans = append(spans, textSpan{start: start, end: end, autolink: true})
+			}
+			return false
+		case *markdown.Text:
+			spans = append(spans, textSpan{start: node.Range.Position, end: node.Range.End})
+		}
+
+		return true
+	})
+
+	return spans
+}
+
+// filterPlainTextReferences keeps the references that lie in the plain text of the message.
+func filterPlainTextReferences(message string, references []issueReference) []issueReference {
+	if len(references) == 0 {
+		return references
+	}
+
+	spans := plainTextSpans(message)
+	kept := references[:0]
+	for _, reference := range references {
+		if inPlainText(spans, reference.start, reference.end) {
+			kept = append(kept, reference)
+		}
+	}
+
+	return kept
+}
+
+// inPlainText reports whether one of the spans contains the part of the message between the
+// byte offsets start and end.
+func inPlainText(spans []textSpan, start, end int) bool {
+	for _, span := range spans {
+		if span.contains(start, end) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	noteAnchorPrefix = "#note-"
+
+	// maxNoteExcerptLength is the number of characters of a linked note shown in attachments.
+	maxNoteExcerptLength = 300
+)
+
+// linkNote is the journal of an issue linked with a #note-N anchor.
+type linkNote struct {
+	// Number is the N of the anchor.
+	Number    int
+	Author    string
+	CreatedOn string
+	// Notes is the text of the note, empty when the journal only changed attributes.
+	Notes   string
+	Changes []journalChange
+}
+
+// noteNumber returns the N of a #note-N anchor, or 0 for other anchors.
+func noteNumber(anchor string) int {
+	number, err := strconv.Atoi(strings.TrimPrefix(anchor, noteAnchorPrefix))
+	if err != nil || !strings.HasPrefix(anchor, noteAnchorPrefix) || number < 1 {
+		return 0
+	}
+
+	return number
+}
+
+// findLinkNote returns the Nth journal of the issue history. Redmine numbers the note anchors
+// from 1 over all the journals of an issue, in order of creation, before hiding the private
+// notes the user may not see. journals must therefore be the complete history, see
+// completeJournals. Private notes are never disclosed.
+func findLinkNote(issue redmine.Issue, journals []redmine.Journal, number int) *linkNote {
+	if number < 1 || number > len(journals) {
+		return nil
+	}
+	journal := journals[number-1]
+	if journal.PrivateNotes {
+		return nil
+	}
+
+	superseded := map[string]bool{}
+	for _, later := range journals[number:] {
+		for _, detail := range later.Details {
+			if detail.Property == "attr" {
+				superseded[detail.Name] = true
+			}
+		}
+	}
+
+	return &linkNote{
+		Number:    number,
+		Author:    journal.User.Name,
+		CreatedOn: journal.CreatedOn,
+		Notes:     strings.TrimSpace(journal.Notes),
+		Changes:   describeJournalDetails(issue, journal.Details, superseded),
+	}
+}
+
+// getLinkNote fetches the history of the issue with the client and returns its Nth journal,
+// or nil when the history may lack private notes hidden from the client. Histories are kept in
+// journals, keyed by instance and issue, so that every issue is fetched once per message.
+func (p *Plugin) getLinkNote(client *userClient, instance *redmineInstance, issue redmine.Issue, number int, journals map[string][]redmine.Journal) *linkNote {
+	key := instance.URL + "#" + strconv.Itoa(issue.ID)
+	history, ok := journals[key]
+	if !ok {
+		var err error
+		history, err = client.GetIssueJournals(context.Background(), issue.ID)
+		if err != nil {
+			p.API.LogWarn("Failed to get issue history", "instance", instance.URL, "issue_id", issue.ID, "err", err.Error())
+		}
+		journals[key] = history
+	}
+	if !completeJournals(client, history) {
+		return nil
+	}
+
+	return findLinkNote(issue, history, number)
+}
+
+// completeJournals reports whether the history fetched with the client includes every journal,
+// so that journals are found by their note number. It does when the client uses the API key of
+// the instance, expected to see private notes, or when the history shows private notes of other
+// users, which are only listed to users allowed to view private notes.
+func completeJournals(client *userClient, journals []redmine.Journal) bool {
+	if client.shared && client.authenticated {
+		return true
+	}
+
+	userID := 0
+	if client.account != nil {
+		userID = client.account.RedmineUserID
+	}
+	for _, journal := range journals {
+		if journal.PrivateNotes && journal.User.ID != userID {
+			return true
+		}
+	}
+
+	return false
+}
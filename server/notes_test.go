@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestNoteNumber(t *testing.T) {
+	for _, tc := range []struct {
+		Anchor   string
+		Expected int
+	}{
+		{Anchor: "#note-4", Expected: 4},
+		{Anchor: "#note-0", Expected: 0},
+		{Anchor: "#note-", Expected: 0},
+		{Anchor: "#change-4", Expected: 0},
+		{Anchor: "", Expected: 0},
+	} {
+		assert.Equal(t, tc.Expected, noteNumber(tc.Anchor), tc.Anchor)
+	}
+}
+
+func TestNoteLinks(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.AddIssue(redmine.Issue{
+		ID:        1,
+		Tracker:   redmine.IssueProperty{Name: "Bug"},
+		Status:    redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true},
+		Priority:  redmine.IssueProperty{ID: 4, Name: "High"},
+		Subject:   "Login fails",
+		UpdatedOn: "2024-06-03T10:00:00Z",
+		Journals: []redmine.Journal{
+			{ID: 11, User: redmine.IssueProperty{Name: "John Smith"}, Notes: "Reproduced on staging", CreatedOn: "2024-06-01T10:00:00Z", Details: []redmine.JournalDetail{
+				{Property: "attr", Name: "status_id", OldValue: "1", NewValue: "2"},
+				{Property: "attr", Name: "priority_id", OldValue: "3", NewValue: "4"},
+				{Property: "attr", Name: "done_ratio", OldValue: "0", NewValue: "50"},
+			}},
+			{ID: 12, User: redmine.IssueProperty{Name: "Jane Doe"}, Notes: "Security details", CreatedOn: "2024-06-02T10:00:00Z", PrivateNotes: true},
+			{ID: 13, User: redmine.IssueProperty{Name: "Jane Doe"}, CreatedOn: "2024-06-03T10:00:00Z", Details: []redmine.JournalDetail{
+				{Property: "attr", Name: "status_id", OldValue: "2", NewValue: "5"},
+			}},
+		},
+	})
+
+	newPlugin := func(mode string) *Plugin {
+		plugin := &Plugin{
+			configuration: &configuration{
+				RedmineInstanceURL: "https://redmine.example.com",
+				RedmineAPIKey:      "key",
+				AllowGlobalAPIKey:  true,
+				TooltipTemplate:    `{{with .Note}}#{{.Number}} {{.Author}}: {{.Notes}}{{range .Changes}} / {{.}}{{end}}{{end}}`,
+				LinkDisplayMode:    mode,
+			},
+			httpClient: server.HTTPClient(),
+		}
+		plugin.SetAPI(&plugintest.API{})
+		return plugin
+	}
+
+	t.Run("Tooltip", func(t *testing.T) {
+		post, _ := newPlugin(linkDisplayInline).MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues/1#note-1 " +
+			"https://redmine.example.com/issues/1#note-2 https://redmine.example.com/issues/1#note-3 https://redmine.example.com/issues/1#note-9"})
+
+		assert.Equal(t,
+			`[Bug#1: Login fails#note-1](https://redmine.example.com/issues/1#note-1 "#1 John Smith: Reproduced on staging / Status: changed / Priority: High / % Done: 0 → 50") `+
+				`[Bug#1: Login fails#note-2](https://redmine.example.com/issues/1#note-2 "") `+
+				`[Bug#1: Login fails#note-3](https://redmine.example.com/issues/1#note-3 "#3 Jane Doe:  / Status: Closed") `+
+				`[Bug#1: Login fails#note-9](https://redmine.example.com/issues/1#note-9 "")`,
+			post.Message,
+		)
+	})
+
+	t.Run("Default tooltip", func(t *testing.T) {
+		plugin := newPlugin(linkDisplayInline)
+		plugin.configuration.TooltipTemplate = ""
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues/1#note-3"})
+		assert.Contains(t, post.Message, "&#013;Note #3 by Jane Doe, Mon, 03 Jun 2024 10:00:00 UTC&#013;Status: Closed\")")
+	})
+
+	t.Run("History without private notes", func(t *testing.T) {
+		plugin := newPlugin(linkDisplayInline)
+		plugin.configuration.RedmineAPIKey = ""
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues/1#note-1 https://redmine.example.com/issues/1#note-3"})
+		assert.Equal(t,
+			`[Bug#1: Login fails#note-1](https://redmine.example.com/issues/1#note-1 "") `+
+				`[Bug#1: Login fails#note-3](https://redmine.example.com/issues/1#note-3 "")`,
+			post.Message,
+		)
+	})
+
+	t.Run("Attachment", func(t *testing.T) {
+		post, _ := newPlugin(linkDisplayAttachments).MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues/1#note-1"})
+
+		attachments := post.Attachments()
+		require.Len(t, attachments, 1)
+		fields := attachments[0].Fields
+		assert.Equal(t, &model.SlackAttachmentField{
+			Title: "Note #1 by John Smith, Sat, 01 Jun 2024 10:00:00 UTC",
+			Value: "Reproduced on staging\n**Status**: changed\n**Priority**: High\n**% Done**: 0 → 50",
+		}, fields[len(fields)-1])
+	})
+}
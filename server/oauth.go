@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	oauthStateKeyPrefix = "oauth_state_"
+	// oauthStateTTL is how long the user has to grant access on the Redmine authorize page.
+	oauthStateTTL = 10 * time.Minute
+	// tokenRefreshMargin refreshes access tokens shortly before they expire, so that they do
+	// not expire during a request.
+	tokenRefreshMargin = time.Minute
+	// tokenRefreshMutexPrefix is the prefix of the cluster mutexes serializing the refreshes of
+	// the token of a user on an instance.
+	tokenRefreshMutexPrefix = "token_refresh_"
+)
+
+// oauthScopes are the Redmine permissions requested for connected accounts.
+var oauthScopes = []string{"view_project", "view_issues", "add_issues"}
+
+// oauthState ties an authorization request to the user who started it.
+type oauthState struct {
+	UserID   string `json:"user_id"`
+	Instance string `json:"instance"`
+}
+
+// getOAuthConfig returns the OAuth2 application of an instance. Redmine redirects back to the
+// callback route of the plugin.
+func (p *Plugin) getOAuthConfig(instance *redmineInstance) (redmine.OAuthConfig, error) {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/")
+	}
+	if siteURL == "" {
+		return redmine.OAuthConfig{}, errors.New("the Mattermost site URL is not configured")
+	}
+
+	return redmine.OAuthConfig{
+		ClientID:     instance.OAuthClientID,
+		ClientSecret: instance.OAuthClientSecret,
+		RedirectURL:  siteURL + pluginURL(routeOAuthCallback),
+		Scopes:       oauthScopes,
+	}, nil
+}
+
+// oauthConnectURL returns the link starting the authorization of an instance.
+func (p *Plugin) oauthConnectURL(instance *redmineInstance) (string, error) {
+	config, err := p.getOAuthConfig(instance)
+	if err != nil {
+		return "", err
+	}
+
+	connectURL := strings.TrimSuffix(config.RedirectURL, routeOAuthCallback) + routeOAuthConnect
+	return connectURL + "?" + url.Values{"instance": {instance.URL}}.Encode(), nil
+}
+
+// handleOAuthConnect redirects the user to the authorize page of the instance.
+func (p *Plugin) handleOAuthConnect(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	instance := findInstance(p.getConfiguration().getInstances(), r.URL.Query().Get("instance"))
+	if instance == nil || !instance.oauthEnabled() {
+		http.Error(w, "OAuth2 is not configured for this Redmine instance", http.StatusBadRequest)
+		return
+	}
+	if p.getConfiguration().EncryptionKey == "" || p.kvStore == nil {
+		http.Error(w, "Linking Redmine accounts is not available", http.StatusInternalServerError)
+		return
+	}
+
+	config, err := p.getOAuthConfig(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, err := p.newRedmineClient(instance, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state := model.NewId()
+	if _, err := p.kvStore.Set(oauthStateKeyPrefix+state, oauthState{UserID: userID, Instance: instance.URL}, pluginapi.SetExpiry(oauthStateTTL)); err != nil {
+		p.API.LogWarn("Failed to save OAuth2 state", "err", err.Error())
+		http.Error(w, "Failed to start the authorization", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, client.AuthorizeURL(config, state), http.StatusFound)
+}
+
+// handleOAuthCallback completes the authorization, exchanging the code for a token that is
+// stored encrypted in the account of the user.
+func (p *Plugin) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	query := r.URL.Query()
+
+	stateKey := oauthStateKeyPrefix + query.Get("state")
+	var state *oauthState
+	if p.kvStore != nil && query.Get("state") != "" {
+		if err := p.kvStore.Get(stateKey, &state); err != nil {
+			p.API.LogWarn("Failed to load OAuth2 state", "err", err.Error())
+		}
+		_ = p.kvStore.Delete(stateKey)
+	}
+	if state == nil || state.UserID != userID {
+		writeOAuthPage(w, http.StatusBadRequest, "The authorization request is invalid or has expired. Please run /redmine connect again.")
+		return
+	}
+
+	instance := findInstance(p.getConfiguration().getInstances(), state.Instance)
+	if instance == nil || !instance.oauthEnabled() {
+		writeOAuthPage(w, http.StatusBadRequest, "OAuth2 is no longer configured for this Redmine instance.")
+		return
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		writeOAuthPage(w, http.StatusOK, "Redmine did not grant access: "+strings.TrimSpace(errorCode+" "+query.Get("error_description")))
+		return
+	}
+
+	token, user, err := p.exchangeOAuthCode(r.Context(), instance, query.Get("code"))
+	if err != nil {
+		p.API.LogWarn("Failed to complete OAuth2 authorization", "user_id", userID, "instance", instance.URL, "err", err.Error())
+		writeOAuthPage(w, http.StatusBadGateway, "Failed to connect your Redmine account: "+err.Error())
+		return
+	}
+
+	account := newUserAccount(instance, user)
+	err = account.setToken(p.getConfiguration().EncryptionKey, token)
+	if err == nil {
+		err = p.saveUserAccount(userID, account)
+	}
+	if err != nil {
+		p.API.LogWarn("Failed to save Redmine account", "user_id", userID, "instance", instance.URL, "err", err.Error())
+		writeOAuthPage(w, http.StatusInternalServerError, "Failed to save your Redmine account.")
+		return
+	}
+
+	writeOAuthPage(w, http.StatusOK, fmt.Sprintf("Your Redmine account %s (%s) is now connected to %s. You can close this window.", user.Name(), user.Login, instance.displayName()))
+}
+
+// exchangeOAuthCode trades the authorization code for a token and looks up the user it
+// belongs to.
+func (p *Plugin) exchangeOAuthCode(ctx context.Context, instance *redmineInstance, code string) (*redmine.Token, *redmine.User, error) {
+	config, err := p.getOAuthConfig(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := p.newRedmineClient(instance, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := client.ExchangeCode(ctx, config, code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userClient, err := p.newTokenClient(instance, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := userClient.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, user, nil
+}
+
+// handleOAuthDisconnect unlinks the account of the user, revoking its token.
+func (p *Plugin) handleOAuthDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	instance := findInstance(p.getConfiguration().getInstances(), r.URL.Query().Get("instance"))
+	if instance == nil {
+		http.Error(w, "Unknown Redmine instance", http.StatusBadRequest)
+		return
+	}
+
+	account, err := p.disconnectUserAccount(userID, instance)
+	if err != nil {
+		http.Error(w, "Failed to disconnect the Redmine account", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]bool{"disconnected": account != nil})
+}
+
+func (p *Plugin) newTokenClient(instance *redmineInstance, token *redmine.Token) (*redmine.Client, error) {
+	redmineURL, _ := getRedmineInstanceURL(instance.URL)
+	if redmineURL == "" {
+		return nil, fmt.Errorf("invalid Redmine instance URL %q", instance.URL)
+	}
+
+	return redmine.NewClient(redmineURL,
+		redmine.WithAccessToken(token.AccessToken),
+		redmine.WithHTTPClient(p.httpClient),
+	)
+}
+
+// getUserToken returns the OAuth2 token of an account, refreshing it when it is about to
+// expire. Refreshes are serialized across the cluster since Redmine rotates refresh tokens on
+// use, so that a concurrent refresh does not invalidate the token of the other.
+func (p *Plugin) getUserToken(userID string, instance *redmineInstance, account *userAccount) (*redmine.Token, error) {
+	secret := p.getConfiguration().EncryptionKey
+	token, err := account.token(secret)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Expired(tokenRefreshMargin) || token.RefreshToken == "" {
+		return token, nil
+	}
+
+	mutex, err := cluster.NewMutex(p.API, tokenRefreshMutexPrefix+userID+"_"+instanceKeyHash(instance.URL))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redmine.DefaultTimeout)
+	defer cancel()
+	if err := mutex.LockWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to lock the token refresh: %w", err)
+	}
+	defer mutex.Unlock()
+
+	// Another request may have refreshed the token while waiting for the lock.
+	current, err := p.getUserAccount(userID, instance)
+	if err != nil || current == nil {
+		return nil, errors.New("the account was disconnected")
+	}
+	if token, err = current.token(secret); err != nil {
+		return nil, err
+	}
+	if !token.Expired(tokenRefreshMargin) {
+		return token, nil
+	}
+
+	config, err := p.getOAuthConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+	client, err := p.newRedmineClient(instance, "")
+	if err != nil {
+		return nil, err
+	}
+	refreshed, err := client.RefreshToken(context.Background(), config, token.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	if err := current.setToken(secret, refreshed); err != nil {
+		return nil, err
+	}
+	if err := p.saveUserAccount(userID, current); err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+// revokeUserToken revokes the refresh token of an account, which also invalidates its
+// access tokens.
+func (p *Plugin) revokeUserToken(instance *redmineInstance, account *userAccount) error {
+	token, err := account.token(p.getConfiguration().EncryptionKey)
+	if err != nil {
+		return err
+	}
+	config, err := p.getOAuthConfig(instance)
+	if err != nil {
+		return err
+	}
+	client, err := p.newRedmineClient(instance, "")
+	if err != nil {
+		return err
+	}
+
+	revoke := token.RefreshToken
+	if revoke == "" {
+		revoke = token.AccessToken
+	}
+
+	return client.RevokeToken(context.Background(), config, revoke)
+}
+
+// writeOAuthPage renders the page shown in the browser at the end of the authorization.
+func writeOAuthPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>Redmine</title></head><body><p>%s</p></body></html>\n", html.EscapeString(message))
+}
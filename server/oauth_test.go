@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuthFlow(t *testing.T) {
+	server := newAccountsTestServer(t)
+	server.SetOAuthClient("client-id", "client-secret", 3600)
+	server.AddOAuthCode("code", 5)
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("https://chat.example.com/")}})
+	// Token refreshes hold a cluster mutex
+	api.On("KVSetWithOptions", "mutex_token_refresh_user-id_"+instanceKeyHash("https://redmine.example.com"), mock.Anything, mock.Anything).Return(true, nil)
+
+	plugin := newAccountsTestPlugin(server, false)
+	plugin.configuration.RedmineOAuthClientID = "client-id"
+	plugin.configuration.RedmineOAuthClientSecret = "client-secret"
+	plugin.SetAPI(api)
+	instance := plugin.getConfiguration().getInstances()[0]
+
+	serve := func(method, target, userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-ID", userID)
+		}
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+	message := "https://redmine.example.com/issues/2"
+	privateLink := `[Bug#2: Private issue](https://redmine.example.com/issues/2 "")`
+
+	response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: "/redmine connect", UserId: "user-id"})
+	require.Nil(t, appErr)
+	assert.Equal(t, "[Click here to connect your Redmine account](https://chat.example.com/plugins/"+manifest.Id+"/oauth/connect?instance=https%3A%2F%2Fredmine.example.com) on https://redmine.example.com.", response.Text)
+
+	w := serve(http.MethodGet, routeOAuthConnect+"?instance=https%3A%2F%2Fredmine.example.com", "user-id")
+	require.Equal(t, http.StatusFound, w.Code)
+	authorizeURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://redmine.example.com/oauth/authorize", authorizeURL.Scheme+"://"+authorizeURL.Host+authorizeURL.Path)
+	assert.Equal(t, "https://chat.example.com/plugins/"+manifest.Id+"/oauth/callback", authorizeURL.Query().Get("redirect_uri"))
+	state := authorizeURL.Query().Get("state")
+	require.NotEmpty(t, state)
+
+	t.Run("Callback of another user", func(t *testing.T) {
+		w := serve(http.MethodGet, routeOAuthCallback+"?code=code&state="+state, "other-user-id")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	// The state is single use, so start over.
+	w = serve(http.MethodGet, routeOAuthConnect+"?instance=https%3A%2F%2Fredmine.example.com", "user-id")
+	authorizeURL, err = url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	state = authorizeURL.Query().Get("state")
+
+	w = serve(http.MethodGet, routeOAuthCallback+"?code=code&state="+state, "user-id")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your Redmine account Jane Doe (jdoe) is now connected")
+
+	account, err := plugin.getUserAccount("user-id", instance)
+	require.NoError(t, err)
+	require.NotNil(t, account)
+	assert.Nil(t, account.EncryptedAPIKey)
+	assert.NotNil(t, account.EncryptedToken)
+
+	t.Run("Uses the access token", func(t *testing.T) {
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: message})
+		assert.Equal(t, privateLink, post.Message)
+	})
+
+	t.Run("Refreshes expired tokens", func(t *testing.T) {
+		token, err := account.token("secret")
+		require.NoError(t, err)
+		token.Expiry = time.Now().Add(-time.Minute)
+		require.NoError(t, account.setToken("secret", token))
+		require.NoError(t, plugin.saveUserAccount("user-id", account))
+		server.RevokeAccessTokens()
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: message})
+		assert.Equal(t, privateLink, post.Message)
+
+		refreshed, err := plugin.getUserAccount("user-id", instance)
+		require.NoError(t, err)
+		token, err = refreshed.token("secret")
+		require.NoError(t, err)
+		assert.False(t, token.Expired(tokenRefreshMargin))
+	})
+
+	t.Run("Failed refresh does not fall back to the instance API key", func(t *testing.T) {
+		api.On("LogWarn", "Failed to get Redmine OAuth2 token", "user_id", "user-id", "instance", "https://redmine.example.com", "err", mock.Anything).Once()
+		api.On("LogWarn", "Failed to create Redmine client", "instance", "https://redmine.example.com", "err", errAccountUnusable.Error()).Once()
+		plugin.configuration.AllowGlobalAPIKey = true
+		defer func() { plugin.configuration.AllowGlobalAPIKey = false }()
+
+		account, err := plugin.getUserAccount("user-id", instance)
+		require.NoError(t, err)
+		token, err := account.token("secret")
+		require.NoError(t, err)
+		token.Expiry = time.Now().Add(-time.Minute)
+		token.RefreshToken = "revoked"
+		require.NoError(t, account.setToken("secret", token))
+		require.NoError(t, plugin.saveUserAccount("user-id", account))
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", Message: "https://redmine.example.com/issues/1"})
+		assert.Equal(t, "https://redmine.example.com/issues/1", post.Message)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, routeOAuthDisconnect, "user-id").Code)
+
+		w := serve(http.MethodPost, routeOAuthDisconnect, "user-id")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"disconnected": true}`, w.Body.String())
+
+		account, err := plugin.getUserAccount("user-id", instance)
+		require.NoError(t, err)
+		assert.Nil(t, account)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, routeOAuthConnect, "").Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, routeOAuthCallback, "").Code)
+	})
+}
@@ -1,19 +1,22 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
-
 	"sync"
 
-	"github.com/dlclark/regexp2"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
 )
 
 // Plugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
@@ -26,203 +29,518 @@ type Plugin struct {
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
 	configuration *configuration
+
+	// httpClient is used for all requests to Redmine. A client with redmine.DefaultTimeout
+	// is used when nil.
+	httpClient *http.Client
+
+	// client is the Mattermost plugin API wrapper, available once the plugin is activated.
+	client *pluginapi.Client
+
+	// issueCache stores recently fetched issues. It is nil until the plugin is activated.
+	issueCache *issueCache
+
+	// resourceCache stores recently rendered resource links. It is nil until the plugin is
+	// activated.
+	resourceCache *resourceCache
+
+	// botUserID is the user posting on behalf of the plugin.
+	botUserID string
+
+	// kvStore persists linked user accounts. It is nil until the plugin is activated.
+	kvStore KVStore
+
+	// issuePostsLock serializes changes to the stored posts referring to issues.
+	issuePostsLock sync.Mutex
+
+	// refreshingPosts holds the messages of the posts being updated by refreshPost, keyed by
+	// post ID.
+	refreshingPosts sync.Map
+
+	// pollJob polls the subscribed projects. It is nil until the plugin is activated.
+	pollJob *cluster.Job
+}
+
+// OnActivate is invoked when the plugin is activated.
+func (p *Plugin) OnActivate() error {
+	p.client = pluginapi.NewClient(p.API, p.Driver)
+	p.kvStore = &p.client.KV
+	p.issueCache = newIssueCache(p.kvStore, issueCacheCapacity, p.getConfiguration().issueCacheTTL())
+	p.resourceCache = newResourceCache(resourceCacheCapacity)
+
+	if err := p.ensureGeneratedSettings(); err != nil {
+		return fmt.Errorf("failed to generate settings: %w", err)
+	}
+
+	botUserID, err := p.client.Bot.EnsureBot(&model.Bot{
+		Username:    "redmine",
+		DisplayName: "Redmine",
+		Description: "Created by the Redmine Link plugin.",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ensure bot account: %w", err)
+	}
+	p.botUserID = botUserID
+
+	if err := p.client.SlashCommand.Register(getCommand()); err != nil {
+		return fmt.Errorf("failed to register command: %w", err)
+	}
+
+	if err := p.schedulePollJob(); err != nil {
+		return fmt.Errorf("failed to schedule the subscription poll job: %w", err)
+	}
+
+	return nil
+}
+
+// OnDeactivate is invoked when the plugin is deactivated.
+func (p *Plugin) OnDeactivate() error {
+	if p.pollJob != nil {
+		if err := p.pollJob.Close(); err != nil {
+			return fmt.Errorf("failed to stop the subscription poll job: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func parseLink(link string) (map[string]string, error) {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
 	u, err := url.Parse(link)
 	if err != nil {
 		return nil, err
 	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in %q", link)
+	}
 
-	if u.Scheme == "" {
-		u.Scheme = "https"
-		u, _ = url.Parse(u.String())
+	// Drop default ports so that links with and without them are treated alike.
+	host := u.Host
+	if (u.Scheme == "https" && u.Port() == "443") || (u.Scheme == "http" && u.Port() == "80") {
+		host = u.Hostname()
 	}
 
 	return map[string]string{
 		"Scheme": u.Scheme,
-		"Host":   u.Hostname(),
+		"Host":   host,
 		"Path":   u.Path,
 		"Hash":   u.Fragment,
 	}, nil
 }
 
-func extractTrackerLinks(input string, redmineHost string) []string {
-	var matches []string
-
-	pattern := `(?<!\]\()(?:https?:\/\/|(?<!\S)|(?<!\W))` + regexp.QuoteMeta(redmineHost) + `\/issues\/\d+(?:\?[\w-]+(?:=[\w-]*)?(?:&[\w-]+(?:=[\w-]*)?)*)?(?:#note-\d+)?(?![^\[]*\])`
-	re := regexp2.MustCompile(pattern, 0)
+// issueIDFromPath returns the issue ID of an issue URL path such as /redmine/issues/123.
+func issueIDFromPath(path string) string {
+	index := strings.LastIndex(path, "/issues/")
+	if index == -1 {
+		return ""
+	}
 
-	match, _ := re.FindStringMatch(input)
+	return path[index+len("/issues/"):]
+}
 
-	for match != nil {
-		matches = append(matches, match.String())
-		match, _ = re.FindNextMatch(match)
+// getRedmineInstanceURL returns the API base URL of an instance, always ending with a slash,
+// and the URL without its scheme as used in links. Both keep the port and any sub-path the
+// instance is hosted under.
+func getRedmineInstanceURL(instanceURL string) (string, string) {
+	if instanceURL == "" {
+		return "", ""
 	}
-	return matches
+	parsedURL, err := parseLink(strings.TrimSpace(instanceURL))
+	if err != nil {
+		return "", ""
+	}
+	host := parsedURL["Host"] + strings.TrimSuffix(parsedURL["Path"], "/")
+	return fmt.Sprintf("%s://%s/", parsedURL["Scheme"], host), host
 }
 
-func processIssuesResponse(issuesResponse *IssuesResponse) map[string]map[string]string {
-	issuesMap := make(map[string]map[string]string)
-
-	for _, issue := range issuesResponse.Issues {
-		issueID := fmt.Sprintf("%d", issue.ID)
-		issuesMap[issueID] = map[string]string{
-			"ID":         issueID,
-			"Subject":    issue.Subject,
-			"Status":     issue.Status.Name,
-			"Tracker":    issue.Tracker.Name,
-			"AssignedTo": issue.AssignedTo.Name,
-			"Priority":   issue.Priority.Name,
-			"UpdatedOn":  issue.UpdatedOn,
-			"Author":     issue.Author.Name,
+// getIssuesData fetches the given issues of the instance with the client, keyed by their ID.
+// Unknown issues and issues the client cannot see are omitted. When the batch request fails,
+// the issues it did not return are fetched one by one, so that a single failing issue does not
+// leave every other link of the message unexpanded. Issues that cannot be fetched are omitted
+// and logged.
+func (p *Plugin) getIssuesData(client *userClient, instance *redmineInstance, issueIDs []string) map[string]redmine.Issue {
+	ids := make([]int, 0, len(issueIDs))
+	for _, issueID := range issueIDs {
+		id, err := strconv.Atoi(issueID)
+		if err != nil {
+			continue
 		}
+		ids = append(ids, id)
 	}
-	return issuesMap
-}
 
-func formatAdditionalData(issueData map[string]string) string {
-	assignee := "Assignee: Unassigned"
-	if issueData["AssignedTo"] != "" {
-		assignee = "Assignee: " + issueData["AssignedTo"]
+	// Only issues fetched with the API key of the instance are cached, so that issues fetched
+	// with a personal API key are never shown to other users.
+	var issues []redmine.Issue
+	missing := ids
+	if client.shared {
+		issues, missing = p.getCachedIssues(client.BaseURL(), ids)
+	}
+	if len(missing) == 0 {
+		return issuesByID(issues)
 	}
-	status := "Status: " + issueData["Status"]
-	prioity := "Priority: " + issueData["Priority"]
-	author := "Author: " + issueData["Author"]
 
-	t, _ := time.Parse(time.RFC3339, issueData["UpdatedOn"])
-	loc, _ := time.LoadLocation("Europe/Kyiv")
-	updatedAt := "Last update: " + t.In(loc).Format(time.RFC1123)
+	// https://www.redmine.org/issues.json?issue_id=1,2,3&status_id=*&limit=100&offset=0
+	fetched, err := client.GetIssuesByIDs(context.Background(), missing)
+	switch {
+	case err == nil:
+	case !client.authenticated && errors.Is(err, redmine.ErrUnauthorized):
+		// The instance requires a login and the viewer may not use any credentials
+		p.API.LogDebug("Redmine requires authentication to fetch issues", "instance", instance.URL, "issue_ids", joinIDs(missing))
+	default:
+		p.API.LogWarn("Failed to fetch issues, fetching them one by one", "instance", instance.URL, "issue_ids", joinIDs(missing), "err", err.Error())
+		fetched = append(fetched, p.getIssuesOneByOne(client, instance, missing, fetched, err)...)
+	}
+	if client.shared {
+		p.cacheIssues(client.BaseURL(), fetched)
+	}
 
-	return strings.Join([]string{assignee, prioity, status, author, updatedAt}, "&#013;")
+	return issuesByID(append(issues, fetched...))
 }
 
-func createTransformedLink(subject, url, anchor string, issueData map[string]string) string {
-	additionalData := formatAdditionalData(issueData)
-	trackerAndID := fmt.Sprintf("%s#%s", issueData["Tracker"], issueData["ID"])
+// maxSingleIssueRequests caps the number of issues fetched one by one for a message when the
+// batch request fails, so that a failing Redmine does not hold the post for long.
+const maxSingleIssueRequests = 10
+
+// getIssuesOneByOne fetches the issues of ids missing from fetched after the batch request
+// failed with err. Nothing is fetched when the batch request did not reach Redmine, the
+// credentials were rejected or Redmine is unavailable. At most maxSingleIssueRequests issues
+// are fetched, and the remaining ones are skipped as soon as Redmine becomes unavailable.
+// Internal server errors are usually caused by a single issue, so the others are still fetched.
+func (p *Plugin) getIssuesOneByOne(client *userClient, instance *redmineInstance, ids []int, fetched []redmine.Issue, err error) []redmine.Issue {
+	var apiErr *redmine.APIError
+	if !errors.As(err, &apiErr) || errors.Is(err, redmine.ErrUnauthorized) || unavailable(apiErr) {
+		return nil
+	}
+
+	found := make(map[int]bool, len(fetched))
+	for _, issue := range fetched {
+		found[issue.ID] = true
+	}
+
+	var issues []redmine.Issue
+	requests := 0
+	for _, id := range ids {
+		if found[id] {
+			continue
+		}
+		if requests == maxSingleIssueRequests {
+			p.API.LogWarn("Too many issues failed to fetch, skipping the others", "instance", instance.URL, "issue_id", id)
+			break
+		}
+		requests++
+
+		issue, err := client.GetIssue(context.Background(), id)
+		if err != nil {
+			if errors.Is(err, redmine.ErrNotFound) || errors.Is(err, redmine.ErrForbidden) {
+				continue
+			}
+			p.API.LogWarn("Failed to fetch issue", "instance", instance.URL, "issue_id", id, "err", err.Error())
 
-	return fmt.Sprintf("[%s: %s%s](%s %q)", trackerAndID, subject, anchor, url, additionalData)
+			if !errors.As(err, &apiErr) || unavailable(apiErr) {
+				// Redmine is unreachable, the other requests would fail too
+				break
+			}
+			continue
+		}
+		issues = append(issues, *issue)
+	}
+
+	return issues
 }
 
-func (p *Plugin) getRedmineInstanceURL() (string, string) {
-	configuration := p.getConfiguration()
-	if configuration.RedmineInstanceURL == "" {
-		return "", ""
+// unavailable reports whether the error is returned by Redmine or its proxy while the service
+// is down, rather than for a particular request.
+func unavailable(apiErr *redmine.APIError) bool {
+	switch apiErr.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
 	}
-	parsedURL, err := parseLink(configuration.RedmineInstanceURL)
-	if err != nil {
-		return "", ""
+}
+
+// joinIDs formats issue IDs for log lines.
+func joinIDs(ids []int) string {
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, strconv.Itoa(id))
 	}
-	return fmt.Sprintf("%s://%s/", parsedURL["Scheme"], parsedURL["Host"]), parsedURL["Host"]
+
+	return strings.Join(strs, ",")
 }
 
-func (p *Plugin) getIssuesData(issueIDs []string) (map[string]map[string]string, error) {
-	configuration := p.getConfiguration()
+func issuesByID(issues []redmine.Issue) map[string]redmine.Issue {
+	issuesMap := make(map[string]redmine.Issue, len(issues))
+	for _, issue := range issues {
+		issuesMap[strconv.Itoa(issue.ID)] = issue
+	}
 
-	idsParam := strings.Join(issueIDs, ",")
-	// https://www.redmine.org/issues.json?issue_id=1,2,3&status_id=*
-	redmineURL, _ := p.getRedmineInstanceURL()
-	url := fmt.Sprintf("%s%s?issue_id=%s&status_id=*", redmineURL, "issues.json", idsParam)
+	return issuesMap
+}
 
-	req, _ := http.NewRequest("GET", url, nil)
+// getCachedIssues splits ids into the issues found in the cache and the IDs that still
+// have to be fetched from Redmine.
+func (p *Plugin) getCachedIssues(instanceURL string, ids []int) ([]redmine.Issue, []int) {
+	if p.issueCache == nil || !p.issueCache.enabled() {
+		return nil, ids
+	}
 
-	if configuration.RedmineAPIKey != "" {
-		req.Header.Set("X-Redmine-API-Key", configuration.RedmineAPIKey)
+	var issues []redmine.Issue
+	var missing []int
+	for _, id := range ids {
+		if issue, ok := p.issueCache.Get(instanceURL, id); ok {
+			issues = append(issues, issue)
+		} else {
+			missing = append(missing, id)
+		}
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	return issues, missing
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to make API request: %w", err)
+func (p *Plugin) cacheIssues(instanceURL string, issues []redmine.Issue) {
+	if p.issueCache == nil || !p.issueCache.enabled() {
+		return
+	}
+
+	for _, issue := range issues {
+		if err := p.issueCache.Set(instanceURL, issue); err != nil {
+			p.API.LogWarn("Failed to cache issue", "instance", instanceURL, "issue_id", issue.ID, "err", err.Error())
+		}
 	}
+}
 
-	defer resp.Body.Close()
+// transformMessageLinks replaces the references found in message with links rendered from the
+// issues as seen by the viewer, fetched with one batch request per instance. It also returns the referenced issues
+// that were found, in order of appearance and without duplicates.
+func (p *Plugin) transformMessageLinks(message string, references []issueReference, viewer issueViewer, dates dateFormatter) (string, []referencedIssue) {
+	rendered, referenced := p.renderIssueReferences(references, viewer, dates)
 
-	var issuesResponse *IssuesResponse
-	err = json.NewDecoder(resp.Body).Decode(&issuesResponse)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+	return replaceLinks(message, issueLinkReplacements(rendered)), referenced
+}
+
+// linkReplacement is a markdown link replacing the part of a message between the byte offsets
+// start and end.
+type linkReplacement struct {
+	start, end int
+	link       string
+}
+
+func issueLinkReplacements(rendered []renderedReference) []linkReplacement {
+	replacements := make([]linkReplacement, 0, len(rendered))
+	for _, reference := range rendered {
+		replacements = append(replacements, linkReplacement{start: reference.start, end: reference.end, link: reference.link})
 	}
 
-	return processIssuesResponse(issuesResponse), nil
+	return replacements
 }
 
-// todo: rewritethis to markdown.Inspect?
-func (p *Plugin) transformMessageLinks(message string, links []string) string {
-	if len(links) == 0 {
+// replaceLinks applies the replacements to message. Issue and resource replacements are found
+// separately, so like removeOverlappingReferences, the longest of replacements starting at the
+// same position is kept and those overlapping an earlier one are dropped.
+func replaceLinks(message string, replacements []linkReplacement) string {
+	if len(replacements) == 0 {
 		return message
 	}
+	sort.SliceStable(replacements, func(i, j int) bool {
+		if replacements[i].start != replacements[j].start {
+			return replacements[i].start < replacements[j].start
+		}
+		return replacements[i].end > replacements[j].end
+	})
 
 	var builder strings.Builder
 	startIndex := 0
-	issuesIDs := make([]string, 0, len(links))
-	issuesHashes := make([]string, 0, len(links))
+	for _, replacement := range replacements {
+		if replacement.start < startIndex {
+			continue
+		}
+		builder.WriteString(message[startIndex:replacement.start])
+		builder.WriteString(replacement.link)
+		startIndex = replacement.end
+	}
 
-	// Collect issue IDs from links
-	for _, link := range links {
-		parsedLink, _ := parseLink(link)
-		issueID := strings.TrimPrefix(parsedLink["Path"], "/issues/")
+	// Append remaining part of the message
+	builder.WriteString(message[startIndex:])
 
-		issuesIDs = append(issuesIDs, issueID)
-		issuesHashes = append(issuesHashes, parsedLink["Hash"])
-	}
+	return builder.String()
+}
 
-	// Get issue names for all issue IDs in a single API request
-	issuesData, err := p.getIssuesData(issuesIDs)
+// renderedReference is a reference rendered as a markdown link.
+type renderedReference struct {
+	issueReference
 
-	if err != nil {
-		// If there is an error fetching issue names, return the original message
-		return message
+	issue redmine.Issue
+	link  string
+}
+
+// renderIssueReferences renders the references to issues the viewer may see, fetched with one
+// batch request per instance. References that cannot be rendered are left out. It also returns
+// the referenced issues that were found, in order of appearance and without duplicates.
+func (p *Plugin) renderIssueReferences(references []issueReference, viewer issueViewer, dates dateFormatter) ([]renderedReference, []referencedIssue) {
+	if len(references) == 0 {
+		return nil, nil
+	}
+
+	// Collect issue IDs per instance
+	var instances []*redmineInstance
+	issuesIDs := make(map[string][]string)
+	for _, reference := range references {
+		if _, ok := issuesIDs[reference.instance.URL]; !ok {
+			instances = append(instances, reference.instance)
+		}
+		issuesIDs[reference.instance.URL] = append(issuesIDs[reference.instance.URL], reference.issueID)
 	}
 
-	// Transform message links based on the fetched issue names
-	for i, link := range links {
-		linkIndex := strings.Index(message[startIndex:], link)
-		if linkIndex == -1 {
+	// Get issues for all issue IDs of an instance in a single API request
+	issuesData := make(map[string]map[string]redmine.Issue, len(instances))
+	clients := make(map[string]*userClient, len(instances))
+	for _, instance := range instances {
+		client, err := p.getViewerClient(instance, viewer)
+		if err != nil {
+			p.API.LogWarn("Failed to create Redmine client", "instance", instance.URL, "err", err.Error())
+		}
+		if client == nil {
+			// Without credentials allowed for the viewer, keep the references of the instance
 			continue
 		}
+		clients[instance.URL] = client
+		// References to issues that could not be fetched are kept as written
+		issuesData[instance.URL] = p.getIssuesData(client, instance, issuesIDs[instance.URL])
+	}
 
-		linkIndex += startIndex
-		builder.WriteString(message[startIndex:linkIndex])
+	var rendered []renderedReference
+	var referenced []referencedIssue
+	seen := make(map[string]bool, len(references))
+	journals := make(map[string][]redmine.Journal)
 
-		issueData := issuesData[issuesIDs[i]]
+	for _, reference := range references {
+		issue, ok := issuesData[reference.instance.URL][reference.issueID]
+		if !ok || (viewer.hidePrivate && issue.IsPrivate) {
+			// If the issue is not found or must not be disclosed, keep the original reference
+			continue
+		}
 
-		if issueData["Subject"] == "" {
-			// If issue subject is not found, use the original link
-			builder.WriteString(link)
-		} else {
-			hash := ""
-			if issuesHashes[i] != "" {
-				hash = "#" + issuesHashes[i]
-			}
+		// Links to a note show it, from the history fetched with the same credentials
+		var note *linkNote
+		if number := noteNumber(reference.anchor); number > 0 {
+			note = p.getLinkNote(clients[reference.instance.URL], reference.instance, issue, number, journals)
+		}
 
-			// Create transformed link with issue subject
-			transformedLink := createTransformedLink(issueData["Subject"], link, hash, issueData)
-			builder.WriteString(transformedLink)
+		transformedLink, err := p.renderIssueLink(issue, reference.instance, reference.url, reference.anchor, note, dates)
+		if err != nil {
+			p.API.LogWarn("Failed to render issue link", "instance", reference.instance.URL, "issue_id", issue.ID, "err", err.Error())
+			continue
 		}
+		rendered = append(rendered, renderedReference{issueReference: reference, issue: issue, link: transformedLink})
 
-		// Update start index for the next iteration
-		startIndex = linkIndex + len(link)
+		if key := reference.instance.URL + "#" + reference.issueID; !seen[key] {
+			seen[key] = true
+			referenced = append(referenced, referencedIssue{Issue: issue, instance: reference.instance, note: note})
+		}
 	}
 
-	// Append remaining part of the message
-	builder.WriteString(message[startIndex:])
+	return rendered, referenced
+}
 
-	return builder.String()
+// renderIssueLink renders the markdown link replacing an issue URL using the configured templates.
+// note is the journal linked by the anchor, if any.
+func (p *Plugin) renderIssueLink(issue redmine.Issue, instance *redmineInstance, link, anchor string, note *linkNote, dates dateFormatter) (string, error) {
+	text, tooltip, err := p.getConfiguration().getLinkTemplates().render(linkData{
+		Issue:    issue,
+		Instance: instance.Label,
+		URL:      link,
+		Anchor:   anchor,
+		Note:     note,
+		dates:    dates,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return createTransformedLink(text, link, tooltip), nil
 }
 
 func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*model.Post, string) {
-	newPost := post.Clone()
-	redmineURL, redmineHost := p.getRedmineInstanceURL()
+	return p.renderPost(post.Clone()), ""
+}
 
-	if redmineURL != "" {
-		newPost.Message = p.transformMessageLinks(newPost.Message, extractTrackerLinks(newPost.Message, redmineHost))
+// MessageWillBeUpdated renders the edited message. Edits of the text replace the original
+// message kept for refreshing the post, while updates made by refreshPost are kept as is.
+func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (*model.Post, string) {
+	if p.isRefreshUpdate(newPost) {
+		return newPost, ""
+	}
+
+	post := newPost.Clone()
+	if post.Message != oldPost.Message {
+		post.DelProp(originalMessageProp)
 	}
-	return newPost, ""
+
+	return p.renderPost(post), ""
 }
 
-func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (*model.Post, string) {
-	return p.MessageWillBePosted(c, newPost)
+// MessageHasBeenPosted remembers the issues the post refers to, so that it is refreshed when
+// they change.
+func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	p.trackIssuePosts(post)
+}
+
+// MessageHasBeenUpdated remembers the issues the edited post refers to.
+func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	p.trackIssuePosts(newPost)
+}
+
+// renderPost renders the issue references of the post as links, attachments or previews. The
+// message as written is kept in originalMessageProp when links are rewritten, and rendered again
+// from there.
+func (p *Plugin) renderPost(post *model.Post) *model.Post {
+	configuration := p.getConfiguration()
+	dates := p.getDateFormatter(post.UserId)
+
+	scope := p.newChannelScope(post.ChannelId)
+	viewer, ok := p.postViewer(post.UserId, scope)
+	if !ok {
+		return post
+	}
+
+	source := originalMessage(post)
+	references := p.findIssueReferences(source, scope)
+	// Resources have no cards, so their links are rendered in every mode.
+	resources := p.renderResourceReferences(p.findResourceReferences(source, scope), viewer, dates)
+	if configuration.showPreviews() {
+		rendered, _ := p.renderIssueReferences(references, viewer, dates)
+		post.Message = source
+		post.DelProp(originalMessageProp)
+		setIssuePreviews(post, rendered, resources)
+		if attachmentCount(post) > 0 {
+			setIssueAttachments(post, nil, configuration.MaxAttachments, dates)
+		}
+		return post
+	}
+	post.DelProp(issuePreviewsProp)
+
+	rendered, referenced := p.renderIssueReferences(references, viewer, dates)
+	replacements := resources
+	if configuration.showInlineLinks() {
+		replacements = append(issueLinkReplacements(rendered), resources...)
+	}
+	message := replaceLinks(source, replacements)
+	post.Message = message
+	if message != source {
+		post.AddProp(originalMessageProp, source)
+	} else {
+		post.DelProp(originalMessageProp)
+	}
+
+	if configuration.showAttachments() {
+		setIssueAttachments(post, referenced, configuration.MaxAttachments, dates)
+	}
+
+	return post
 }
 
 // See https://developers.mattermost.com/extend/plugins/server/reference/
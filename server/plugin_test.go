@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
 )
 
 type TestCase struct {
@@ -15,11 +24,66 @@ type TestCase struct {
 	OldMessage      string
 }
 
+// Copies of a few www.redmine.org issues served by newTestRedmineServer.
+var (
+	issue40556 = redmine.Issue{
+		ID:         40556,
+		Tracker:    redmine.IssueProperty{ID: 2, Name: "Feature"},
+		Status:     redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true},
+		Priority:   redmine.IssueProperty{ID: 4, Name: "Normal"},
+		Author:     redmine.IssueProperty{ID: 1, Name: "Yasu Saku"},
+		AssignedTo: redmine.IssueProperty{ID: 2, Name: "Marius BĂLTEANU"},
+		Subject:    "Focus on the textarea after clicking the Edit Journal button",
+		UpdatedOn:  "2024-04-29T19:23:49Z",
+	}
+	issue40559 = redmine.Issue{
+		ID:         40559,
+		Tracker:    redmine.IssueProperty{ID: 3, Name: "Patch"},
+		Status:     redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true},
+		Priority:   redmine.IssueProperty{ID: 4, Name: "Normal"},
+		Author:     redmine.IssueProperty{ID: 3, Name: "Katsuya HIDAKA"},
+		AssignedTo: redmine.IssueProperty{ID: 2, Name: "Marius BĂLTEANU"},
+		Subject:    "Fix incorrect icon image paths for Wiki help pages",
+		UpdatedOn:  "2024-04-16T19:26:17Z",
+	}
+	issue40538 = redmine.Issue{
+		ID:        40538,
+		Tracker:   redmine.IssueProperty{ID: 3, Name: "Patch"},
+		Status:    redmine.Status{IssueProperty: redmine.IssueProperty{ID: 6, Name: "Reopened"}},
+		Priority:  redmine.IssueProperty{ID: 4, Name: "Normal"},
+		Author:    redmine.IssueProperty{ID: 4, Name: "Enzo Pellecchia"},
+		Subject:   "Hi, can you help me with a Version Extended?",
+		UpdatedOn: "2024-04-09T10:42:41Z",
+	}
+)
+
+// newTestRedmineServer starts a fake Redmine serving the issues above.
+func newTestRedmineServer(t *testing.T) *redminetest.Server {
+	server := redminetest.NewServer(t)
+	server.AddIssue(issue40556)
+	server.AddIssue(issue40559)
+	server.AddIssue(issue40538)
+
+	return server
+}
+
+// expectedLink renders an issue link with the default templates and date format.
+func expectedLink(url, anchor string, issue redmine.Issue) string {
+	text, tooltip, err := defaultLinkTemplates.render(linkData{Issue: issue, URL: url, Anchor: anchor, dates: defaultDateFormatter})
+	if err != nil {
+		panic(err)
+	}
+
+	return createTransformedLink(text, url, tooltip)
+}
+
 func TestMessagehooks(t *testing.T) {
+	server := newTestRedmineServer(t)
 	plugin := &Plugin{
 		configuration: &configuration{
 			RedmineInstanceURL: "https://www.redmine.org",
 		},
+		httpClient: server.HTTPClient(),
 	}
 
 	// Nested map to group test cases for each hook
@@ -31,131 +95,33 @@ func TestMessagehooks(t *testing.T) {
 				ExpectedMessage: "This is a test message without any tracker links.",
 			},
 			{
-				Description:  "Single tracker link",
-				InputMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/40556",
-				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", createTransformedLink(
-					"Focus on the textarea after clicking the Edit Journal button",
-					"https://www.redmine.org/issues/40556",
-					"",
-					map[string]string{
-						"Subject":    "Focus on the textarea after clicking the Edit Journal button",
-						"Status":     "Closed",
-						"Priority":   "Normal",
-						"UpdatedOn":  "2024-04-29T19:23:49Z",
-						"AssignedTo": "Marius BĂLTEANU",
-						"Tracker":    "Feature",
-						"ID":         "40556",
-						"Author":     "Yasu Saku",
-					},
-				)),
+				Description:     "Single tracker link",
+				InputMessage:    "This is a test message with a tracker link: https://www.redmine.org/issues/40556",
+				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", expectedLink("https://www.redmine.org/issues/40556", "", issue40556)),
 			},
 			{
 				Description:  "Multiple tracker links",
 				InputMessage: "This is a test message with multiple tracker links: https://www.redmine.org/issues/40556 and https://www.redmine.org/issues/40559",
 				ExpectedMessage: fmt.Sprintf("This is a test message with multiple tracker links: %s and %s",
-					createTransformedLink(
-						"Focus on the textarea after clicking the Edit Journal button",
-						"https://www.redmine.org/issues/40556",
-						"",
-						map[string]string{
-							"Subject":    "Focus on the textarea after clicking the Edit Journal button",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-29T19:23:49Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Feature",
-							"ID":         "40556",
-							"Author":     "Yasu Saku",
-						},
-					),
-					createTransformedLink(
-						"Fix incorrect icon image paths for Wiki help pages",
-						"https://www.redmine.org/issues/40559",
-						"",
-						map[string]string{
-							"Subject":    "Fix incorrect icon image paths for Wiki help pages",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-16T19:26:17Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Patch",
-							"ID":         "40559",
-							"Author":     "Katsuya HIDAKA",
-						},
-					),
+					expectedLink("https://www.redmine.org/issues/40556", "", issue40556),
+					expectedLink("https://www.redmine.org/issues/40559", "", issue40559),
 				),
 			},
 			{
 				Description:  "Multiple tracker links with markdown links",
 				InputMessage: "This is a test message with multiple tracker links: [a link](https://www.redmine.org/issues/40556) and https://www.redmine.org/issues/40559 and https://www.redmine.org/issues/999999 and https://www.redmine.org/issues/40559",
 				ExpectedMessage: fmt.Sprintf("This is a test message with multiple tracker links: [a link](https://www.redmine.org/issues/40556) and %s and %s and %s",
-					createTransformedLink(
-						"Fix incorrect icon image paths for Wiki help pages",
-						"https://www.redmine.org/issues/40559",
-						"",
-						map[string]string{
-							"Subject":    "Fix incorrect icon image paths for Wiki help pages",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-16T19:26:17Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Patch",
-							"ID":         "40559",
-							"Author":     "Katsuya HIDAKA",
-						},
-					),
+					expectedLink("https://www.redmine.org/issues/40559", "", issue40559),
 					"https://www.redmine.org/issues/999999",
-					createTransformedLink(
-						"Fix incorrect icon image paths for Wiki help pages",
-						"https://www.redmine.org/issues/40559",
-						"",
-						map[string]string{
-							"Subject":    "Fix incorrect icon image paths for Wiki help pages",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-16T19:26:17Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Patch",
-							"ID":         "40559",
-							"Author":     "Katsuya HIDAKA",
-						},
-					),
+					expectedLink("https://www.redmine.org/issues/40559", "", issue40559),
 				),
 			},
 			{
 				Description:  "Tracker links with http protocol and without protocol",
 				InputMessage: "This is a test message with an http tracker link: http://www.redmine.org/issues/40556 and without protocol www.redmine.org/issues/40556",
 				ExpectedMessage: fmt.Sprintf("This is a test message with an http tracker link: %s and without protocol %s",
-					createTransformedLink(
-						"Focus on the textarea after clicking the Edit Journal button",
-						"http://www.redmine.org/issues/40556",
-						"",
-						map[string]string{
-							"Subject":    "Focus on the textarea after clicking the Edit Journal button",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-29T19:23:49Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Feature",
-							"ID":         "40556",
-							"Author":     "Yasu Saku",
-						},
-					),
-					createTransformedLink(
-						"Focus on the textarea after clicking the Edit Journal button",
-						"www.redmine.org/issues/40556",
-						"",
-						map[string]string{
-							"Subject":    "Focus on the textarea after clicking the Edit Journal button",
-							"Status":     "Closed",
-							"Priority":   "Normal",
-							"UpdatedOn":  "2024-04-29T19:23:49Z",
-							"AssignedTo": "Marius BĂLTEANU",
-							"Tracker":    "Feature",
-							"ID":         "40556",
-							"Author":     "Yasu Saku",
-						},
-					),
+					expectedLink("http://www.redmine.org/issues/40556", "", issue40556),
+					expectedLink("www.redmine.org/issues/40556", "", issue40556),
 				),
 			},
 			{
@@ -164,80 +130,52 @@ func TestMessagehooks(t *testing.T) {
 				ExpectedMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/999999",
 			},
 			{
-				Description:  "Tracker link with a note anchor",
-				InputMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/40538#note-4",
-				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", createTransformedLink(
-					"Hi, can you help me with a Version Extended?",
-					"https://www.redmine.org/issues/40538#note-4",
-					"#note-4",
-					map[string]string{
-						"Subject":   "Hi, can you help me with a Version Extended?",
-						"Status":    "Reopened",
-						"Priority":  "Normal",
-						"UpdatedOn": "2024-04-09T10:42:41Z",
-						"Tracker":   "Patch",
-						"ID":        "40538",
-						"Author":    "Enzo Pellecchia",
-					},
-				)),
+				Description:     "Tracker link with a note anchor",
+				InputMessage:    "This is a test message with a tracker link: https://www.redmine.org/issues/40538#note-4",
+				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", expectedLink("https://www.redmine.org/issues/40538#note-4", "#note-4", issue40538)),
+			},
+			{
+				Description:     "Tracker link with query params",
+				InputMessage:    "This is a test message with a tracker link: https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506",
+				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", expectedLink("https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506", "", issue40538)),
+			},
+			{
+				Description:     "Tracker link with query params and note anchor",
+				InputMessage:    "This is a test message with a tracker link: https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506#note-4",
+				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", expectedLink("https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506#note-4", "#note-4", issue40538)),
+			},
+			{
+				Description:     "Tracker links in inline code and code blocks",
+				InputMessage:    "Run `curl https://www.redmine.org/issues/40556.json` or\n```\ncurl https://www.redmine.org/issues/40559\n```\n\n    https://www.redmine.org/issues/40538",
+				ExpectedMessage: "Run `curl https://www.redmine.org/issues/40556.json` or\n```\ncurl https://www.redmine.org/issues/40559\n```\n\n    https://www.redmine.org/issues/40538",
 			},
 			{
-				Description:  "Tracker link with query params",
-				InputMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506",
-				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", createTransformedLink(
-					"Hi, can you help me with a Version Extended?",
-					"https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506",
-					"",
-					map[string]string{
-						"Subject":   "Hi, can you help me with a Version Extended?",
-						"Status":    "Reopened",
-						"Priority":  "Normal",
-						"UpdatedOn": "2024-04-09T10:42:41Z",
-						"Tracker":   "Patch",
-						"ID":        "40538",
-						"Author":    "Enzo Pellecchia",
-					},
-				)),
+				Description:     "Tracker link in a block quote",
+				InputMessage:    "> https://www.redmine.org/issues/40556\n\nhttps://www.redmine.org/issues/40559",
+				ExpectedMessage: fmt.Sprintf("> https://www.redmine.org/issues/40556\n\n%s", expectedLink("https://www.redmine.org/issues/40559", "", issue40559)),
 			},
 			{
-				Description:  "Tracker link with query params and note anchor",
-				InputMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506#note-4",
-				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", createTransformedLink(
-					"Hi, can you help me with a Version Extended?",
-					"https://www.redmine.org/issues/40538?issue_count=453&issue_position=2&next_issue_id=40506#note-4",
-					"#note-4",
-					map[string]string{
-						"Subject":   "Hi, can you help me with a Version Extended?",
-						"Status":    "Reopened",
-						"Priority":  "Normal",
-						"UpdatedOn": "2024-04-09T10:42:41Z",
-						"Tracker":   "Patch",
-						"ID":        "40538",
-						"Author":    "Enzo Pellecchia",
-					},
-				)),
+				Description:     "Tracker link in angle brackets",
+				InputMessage:    "See <https://www.redmine.org/issues/40556> and (https://www.redmine.org/issues/40559)",
+				ExpectedMessage: fmt.Sprintf("See <https://www.redmine.org/issues/40556> and (%s)", expectedLink("https://www.redmine.org/issues/40559", "", issue40559)),
+			},
+			{
+				Description:     "Tracker link as the text of a markdown link",
+				InputMessage:    "[https://www.redmine.org/issues/40556](https://example.com) and ![https://www.redmine.org/issues/40559](https://example.com/image.png)",
+				ExpectedMessage: "[https://www.redmine.org/issues/40556](https://example.com) and ![https://www.redmine.org/issues/40559](https://example.com/image.png)",
+			},
+			{
+				Description:     "Longer URL starting with a tracker link",
+				InputMessage:    "https://www.redmine.org/issues/40556/relations and https://www.redmine.org/issues/40559.",
+				ExpectedMessage: fmt.Sprintf("https://www.redmine.org/issues/40556/relations and %s.", expectedLink("https://www.redmine.org/issues/40559", "", issue40559)),
 			},
 		},
 		"MessageWillBeUpdated": {
 			{
-				Description:  "Message updated with a single tracker link",
-				OldMessage:   "this is an old message",
-				InputMessage: "This is a test message with a tracker link: https://www.redmine.org/issues/40556",
-				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", createTransformedLink(
-					"Focus on the textarea after clicking the Edit Journal button",
-					"https://www.redmine.org/issues/40556",
-					"",
-					map[string]string{
-						"Subject":    "Focus on the textarea after clicking the Edit Journal button",
-						"Status":     "Closed",
-						"Priority":   "Normal",
-						"UpdatedOn":  "2024-04-29T19:23:49Z",
-						"AssignedTo": "Marius BĂLTEANU",
-						"Tracker":    "Feature",
-						"ID":         "40556",
-						"Author":     "Yasu Saku",
-					},
-				)),
+				Description:     "Message updated with a single tracker link",
+				OldMessage:      "this is an old message",
+				InputMessage:    "This is a test message with a tracker link: https://www.redmine.org/issues/40556",
+				ExpectedMessage: fmt.Sprintf("This is a test message with a tracker link: %s", expectedLink("https://www.redmine.org/issues/40556", "", issue40556)),
 			},
 		},
 	}
@@ -269,3 +207,172 @@ func runTestCases(t *testing.T, plugin *Plugin, testCases []TestCase, hook strin
 		})
 	}
 }
+
+func TestMessageWillBePostedManyLinks(t *testing.T) {
+	server := redminetest.NewServer(t)
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://www.redmine.org",
+		},
+		httpClient: server.HTTPClient(),
+	}
+
+	links := make([]string, 0, 60)
+	for id := 1; id <= 60; id++ {
+		server.AddIssue(redmine.Issue{
+			ID:      id,
+			Tracker: redmine.IssueProperty{ID: 1, Name: "Defect"},
+			Subject: fmt.Sprintf("Issue %d", id),
+		})
+		links = append(links, fmt.Sprintf("https://www.redmine.org/issues/%d", id))
+	}
+
+	newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: strings.Join(links, "\n")})
+
+	assert.NotContains(t, "\n"+newPost.Message, "\nhttps://www.redmine.org/issues/")
+	assert.Contains(t, newPost.Message, "[Defect#60: Issue 60](https://www.redmine.org/issues/60 ")
+}
+
+func TestMessageWillBePostedPartialFailure(t *testing.T) {
+	server := redminetest.NewServer(t)
+	for id := 1; id <= 3; id++ {
+		server.AddIssue(redmine.Issue{
+			ID:      id,
+			Tracker: redmine.IssueProperty{ID: 1, Name: "Defect"},
+			Subject: fmt.Sprintf("Issue %d", id),
+		})
+	}
+	server.FailIssue(2, http.StatusInternalServerError, "<html>Internal error</html>")
+
+	api := &plugintest.API{}
+	api.On("LogWarn", "Failed to fetch issues, fetching them one by one", "instance", "https://www.redmine.org", "issue_ids", "1,2,3", "err",
+		"redmine: https://www.redmine.org/issues.json?issue_id=1%2C2%2C3&limit=100&offset=0&status_id=%2A returned 500 Internal Server Error").Return()
+	api.On("LogWarn", "Failed to fetch issue", "instance", "https://www.redmine.org", "issue_id", 2, "err",
+		"redmine: https://www.redmine.org/issues/2.json returned 500 Internal Server Error").Return()
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://www.redmine.org",
+			TooltipTemplate:    "{{.Subject}}",
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(api)
+
+	newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://www.redmine.org/issues/1 https://www.redmine.org/issues/2 https://www.redmine.org/issues/3"})
+
+	assert.Equal(t, `[Defect#1: Issue 1](https://www.redmine.org/issues/1 "Issue 1") https://www.redmine.org/issues/2 [Defect#3: Issue 3](https://www.redmine.org/issues/3 "Issue 3")`, newPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestGetIssuesOneByOne(t *testing.T) {
+	server := redminetest.NewServer(t)
+	ids := make([]int, 0, 20)
+	for id := 1; id <= 20; id++ {
+		server.AddIssue(redmine.Issue{ID: id, Subject: fmt.Sprintf("Issue %d", id)})
+		ids = append(ids, id)
+	}
+
+	plugin := &Plugin{
+		configuration: &configuration{RedmineInstanceURL: "https://www.redmine.org"},
+		httpClient:    server.HTTPClient(),
+	}
+	instance := plugin.getConfiguration().getInstances()[0]
+	redmineClient, err := plugin.newRedmineClient(instance, "")
+	require.NoError(t, err)
+	client := &userClient{Client: redmineClient}
+
+	for _, tc := range []struct {
+		Description      string
+		BatchError       error
+		Status           int
+		ExpectedIssues   int
+		ExpectedRequests int
+	}{
+		{
+			Description:      "Capped",
+			BatchError:       &redmine.APIError{StatusCode: http.StatusInternalServerError},
+			ExpectedIssues:   maxSingleIssueRequests,
+			ExpectedRequests: maxSingleIssueRequests,
+		},
+		{
+			Description:      "Stops when Redmine becomes unavailable",
+			BatchError:       &redmine.APIError{StatusCode: http.StatusInternalServerError},
+			Status:           http.StatusServiceUnavailable,
+			ExpectedRequests: 1,
+		},
+		{
+			Description: "Redmine unavailable",
+			BatchError:  &redmine.APIError{StatusCode: http.StatusBadGateway},
+		},
+		{
+			Description: "Rejected credentials",
+			BatchError:  &redmine.APIError{StatusCode: http.StatusUnauthorized},
+		},
+		{
+			Description: "Request failed before reaching Redmine",
+			BatchError:  context.DeadlineExceeded,
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			server.FailWith(tc.Status, "")
+			defer server.FailWith(0, "")
+			api := &plugintest.API{}
+			api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe().Return()
+			api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe().Return()
+			plugin.SetAPI(api)
+			before := len(server.Requests())
+
+			issues := plugin.getIssuesOneByOne(client, instance, ids, nil, tc.BatchError)
+			assert.Len(t, issues, tc.ExpectedIssues)
+			assert.Len(t, server.Requests(), before+tc.ExpectedRequests)
+		})
+	}
+}
+
+func TestReplaceLinks(t *testing.T) {
+	message := "see https://redmine.example.com/issues/1 and https://redmine.example.com/versions/2"
+
+	for _, tc := range []struct {
+		Description  string
+		Replacements []linkReplacement
+		Expected     string
+	}{
+		{
+			Description: "Separate links",
+			Replacements: []linkReplacement{
+				{start: 45, end: 83, link: "[version]"},
+				{start: 4, end: 40, link: "[issue]"},
+			},
+			Expected: "see [issue] and [version]",
+		},
+		{
+			Description: "Same start keeps the longest",
+			Replacements: []linkReplacement{
+				{start: 4, end: 35, link: "[short]"},
+				{start: 4, end: 40, link: "[issue]"},
+			},
+			Expected: "see [issue] and https://redmine.example.com/versions/2",
+		},
+		{
+			Description: "Overlapping links keep the earlier",
+			Replacements: []linkReplacement{
+				{start: 20, end: 60, link: "[overlap]"},
+				{start: 4, end: 40, link: "[issue]"},
+				{start: 45, end: 83, link: "[version]"},
+			},
+			Expected: "see [issue] and [version]",
+		},
+		{
+			Description: "Nested link",
+			Replacements: []linkReplacement{
+				{start: 4, end: 83, link: "[all]"},
+				{start: 45, end: 83, link: "[version]"},
+			},
+			Expected: "see [all]",
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, replaceLinks(message, tc.Replacements))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// Values of the IssueDetailPolicy setting.
+const (
+	// issueDetailsAll renders every issue the configured API key can see.
+	issueDetailsAll = "all"
+	// issueDetailsHidePrivate leaves links to private issues untouched.
+	issueDetailsHidePrivate = "hide_private"
+	// issueDetailsLinkedAccount renders issues only with the linked account of the poster.
+	issueDetailsLinkedAccount = "linked_account"
+	// issueDetailsChannelAllowlist renders issues only in the channels of IssueDetailChannels.
+	issueDetailsChannelAllowlist = "channel_allowlist"
+)
+
+// issueViewer describes on whose behalf issues are fetched and rendered.
+type issueViewer struct {
+	userID string
+	// linkedOnly only fetches issues with the linked account of the user, never with the API
+	// key of the instance or anonymously.
+	linkedOnly bool
+	// hidePrivate leaves references to private issues untouched.
+	hidePrivate bool
+}
+
+// getIssueDetailChannels returns the channel names or IDs of IssueDetailChannels.
+func (c *configuration) getIssueDetailChannels() []string {
+	return strings.FieldsFunc(c.IssueDetailChannels, func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' '
+	})
+}
+
+// postViewer returns the viewer issues referenced in a post are rendered for, according to
+// the IssueDetailPolicy setting. It returns false when no issue may be rendered at all.
+func (p *Plugin) postViewer(userID string, scope *channelScope) (issueViewer, bool) {
+	configuration := p.getConfiguration()
+	viewer := issueViewer{userID: userID}
+
+	switch configuration.IssueDetailPolicy {
+	case issueDetailsHidePrivate:
+		viewer.hidePrivate = true
+	case issueDetailsLinkedAccount:
+		viewer.linkedOnly = true
+	case issueDetailsChannelAllowlist:
+		channels := configuration.getIssueDetailChannels()
+		if len(channels) == 0 || !scope.allows(nil, channels) {
+			return viewer, false
+		}
+	}
+
+	return viewer, true
+}
+
+// getViewerClient returns the client fetching issues for the viewer, or nil when the viewer
+// may not see issues of the instance.
+func (p *Plugin) getViewerClient(instance *redmineInstance, viewer issueViewer) (*userClient, error) {
+	client, err := p.getUserClient(instance, viewer.userID)
+	if err != nil {
+		return nil, err
+	}
+	if viewer.linkedOnly && client.account == nil {
+		return nil, nil
+	}
+
+	return client, nil
+}
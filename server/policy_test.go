@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+func TestIssueDetailPolicy(t *testing.T) {
+	message := "https://redmine.example.com/issues/1 and https://redmine.example.com/issues/2"
+	publicLink := `[Bug#1: Public issue](https://redmine.example.com/issues/1 "")`
+	privateLink := `[Bug#2: Private issue](https://redmine.example.com/issues/2 "")`
+
+	api := &plugintest.API{}
+	api.On("GetChannel", "engineering-id").Return(&model.Channel{Id: "engineering-id", Name: "engineering"}, nil)
+	api.On("GetChannel", "town-square-id").Return(&model.Channel{Id: "town-square-id", Name: "town-square"}, nil)
+
+	for _, tc := range []struct {
+		Description string
+		Policy      string
+		Channels    string
+		ChannelID   string
+		UserID      string
+		Expected    string
+	}{
+		{
+			Description: "All issues",
+			Policy:      issueDetailsAll,
+			Expected:    publicLink + " and " + privateLink,
+		},
+		{
+			Description: "Private issues hidden",
+			Policy:      issueDetailsHidePrivate,
+			Expected:    publicLink + " and https://redmine.example.com/issues/2",
+		},
+		{
+			Description: "Linked account of the poster",
+			Policy:      issueDetailsLinkedAccount,
+			UserID:      "user-id",
+			Expected:    publicLink + " and " + privateLink,
+		},
+		{
+			Description: "Poster without linked account",
+			Policy:      issueDetailsLinkedAccount,
+			UserID:      "other-user-id",
+			Expected:    message,
+		},
+		{
+			Description: "Allowed channel",
+			Policy:      issueDetailsChannelAllowlist,
+			Channels:    "engineering, support",
+			ChannelID:   "engineering-id",
+			Expected:    publicLink + " and " + privateLink,
+		},
+		{
+			Description: "Channel not allowed",
+			Policy:      issueDetailsChannelAllowlist,
+			Channels:    "engineering, support",
+			ChannelID:   "town-square-id",
+			Expected:    message,
+		},
+		{
+			Description: "Empty allowlist",
+			Policy:      issueDetailsChannelAllowlist,
+			ChannelID:   "engineering-id",
+			Expected:    message,
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			server := newAccountsTestServer(t)
+			plugin := newAccountsTestPlugin(server, true)
+			plugin.configuration.IssueDetailPolicy = tc.Policy
+			plugin.configuration.IssueDetailChannels = tc.Channels
+			plugin.SetAPI(api)
+
+			account := newUserAccount(plugin.getConfiguration().getInstances()[0], &redmine.User{ID: 5, Login: "jdoe"})
+			require.NoError(t, account.setAPIKey("secret", "jane-key"))
+			require.NoError(t, plugin.saveUserAccount("user-id", account))
+
+			post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: tc.UserID, ChannelId: tc.ChannelID, Message: message})
+			assert.Equal(t, tc.Expected, post.Message)
+		})
+	}
+}
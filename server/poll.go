@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	// pollJobKey names the cluster job polling subscribed projects, so that a single node of a
+	// cluster runs it.
+	pollJobKey = "subscription_poll"
+
+	// pollRetryInterval is how often a disabled poller checks whether it was enabled.
+	pollRetryInterval = time.Minute
+
+	pollMarkKeyPrefix = "poll_mark_"
+
+	// pollWindowIntervals and minPollWindow bound how far back a poll looks for changes. Marks
+	// older than that are left by a stopped poller or an unavailable Redmine, and catching up
+	// with all of their changes would flood the channels.
+	pollWindowIntervals = 3
+	minPollWindow       = time.Hour
+)
+
+// pollMark is the high-water mark of a polled project: the update time of the most recently
+// posted issue. IssueIDs lists the issues posted with exactly that update time, since the
+// updated_on filter of Redmine includes it.
+type pollMark struct {
+	UpdatedOn time.Time `json:"updated_on"`
+	IssueIDs  []int     `json:"issue_ids,omitempty"`
+}
+
+// seen reports whether the change of the issue was already posted.
+func (m *pollMark) seen(issueID int, updatedOn time.Time) bool {
+	if updatedOn.Before(m.UpdatedOn) {
+		return true
+	}
+	if updatedOn.Equal(m.UpdatedOn) {
+		for _, id := range m.IssueIDs {
+			if id == issueID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// advance records the change of the issue as posted.
+func (m *pollMark) advance(issueID int, updatedOn time.Time) {
+	if updatedOn.After(m.UpdatedOn) {
+		m.UpdatedOn = updatedOn
+		m.IssueIDs = nil
+	}
+	m.IssueIDs = append(m.IssueIDs, issueID)
+}
+
+// subscriptionPollInterval returns how often subscribed projects are polled. Zero disables
+// polling.
+func (c *configuration) subscriptionPollInterval() time.Duration {
+	if c.SubscriptionPollMinutes <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.SubscriptionPollMinutes) * time.Minute
+}
+
+// pollWindow returns how far back a poll looks for changes: a few poll intervals, and at least
+// minPollWindow.
+func (c *configuration) pollWindow() time.Duration {
+	window := pollWindowIntervals * c.subscriptionPollInterval()
+	if window < minPollWindow {
+		window = minPollWindow
+	}
+
+	return window
+}
+
+// pollMarkKey stores the high-water mark of a project of an instance.
+func pollMarkKey(instanceURL string, projectID int) string {
+	return pollMarkKeyPrefix + instanceKeyHash(instanceURL) + "_" + strconv.Itoa(projectID)
+}
+
+func (p *Plugin) getPollMark(instance *redmineInstance, projectID int) (*pollMark, error) {
+	var mark *pollMark
+	if err := p.kvStore.Get(pollMarkKey(instance.URL, projectID), &mark); err != nil {
+		return nil, fmt.Errorf("failed to load poll mark: %w", err)
+	}
+
+	return mark, nil
+}
+
+func (p *Plugin) savePollMark(instance *redmineInstance, projectID int, mark *pollMark) error {
+	if _, err := p.kvStore.Set(pollMarkKey(instance.URL, projectID), mark); err != nil {
+		return fmt.Errorf("failed to save poll mark: %w", err)
+	}
+
+	return nil
+}
+
+// schedulePollJob starts polling the subscribed projects on a single node of the cluster, at
+// the interval of the SubscriptionPollMinutes setting.
+func (p *Plugin) schedulePollJob() error {
+	job, err := cluster.Schedule(p.API, pollJobKey, p.nextPollWait, func() {
+		if p.getConfiguration().subscriptionPollInterval() > 0 {
+			p.pollSubscriptions(time.Now())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	p.pollJob = job
+
+	return nil
+}
+
+// nextPollWait reads the poll interval on every run, so that changes of the setting apply
+// without restarting the job.
+func (p *Plugin) nextPollWait(now time.Time, metadata cluster.JobMetadata) time.Duration {
+	interval := p.getConfiguration().subscriptionPollInterval()
+	if interval == 0 {
+		interval = pollRetryInterval
+	}
+
+	return cluster.MakeWaitForInterval(interval)(now, metadata)
+}
+
+// pollSubscriptions posts the changes of every subscribed project since its high-water mark.
+// The first poll of a project only records the mark, so that old changes are not replayed.
+func (p *Plugin) pollSubscriptions(now time.Time) {
+	for _, instance := range p.getConfiguration().getInstances() {
+		subscriptions, err := p.getSubscriptions(instance)
+		if err != nil {
+			p.API.LogWarn("Failed to load subscriptions", "instance", instance.URL, "err", err.Error())
+			continue
+		}
+		if len(subscriptions) == 0 {
+			continue
+		}
+
+		client, err := p.newRedmineClient(instance, instance.APIKey)
+		if err != nil {
+			p.API.LogWarn("Failed to create Redmine client", "instance", instance.URL, "err", err.Error())
+			continue
+		}
+
+		subscribers := map[string]*userClient{}
+		projects := map[int]bool{}
+		for _, sub := range subscriptions {
+			if projects[sub.ProjectID] {
+				continue
+			}
+			projects[sub.ProjectID] = true
+
+			if err := p.pollProject(context.Background(), client, instance, sub.ProjectID, subscriptions, subscribers, now); err != nil {
+				p.API.LogWarn("Failed to poll project", "instance", instance.URL, "project_id", sub.ProjectID, "err", err.Error())
+			}
+		}
+	}
+}
+
+// pollProject posts the changes of a project since its high-water mark, oldest first, and
+// advances the mark after each posted issue so that a restart resumes where it stopped. Only
+// the changes within the poll window are posted.
+// subscribers holds the clients of the subscribers checked so far, keyed by user ID.
+func (p *Plugin) pollProject(ctx context.Context, client *redmine.Client, instance *redmineInstance, projectID int, subscriptions []subscription, subscribers map[string]*userClient, now time.Time) error {
+	mark, err := p.getPollMark(instance, projectID)
+	if err != nil {
+		return err
+	}
+	if mark == nil {
+		mark, err = initialPollMark(ctx, client, projectID, now)
+		if err != nil {
+			return err
+		}
+		return p.savePollMark(instance, projectID, mark)
+	}
+
+	// The mark of a quiet project stays old, but changes before the poll window were either
+	// posted by earlier polls or missed while polling stopped, and are skipped.
+	from := mark.UpdatedOn
+	if windowStart := now.Add(-p.getConfiguration().pollWindow()).UTC().Truncate(time.Second); from.Before(windowStart) {
+		from = windowStart
+	}
+
+	issues, err := client.ListAllIssues(ctx, url.Values{
+		"project_id": {strconv.Itoa(projectID)},
+		"status_id":  {"*"},
+		"updated_on": {">=" + from.UTC().Format(time.RFC3339)},
+		"sort":       {"updated_on,id"},
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return parseRedmineTime(issues[i].UpdatedOn).Before(parseRedmineTime(issues[j].UpdatedOn))
+	})
+
+	for _, issue := range issues {
+		// Issues of subprojects are included by Redmine, but delivered by their own subscriptions.
+		if issue.Project.ID != projectID {
+			continue
+		}
+		updatedOn := parseRedmineTime(issue.UpdatedOn)
+		if mark.seen(issue.ID, updatedOn) {
+			continue
+		}
+
+		// Changes made in the second of the mark are only new for issues not posted in it.
+		since := from
+		if since.Equal(mark.UpdatedOn) && updatedOn.Equal(since) {
+			since = since.Add(-time.Nanosecond)
+		}
+		event, err := p.polledIssueEvent(ctx, client, instance, issue, since)
+		if err != nil {
+			p.API.LogWarn("Failed to get issue history", "instance", instance.URL, "issue_id", issue.ID, "err", err.Error())
+		} else {
+			p.handleIssueEvent(event, p.subscribedChannels(ctx, instance, subscriptions, issue, subscribers))
+		}
+
+		mark.advance(issue.ID, updatedOn)
+		if err := p.savePollMark(instance, projectID, mark); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// initialPollMark returns the mark of a project polled for the first time: the most recent
+// change, or now when the project has no issues.
+func initialPollMark(ctx context.Context, client *redmine.Client, projectID int, now time.Time) (*pollMark, error) {
+	resp, err := client.ListIssues(ctx, url.Values{
+		"project_id": {strconv.Itoa(projectID)},
+		"status_id":  {"*"},
+		"sort":       {"updated_on:desc"},
+		"limit":      {"1"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Issues) == 0 {
+		return &pollMark{UpdatedOn: now.UTC().Truncate(time.Second)}, nil
+	}
+
+	latest := resp.Issues[0]
+	return &pollMark{UpdatedOn: parseRedmineTime(latest.UpdatedOn), IssueIDs: []int{latest.ID}}, nil
+}
+
+// subscribedChannels returns the channels whose subscriptions match the issue. Projects are
+// polled with the API key of the instance, so the issue is only delivered to the channels of
+// subscribers who can see it in Redmine themselves. subscribers holds their clients, keyed by
+// user ID.
+func (p *Plugin) subscribedChannels(ctx context.Context, instance *redmineInstance, subscriptions []subscription, issue redmine.Issue, subscribers map[string]*userClient) []string {
+	var channelIDs []string
+	visible := map[string]bool{}
+	for _, sub := range subscriptions {
+		if !sub.matches(issue) {
+			continue
+		}
+
+		canSee, ok := visible[sub.CreatorID]
+		if !ok {
+			canSee = p.subscriberCanSee(ctx, instance, sub.CreatorID, issue, subscribers)
+			visible[sub.CreatorID] = canSee
+		}
+		if canSee {
+			channelIDs = append(channelIDs, sub.ChannelID)
+		}
+	}
+
+	return channelIDs
+}
+
+// subscriberCanSee reports whether the issue can be fetched with the linked account of the
+// subscriber, or anonymously when they have none.
+func (p *Plugin) subscriberCanSee(ctx context.Context, instance *redmineInstance, userID string, issue redmine.Issue, subscribers map[string]*userClient) bool {
+	client, ok := subscribers[userID]
+	if !ok {
+		var err error
+		client, err = p.getPersonalClient(instance, userID)
+		if err != nil {
+			p.API.LogWarn("Failed to create Redmine client", "user_id", userID, "instance", instance.URL, "err", err.Error())
+		}
+		subscribers[userID] = client
+	}
+	if client == nil {
+		return false
+	}
+
+	_, err := client.GetIssue(ctx, issue.ID)
+	if err != nil && !errors.Is(err, redmine.ErrNotFound) && !errors.Is(err, redmine.ErrForbidden) && !errors.Is(err, redmine.ErrUnauthorized) {
+		p.API.LogWarn("Failed to check issue visibility", "user_id", userID, "instance", instance.URL, "issue_id", issue.ID, "err", err.Error())
+	}
+
+	return err == nil
+}
+
+// polledIssueEvent describes the changes of an issue after the given time, using the journals
+// added since then.
+func (p *Plugin) polledIssueEvent(ctx context.Context, client *redmine.Client, instance *redmineInstance, issue redmine.Issue, since time.Time) (*issueEvent, error) {
+	event := &issueEvent{kind: issueEventUpdated, issue: issue, instance: instance}
+	if parseRedmineTime(issue.CreatedOn).After(since) {
+		event.kind = issueEventCreated
+		event.actor = issue.Author.Name
+		return event, nil
+	}
+
+	journals, err := client.GetIssueJournals(ctx, issue.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []string
+	for _, journal := range journals {
+		if !parseRedmineTime(journal.CreatedOn).After(since) {
+			continue
+		}
+
+		event.actor = journal.User.Name
+		if journal.Notes != "" && !journal.PrivateNotes {
+			notes = append(notes, journal.Notes)
+		}
+		event.changes = append(event.changes, journalChanges(issue, journal.Details)...)
+		for _, detail := range journal.Details {
+			if detail.Property == "attr" && detail.Name == "status_id" && issue.Status.IsClosed {
+				event.kind = issueEventClosed
+			}
+		}
+	}
+	event.notes = strings.Join(notes, "\n\n")
+
+	return event, nil
+}
+
+// parseRedmineTime parses a timestamp of the Redmine API, returning the zero time when it is
+// empty or invalid.
+func parseRedmineTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
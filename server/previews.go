@@ -0,0 +1,68 @@
+package main
+
+import (
+	"unicode/utf16"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// issuePreviewsProp holds the issue previews of a post rendered by the webapp.
+const issuePreviewsProp = "redmine_previews"
+
+// issuePreview is a rendered issue or resource reference, replaced by the webapp when the post
+// is displayed so that the stored message stays as written. The issue fields are empty for
+// resources.
+type issuePreview struct {
+	// Start and End locate the reference in the message, in UTF-16 code units as used by
+	// JavaScript strings.
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Text is the reference as written, used to check that the message was not changed.
+	Text string `json:"text"`
+	// Link is the markdown link replacing the reference.
+	Link string `json:"link"`
+
+	Instance string `json:"instance,omitempty"`
+	IssueID  int    `json:"issue_id,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	Status   string `json:"status,omitempty"`
+	IsClosed bool   `json:"is_closed,omitempty"`
+}
+
+// setIssuePreviews replaces the previews of the post with the rendered issue references and
+// resource links of its message.
+func setIssuePreviews(post *model.Post, rendered []renderedReference, resources []linkReplacement) {
+	if len(rendered)+len(resources) == 0 {
+		post.DelProp(issuePreviewsProp)
+		return
+	}
+
+	previews := make([]issuePreview, 0, len(rendered)+len(resources))
+	for _, reference := range rendered {
+		previews = append(previews, issuePreview{
+			Start:    utf16Offset(post.Message, reference.start),
+			End:      utf16Offset(post.Message, reference.end),
+			Text:     reference.text,
+			Link:     reference.link,
+			Instance: reference.instance.URL,
+			IssueID:  reference.issue.ID,
+			Subject:  reference.issue.Subject,
+			Status:   reference.issue.Status.Name,
+			IsClosed: reference.issue.Status.IsClosed,
+		})
+	}
+	for _, resource := range resources {
+		previews = append(previews, issuePreview{
+			Start: utf16Offset(post.Message, resource.start),
+			End:   utf16Offset(post.Message, resource.end),
+			Text:  post.Message[resource.start:resource.end],
+			Link:  resource.link,
+		})
+	}
+	post.AddProp(issuePreviewsProp, previews)
+}
+
+// utf16Offset converts a byte offset of text to the offset in UTF-16 code units.
+func utf16Offset(text string, byteOffset int) int {
+	return len(utf16.Encode([]rune(text[:byteOffset])))
+}
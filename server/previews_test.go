@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestIssuePreviews(t *testing.T) {
+	server := redminetest.NewServer(t)
+	closed := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true}
+	server.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Login fails", Status: closed})
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			TooltipTemplate:    "{{.Status.Name}}",
+			LinkDisplayMode:    linkDisplayPreview,
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(&plugintest.API{})
+
+	message := "🚀 Fixed https://redmine.example.com/issues/1 and https://redmine.example.com/issues/404"
+	post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+	assert.Equal(t, message, post.Message)
+	assert.Nil(t, post.GetProp(originalMessageProp))
+	assert.Equal(t, []issuePreview{{
+		// The emoji takes two UTF-16 code units.
+		Start:    9,
+		End:      45,
+		Text:     "https://redmine.example.com/issues/1",
+		Link:     `[Bug#1: Login fails](https://redmine.example.com/issues/1 "Closed")`,
+		Instance: "https://redmine.example.com",
+		IssueID:  1,
+		Subject:  "Login fails",
+		Status:   "Closed",
+		IsClosed: true,
+	}}, post.GetProp(issuePreviewsProp))
+
+	t.Run("Edited message", func(t *testing.T) {
+		edited := post.Clone()
+		edited.Message = "Nothing to see"
+
+		updated, _ := plugin.MessageWillBeUpdated(nil, edited, post)
+		assert.Equal(t, "Nothing to see", updated.Message)
+		assert.Nil(t, updated.GetProp(issuePreviewsProp))
+	})
+
+	t.Run("Inline links replace previews", func(t *testing.T) {
+		inline := &Plugin{configuration: plugin.configuration.Clone(), httpClient: server.HTTPClient()}
+		inline.configuration.LinkDisplayMode = linkDisplayInline
+		inline.SetAPI(&plugintest.API{})
+
+		rendered := inline.renderPost(post.Clone())
+		assert.Equal(t, "🚀 Fixed [Bug#1: Login fails](https://redmine.example.com/issues/1 \"Closed\") and https://redmine.example.com/issues/404", rendered.Message)
+		assert.Nil(t, rendered.GetProp(issuePreviewsProp))
+
+		// And back, restoring the message as written.
+		restored := plugin.renderPost(rendered)
+		assert.Equal(t, message, restored.Message)
+		assert.Nil(t, restored.GetProp(originalMessageProp))
+		require.Len(t, restored.GetProp(issuePreviewsProp), 1)
+	})
+}
@@ -0,0 +1,174 @@
+// Package redmine implements a small client for the Redmine REST API.
+//
+// See https://www.redmine.org/projects/redmine/wiki/Rest_api
+package redmine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the request timeout of the http.Client used when none is provided.
+const DefaultTimeout = 10 * time.Second
+
+// Client talks to a single Redmine instance.
+type Client struct {
+	baseURL     *url.URL
+	apiKey      string
+	accessToken string
+	httpClient  *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey authenticates every request with the given key using the X-Redmine-API-Key header.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithAccessToken authenticates every request with an OAuth2 access token using the
+// Authorization header, instead of an API key.
+func WithAccessToken(accessToken string) Option {
+	return func(c *Client) {
+		c.accessToken = accessToken
+	}
+}
+
+// WithHTTPClient replaces the http.Client used to perform requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// NewClient creates a client for the Redmine instance located at baseURL.
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redmine URL %q: %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Redmine URL %q: scheme must be http or https", baseURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid Redmine URL %q: missing host", baseURL)
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// BaseURL returns the normalized instance URL, always ending with a slash.
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	} else if c.apiKey != "" {
+		req.Header.Set("X-Redmine-API-Key", c.apiKey)
+	}
+
+	return req, nil
+}
+
+// do sends the request and decodes a successful JSON response into v, which may be nil.
+func (c *Client) do(req *http.Request, v any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(req, resp)
+	}
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		// Typically an HTML login or maintenance page served with a success status
+		return fmt.Errorf("failed to decode JSON response from %s: %w: %w", redactedURL(req.URL), ErrUnexpectedResponse, err)
+	}
+
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, v any) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, v)
+}
+
+func (c *Client) post(ctx context.Context, path string, body, v any) error {
+	req, err := c.newRequest(ctx, http.MethodPost, path, nil, body)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, v)
+}
+
+// redactedURL strips credentials that Redmine accepts as query parameters before the URL is logged.
+func redactedURL(u *url.URL) string {
+	clone := *u
+	query := clone.Query()
+	if query.Has("key") {
+		query.Set("key", "REDACTED")
+		clone.RawQuery = query.Encode()
+	}
+	clone.User = nil
+
+	return clone.String()
+}
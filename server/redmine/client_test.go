@@ -0,0 +1,368 @@
+package redmine_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func newTestClient(t *testing.T, server *redminetest.Server, opts ...redmine.Option) *redmine.Client {
+	client, err := redmine.NewClient(server.URL, opts...)
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestNewClient(t *testing.T) {
+	for _, tc := range []struct {
+		Description string
+		URL         string
+		Expected    string
+		Error       bool
+	}{
+		{Description: "Host only", URL: "https://www.redmine.org", Expected: "https://www.redmine.org/"},
+		{Description: "Trailing slash", URL: "https://www.redmine.org/", Expected: "https://www.redmine.org/"},
+		{Description: "Sub-path and port", URL: "http://corp.example.com:8080/redmine", Expected: "http://corp.example.com:8080/redmine/"},
+		{Description: "Query and fragment are dropped", URL: "https://www.redmine.org/?a=b#c", Expected: "https://www.redmine.org/"},
+		{Description: "Missing scheme", URL: "www.redmine.org", Error: true},
+		{Description: "Unsupported scheme", URL: "ftp://www.redmine.org", Error: true},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			client, err := redmine.NewClient(tc.URL)
+			if tc.Error {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expected, client.BaseURL())
+		})
+	}
+}
+
+func TestClientResources(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.SetAPIKey("secret")
+	dueDate := "2024-06-01"
+	server.AddIssue(redmine.Issue{
+		ID:      1,
+		Subject: "First issue",
+		Status:  redmine.Status{IssueProperty: redmine.IssueProperty{ID: 1, Name: "New"}},
+		Journals: []redmine.Journal{
+			{ID: 10, User: redmine.IssueProperty{ID: 1, Name: "Jean-Philippe Lang"}, Notes: "A note"},
+		},
+	})
+	server.AddIssue(redmine.Issue{
+		ID:      2,
+		Subject: "Closed issue",
+		Status:  redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true},
+	})
+	server.AddProject(redmine.Project{ID: 1, Name: "Redmine", Identifier: "redmine"})
+	server.AddUser(redmine.User{ID: 1, Login: "jplang", Firstname: "Jean-Philippe", Lastname: "Lang"})
+	server.SetCurrentUser(1)
+	server.AddVersion(redmine.Version{ID: 3, Name: "5.1.0", Project: redmine.IssueProperty{ID: 1}, DueDate: &dueDate})
+	server.AddTimeEntry(redmine.TimeEntry{ID: 7, Hours: 1.5, Issue: &redmine.Parent{ID: 1}})
+	server.AddWikiPage("redmine", redmine.WikiPage{Title: "Getting_started", Version: 4, Author: redmine.IssueProperty{ID: 1, Name: "Jean-Philippe Lang"}})
+	server.AddTracker(redmine.Tracker{ID: 1, Name: "Defect"})
+	server.AddQuery(redmine.Query{ID: 9, Name: "Closed issues", IsPublic: true}, url.Values{"status_id": {"c"}})
+	server.AddPriority(redmine.Enumeration{ID: 2, Name: "Normal", IsDefault: true, Active: true})
+	server.AddMembership(redmine.Membership{ID: 4, Project: redmine.IssueProperty{ID: 1}, User: &redmine.IssueProperty{ID: 1, Name: "Jean-Philippe Lang"}})
+
+	client := newTestClient(t, server, redmine.WithAPIKey("secret"))
+	ctx := context.Background()
+
+	t.Run("Issue", func(t *testing.T) {
+		issue, err := client.GetIssue(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "First issue", issue.Subject)
+		assert.Empty(t, issue.Journals)
+	})
+
+	t.Run("Issue journals", func(t *testing.T) {
+		journals, err := client.GetIssueJournals(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, journals, 1)
+		assert.Equal(t, "A note", journals[0].Notes)
+	})
+
+	t.Run("Issues", func(t *testing.T) {
+		resp, err := client.ListIssues(ctx, url.Values{"issue_id": {"1,2"}, "status_id": {"*"}})
+		require.NoError(t, err)
+		require.Len(t, resp.Issues, 2)
+		assert.Equal(t, 1, resp.Issues[0].ID)
+		assert.Equal(t, 2, resp.Issues[1].ID)
+	})
+
+	t.Run("Project", func(t *testing.T) {
+		project, err := client.GetProject(ctx, "redmine")
+		require.NoError(t, err)
+		assert.Equal(t, "Redmine", project.Name)
+
+		projects, err := client.ListProjects(ctx, nil)
+		require.NoError(t, err)
+		assert.Len(t, projects.Projects, 1)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		user, err := client.GetUser(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "Jean-Philippe Lang", user.Name())
+
+		current, err := client.GetCurrentUser(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "jplang", current.Login)
+
+		users, err := client.ListUsers(ctx, url.Values{"name": {"lang"}})
+		require.NoError(t, err)
+		require.Len(t, users.Users, 1)
+		assert.Equal(t, 1, users.Users[0].ID)
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		version, err := client.GetVersion(ctx, 3)
+		require.NoError(t, err)
+		assert.Equal(t, "5.1.0", version.Name)
+
+		versions, err := client.ListVersions(ctx, "redmine")
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+
+	t.Run("Wiki page", func(t *testing.T) {
+		page, err := client.GetWikiPage(ctx, "redmine", "Getting_started")
+		require.NoError(t, err)
+		assert.Equal(t, 4, page.Version)
+
+		_, err = client.GetWikiPage(ctx, "redmine", "Missing")
+		assert.ErrorIs(t, err, redmine.ErrNotFound)
+	})
+
+	t.Run("Saved queries", func(t *testing.T) {
+		queries, err := client.ListQueries(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []redmine.Query{{ID: 9, Name: "Closed issues", IsPublic: true}}, queries.Queries)
+
+		issues, err := client.ListIssues(ctx, url.Values{"query_id": {"9"}})
+		require.NoError(t, err)
+		require.Len(t, issues.Issues, 1)
+		assert.Equal(t, 2, issues.Issues[0].ID)
+	})
+
+	t.Run("Time entries", func(t *testing.T) {
+		entry, err := client.GetTimeEntry(ctx, 7)
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, entry.Hours)
+
+		entries, err := client.ListTimeEntries(ctx, url.Values{"issue_id": {"1"}})
+		require.NoError(t, err)
+		assert.Len(t, entries.TimeEntries, 1)
+	})
+
+	t.Run("Trackers, priorities and memberships", func(t *testing.T) {
+		trackers, err := client.ListTrackers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []redmine.Tracker{{ID: 1, Name: "Defect"}}, trackers)
+
+		priorities, err := client.ListIssuePriorities(ctx)
+		require.NoError(t, err)
+		require.Len(t, priorities, 1)
+		assert.True(t, priorities[0].IsDefault)
+
+		memberships, err := client.ListMemberships(ctx, "redmine", nil)
+		require.NoError(t, err)
+		require.Len(t, memberships.Memberships, 1)
+		assert.Equal(t, "Jean-Philippe Lang", memberships.Memberships[0].User.Name)
+	})
+
+	t.Run("Create issue", func(t *testing.T) {
+		issue, err := client.CreateIssue(ctx, redmine.NewIssue{
+			ProjectID:    1,
+			TrackerID:    1,
+			PriorityID:   2,
+			AssignedToID: 1,
+			Subject:      "Created from chat",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, issue.ID)
+		assert.Equal(t, "Defect", issue.Tracker.Name)
+		assert.Equal(t, "Jean-Philippe Lang", issue.AssignedTo.Name)
+
+		_, err = client.CreateIssue(ctx, redmine.NewIssue{ProjectID: 1})
+		var apiErr *redmine.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.ErrorIs(t, err, redmine.ErrUnprocessable)
+		assert.Equal(t, []string{"Subject cannot be blank"}, apiErr.Errors)
+	})
+}
+
+func TestClientErrors(t *testing.T) {
+	server := redminetest.NewServer(t)
+	client := newTestClient(t, server)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		Description string
+		Status      int
+		Body        string
+		Expected    error
+		Messages    []string
+	}{
+		{Description: "Unauthorized", Status: http.StatusUnauthorized, Expected: redmine.ErrUnauthorized},
+		{Description: "Forbidden", Status: http.StatusForbidden, Expected: redmine.ErrForbidden},
+		{Description: "Not found", Status: http.StatusNotFound, Expected: redmine.ErrNotFound},
+		{
+			Description: "Unprocessable entity",
+			Status:      http.StatusUnprocessableEntity,
+			Body:        `{"errors":["Subject cannot be blank"]}`,
+			Expected:    redmine.ErrUnprocessable,
+			Messages:    []string{"Subject cannot be blank"},
+		},
+		{Description: "Server error", Status: http.StatusServiceUnavailable, Body: "<html>down</html>", Expected: redmine.ErrServer},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			server.FailWith(tc.Status, tc.Body)
+			defer server.FailWith(0, "")
+
+			_, err := client.GetIssue(ctx, 1)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tc.Expected)
+
+			var apiErr *redmine.APIError
+			require.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tc.Status, apiErr.StatusCode)
+			assert.Equal(t, tc.Messages, apiErr.Errors)
+		})
+	}
+
+	t.Run("HTML page with a success status", func(t *testing.T) {
+		server.FailWith(http.StatusOK, "<html>Login</html>")
+		defer server.FailWith(0, "")
+
+		_, err := client.GetIssue(ctx, 1)
+		assert.ErrorIs(t, err, redmine.ErrUnexpectedResponse)
+		assert.ErrorContains(t, err, "failed to decode JSON response")
+	})
+
+	t.Run("Missing API key", func(t *testing.T) {
+		server.SetAPIKey("secret")
+		defer server.SetAPIKey("")
+
+		_, err := client.GetCurrentUser(ctx)
+		assert.ErrorIs(t, err, redmine.ErrUnauthorized)
+	})
+}
+
+func TestGetIssuesByIDs(t *testing.T) {
+	server := redminetest.NewServer(t)
+	ids := make([]int, 0, 250)
+	for id := 1; id <= 250; id++ {
+		server.AddIssue(redmine.Issue{ID: id, Subject: fmt.Sprintf("Issue %d", id)})
+		ids = append(ids, id)
+	}
+	client := newTestClient(t, server)
+
+	t.Run("Chunks and deduplicates IDs", func(t *testing.T) {
+		issues, err := client.GetIssuesByIDs(context.Background(), append(ids, 1, 2, 3))
+		require.NoError(t, err)
+		assert.Len(t, issues, 250)
+		assert.Len(t, server.Requests(), 3)
+	})
+
+	t.Run("Follows pagination", func(t *testing.T) {
+		issues, err := client.ListAllIssues(context.Background(), url.Values{"status_id": {"*"}})
+		require.NoError(t, err)
+		require.Len(t, issues, 250)
+		assert.Equal(t, 250, issues[249].ID)
+	})
+
+	t.Run("Unknown IDs are omitted", func(t *testing.T) {
+		issues, err := client.GetIssuesByIDs(context.Background(), []int{1, 999999})
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		assert.Equal(t, 1, issues[0].ID)
+	})
+
+	t.Run("Failed chunks keep the others", func(t *testing.T) {
+		server.FailIssue(150, http.StatusInternalServerError, "<html>error</html>")
+		defer server.FailIssue(150, 0, "")
+
+		issues, err := client.GetIssuesByIDs(context.Background(), ids)
+		assert.ErrorIs(t, err, redmine.ErrServer)
+		require.Len(t, issues, 150)
+		assert.Equal(t, 100, issues[99].ID)
+		assert.Equal(t, 201, issues[100].ID)
+	})
+}
+
+func TestOAuth(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.SetOAuthClient("client-id", "client-secret", 3600)
+	server.AddUser(redmine.User{ID: 5, Login: "jdoe"})
+	server.AddOAuthCode("code", 5)
+
+	client := newTestClient(t, server)
+	config := redmine.OAuthConfig{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://chat.example.com/plugins/redmine/oauth/callback",
+		Scopes:       []string{"view_issues", "add_issues"},
+	}
+
+	authorizeURL, err := url.Parse(client.AuthorizeURL(config, "state"))
+	require.NoError(t, err)
+	assert.Equal(t, "/oauth/authorize", authorizeURL.Path)
+	assert.Equal(t, url.Values{
+		"response_type": {"code"},
+		"client_id":     {"client-id"},
+		"redirect_uri":  {"https://chat.example.com/plugins/redmine/oauth/callback"},
+		"scope":         {"view_issues add_issues"},
+		"state":         {"state"},
+	}, authorizeURL.Query())
+
+	token, err := client.ExchangeCode(context.Background(), config, "code")
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.RefreshToken)
+	assert.False(t, token.Expired(time.Minute))
+	assert.True(t, token.Expired(2*time.Hour))
+
+	user, err := newTestClient(t, server, redmine.WithAccessToken(token.AccessToken)).GetCurrentUser(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "jdoe", user.Login)
+
+	t.Run("Codes are single use", func(t *testing.T) {
+		_, err := client.ExchangeCode(context.Background(), config, "code")
+		var apiErr *redmine.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, []string{"The provided authorization grant is invalid."}, apiErr.Errors)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		refreshed, err := client.RefreshToken(context.Background(), config, token.RefreshToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, token.AccessToken, refreshed.AccessToken)
+		assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+	})
+
+	t.Run("Invalid client", func(t *testing.T) {
+		invalid := config
+		invalid.ClientSecret = "wrong"
+		_, err := client.RefreshToken(context.Background(), invalid, token.RefreshToken)
+		assert.ErrorIs(t, err, redmine.ErrUnauthorized)
+	})
+
+	t.Run("Revoked access token", func(t *testing.T) {
+		require.NoError(t, client.RevokeToken(context.Background(), config, token.AccessToken))
+		_, err := newTestClient(t, server, redmine.WithAccessToken(token.AccessToken)).GetCurrentUser(context.Background())
+		assert.ErrorIs(t, err, redmine.ErrUnauthorized)
+	})
+}
@@ -0,0 +1,27 @@
+package redmine
+
+import (
+	"context"
+)
+
+// Enumeration is an entry of a Redmine enumeration such as the issue priorities.
+type Enumeration struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	IsDefault bool   `json:"is_default"`
+	Active    bool   `json:"active"`
+}
+
+type IssuePrioritiesResponse struct {
+	IssuePriorities []Enumeration `json:"issue_priorities"`
+}
+
+// ListIssuePriorities lists the issue priorities, from the lowest to the highest.
+func (c *Client) ListIssuePriorities(ctx context.Context) ([]Enumeration, error) {
+	var resp IssuePrioritiesResponse
+	if err := c.get(ctx, "enumerations/issue_priorities.json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.IssuePriorities, nil
+}
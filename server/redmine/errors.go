@@ -0,0 +1,87 @@
+package redmine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matched by APIError through errors.Is.
+var (
+	ErrUnauthorized       = errors.New("redmine: unauthorized")
+	ErrForbidden          = errors.New("redmine: forbidden")
+	ErrNotFound           = errors.New("redmine: not found")
+	ErrUnprocessable      = errors.New("redmine: unprocessable entity")
+	ErrServer             = errors.New("redmine: server error")
+	ErrUnexpectedResponse = errors.New("redmine: unexpected response")
+)
+
+// maxErrorBodySize caps how much of an error response body is read.
+const maxErrorBodySize = 64 * 1024
+
+// APIError is returned for any non-2xx response from Redmine.
+type APIError struct {
+	StatusCode int
+	URL        string
+	// Errors holds the messages of a Redmine {"errors": [...]} body, if any.
+	Errors []string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("redmine: %s returned %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Errors) > 0 {
+		msg += ": " + strings.Join(e.Errors, "; ")
+	}
+
+	return msg
+}
+
+// Unwrap maps the status code to one of the sentinel errors.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrUnprocessable
+	case e.StatusCode >= 500:
+		return ErrServer
+	default:
+		return ErrUnexpectedResponse
+	}
+}
+
+type errorsResponse struct {
+	Errors []string `json:"errors"`
+
+	// OAuth errors of the token endpoint, see RFC 6749 section 5.2.
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		URL:        redactedURL(req.URL),
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+	var errResp errorsResponse
+	if json.Unmarshal(body, &errResp) == nil {
+		apiErr.Errors = errResp.Errors
+		if len(apiErr.Errors) == 0 && errResp.ErrorDescription != "" {
+			apiErr.Errors = []string{errResp.ErrorDescription}
+		} else if len(apiErr.Errors) == 0 && errResp.Error != "" {
+			apiErr.Errors = []string{errResp.Error}
+		}
+	}
+
+	return apiErr
+}
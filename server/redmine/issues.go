@@ -0,0 +1,224 @@
+package redmine
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxPageSize is the largest limit Redmine accepts on collection endpoints.
+	MaxPageSize = 100
+
+	// maxIssueIDsLength caps the length of the issue_id filter of a single request so
+	// the URL stays well below the limits of common proxies and web servers.
+	maxIssueIDsLength = 1000
+)
+
+type IssueResponse struct {
+	Issue Issue `json:"issue"`
+}
+
+type IssuesResponse struct {
+	Issues     []Issue `json:"issues"`
+	TotalCount int     `json:"total_count"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
+}
+
+type IssueProperty struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Status struct {
+	IssueProperty
+	IsClosed bool `json:"is_closed"`
+}
+
+type Parent struct {
+	ID int `json:"id"`
+}
+
+type Issue struct {
+	ID                  int           `json:"id"`
+	Project             IssueProperty `json:"project"`
+	Tracker             IssueProperty `json:"tracker"`
+	Status              Status        `json:"status"`
+	Priority            IssueProperty `json:"priority"`
+	Author              IssueProperty `json:"author"`
+	AssignedTo          IssueProperty `json:"assigned_to"`
+	FixedVersion        IssueProperty `json:"fixed_version"`
+	Parent              *Parent       `json:"parent,omitempty"` // Optional field
+	Subject             string        `json:"subject"`
+	Description         string        `json:"description"`
+	StartDate           string        `json:"start_date"`
+	DueDate             *string       `json:"due_date,omitempty"` // Optional field
+	DoneRatio           int           `json:"done_ratio"`
+	IsPrivate           bool          `json:"is_private"`
+	EstimatedHours      *float64      `json:"estimated_hours,omitempty"`       // Optional field
+	TotalEstimatedHours *float64      `json:"total_estimated_hours,omitempty"` // Optional field
+	SpentHours          float64       `json:"spent_hours"`
+	TotalSpentHours     float64       `json:"total_spent_hours"`
+	CreatedOn           string        `json:"created_on"`
+	UpdatedOn           string        `json:"updated_on"`
+	ClosedOn            *string       `json:"closed_on,omitempty"` // Optional field
+	Journals            []Journal     `json:"journals,omitempty"`  // Only present with include=journals
+}
+
+// Journal is a single entry of an issue history: a note, a set of field changes, or both.
+type Journal struct {
+	ID           int             `json:"id"`
+	User         IssueProperty   `json:"user"`
+	Notes        string          `json:"notes"`
+	CreatedOn    string          `json:"created_on"`
+	PrivateNotes bool            `json:"private_notes"`
+	Details      []JournalDetail `json:"details"`
+}
+
+// JournalDetail describes a single field change recorded in a journal.
+type JournalDetail struct {
+	Property string `json:"property"`
+	Name     string `json:"name"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// NewIssue holds the fields of an issue to create. Zero values are left to the Redmine defaults.
+type NewIssue struct {
+	ProjectID    int    `json:"project_id"`
+	TrackerID    int    `json:"tracker_id,omitempty"`
+	PriorityID   int    `json:"priority_id,omitempty"`
+	AssignedToID int    `json:"assigned_to_id,omitempty"`
+	Subject      string `json:"subject"`
+	Description  string `json:"description,omitempty"`
+}
+
+// CreateIssue creates an issue and returns it as stored by Redmine.
+func (c *Client) CreateIssue(ctx context.Context, issue NewIssue) (*Issue, error) {
+	var resp IssueResponse
+	if err := c.post(ctx, "issues.json", map[string]NewIssue{"issue": issue}, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Issue, nil
+}
+
+// GetIssue fetches a single issue. include lists the associated data to embed,
+// e.g. "journals", "children" or "watchers".
+func (c *Client) GetIssue(ctx context.Context, id int, include ...string) (*Issue, error) {
+	query := url.Values{}
+	if len(include) > 0 {
+		query.Set("include", strings.Join(include, ","))
+	}
+
+	var resp IssueResponse
+	if err := c.get(ctx, "issues/"+strconv.Itoa(id)+".json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Issue, nil
+}
+
+// ListIssues runs an issues.json query with the given filter parameters.
+func (c *Client) ListIssues(ctx context.Context, query url.Values) (*IssuesResponse, error) {
+	var resp IssuesResponse
+	if err := c.get(ctx, "issues.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ListAllIssues runs an issues.json query and follows the offset/limit pagination until
+// total_count issues have been collected. On error, the issues of the pages fetched before are
+// returned along with it.
+func (c *Client) ListAllIssues(ctx context.Context, query url.Values) ([]Issue, error) {
+	pageQuery := url.Values{}
+	for key, values := range query {
+		pageQuery[key] = values
+	}
+	pageQuery.Set("limit", strconv.Itoa(MaxPageSize))
+
+	var issues []Issue
+	for offset := 0; ; {
+		pageQuery.Set("offset", strconv.Itoa(offset))
+
+		resp, err := c.ListIssues(ctx, pageQuery)
+		if err != nil {
+			return issues, err
+		}
+		issues = append(issues, resp.Issues...)
+
+		offset += len(resp.Issues)
+		if len(resp.Issues) == 0 || offset >= resp.TotalCount {
+			return issues, nil
+		}
+	}
+}
+
+// GetIssuesByIDs fetches the given issues regardless of their status. Long ID lists are
+// split across several requests; issues that do not exist or are not visible are omitted.
+// When some requests fail, the issues of the others are returned along with the errors.
+func (c *Client) GetIssuesByIDs(ctx context.Context, ids []int) ([]Issue, error) {
+	var issues []Issue
+	var errs []error
+	for _, chunk := range chunkIssueIDs(ids) {
+		query := url.Values{}
+		query.Set("issue_id", chunk)
+		query.Set("status_id", "*")
+
+		chunkIssues, err := c.ListAllIssues(ctx, query)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		issues = append(issues, chunkIssues...)
+	}
+
+	return issues, errors.Join(errs...)
+}
+
+// chunkIssueIDs deduplicates ids and joins them into comma separated lists of at most
+// MaxPageSize IDs and maxIssueIDsLength characters.
+func chunkIssueIDs(ids []int) []string {
+	var chunks []string
+	var builder strings.Builder
+	seen := make(map[int]bool, len(ids))
+	count := 0
+
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		idStr := strconv.Itoa(id)
+		if count == MaxPageSize || (count > 0 && builder.Len()+1+len(idStr) > maxIssueIDsLength) {
+			chunks = append(chunks, builder.String())
+			builder.Reset()
+			count = 0
+		}
+		if count > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(idStr)
+		count++
+	}
+	if count > 0 {
+		chunks = append(chunks, builder.String())
+	}
+
+	return chunks
+}
+
+// GetIssueJournals fetches the history of an issue, oldest entry first.
+func (c *Client) GetIssueJournals(ctx context.Context, id int) ([]Journal, error) {
+	issue, err := c.GetIssue(ctx, id, "journals")
+	if err != nil {
+		return nil, err
+	}
+
+	return issue.Journals, nil
+}
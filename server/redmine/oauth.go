@@ -0,0 +1,122 @@
+package redmine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuthConfig describes an OAuth2 application registered in Redmine under
+// Administration » Applications. Redmine 6.1 and later act as OAuth2 provider.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL must match the redirect URI of the application.
+	RedirectURL string
+	// Scopes are the permissions requested, e.g. view_issues or add_issues.
+	Scopes []string
+}
+
+// Token is an OAuth2 token as returned by the Redmine token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// ExpiresIn is the lifetime of the access token in seconds, zero if it does not expire.
+	ExpiresIn int `json:"expires_in,omitempty"`
+	// Expiry is computed from ExpiresIn when the token is received.
+	Expiry time.Time `json:"expiry"`
+}
+
+// Expired reports whether the access token expires within the given margin.
+func (t *Token) Expired(margin time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(margin).After(t.Expiry)
+}
+
+// AuthorizeURL returns the page the user is sent to in order to grant access. Redmine
+// redirects back to config.RedirectURL with a code and the given state.
+func (c *Client) AuthorizeURL(config OAuthConfig, state string) string {
+	u := c.baseURL.JoinPath("oauth/authorize")
+	u.RawQuery = url.Values{
+		"response_type": {"code"},
+		"client_id":     {config.ClientID},
+		"redirect_uri":  {config.RedirectURL},
+		"scope":         {strings.Join(config.Scopes, " ")},
+		"state":         {state},
+	}.Encode()
+
+	return u.String()
+}
+
+// ExchangeCode trades the authorization code received on the redirect URL for a token.
+func (c *Client) ExchangeCode(ctx context.Context, config OAuthConfig, code string) (*Token, error) {
+	return c.requestToken(ctx, config, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {config.RedirectURL},
+	})
+}
+
+// RefreshToken obtains a new access token. Redmine may rotate the refresh token as well.
+func (c *Client) RefreshToken(ctx context.Context, config OAuthConfig, refreshToken string) (*Token, error) {
+	token, err := c.requestToken(ctx, config, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	return token, nil
+}
+
+// RevokeToken invalidates an access or refresh token.
+func (c *Client) RevokeToken(ctx context.Context, config OAuthConfig, token string) error {
+	req, err := c.newTokenRequest(ctx, "oauth/revoke", config, url.Values{"token": {token}})
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
+
+func (c *Client) requestToken(ctx context.Context, config OAuthConfig, form url.Values) (*Token, error) {
+	req, err := c.newTokenRequest(ctx, "oauth/token", config, form)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+	if err := c.do(req, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%w: no access token in the response of %s", ErrUnexpectedResponse, redactedURL(req.URL))
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	return &token, nil
+}
+
+// newTokenRequest builds a form encoded request authenticated with the client credentials.
+func (c *Client) newTokenRequest(ctx context.Context, path string, config OAuthConfig, form url.Values) (*http.Request, error) {
+	form.Set("client_id", config.ClientID)
+	form.Set("client_secret", config.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL.JoinPath(path).String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return req, nil
+}
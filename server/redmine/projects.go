@@ -0,0 +1,116 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+type Project struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Identifier  string         `json:"identifier"`
+	Description string         `json:"description"`
+	Status      int            `json:"status"`
+	IsPublic    bool           `json:"is_public"`
+	Parent      *IssueProperty `json:"parent,omitempty"` // Optional field
+	CreatedOn   string         `json:"created_on"`
+	UpdatedOn   string         `json:"updated_on"`
+}
+
+type ProjectResponse struct {
+	Project Project `json:"project"`
+}
+
+type ProjectsResponse struct {
+	Projects   []Project `json:"projects"`
+	TotalCount int       `json:"total_count"`
+	Offset     int       `json:"offset"`
+	Limit      int       `json:"limit"`
+}
+
+// Membership is a user or a group given roles in a project.
+type Membership struct {
+	ID      int             `json:"id"`
+	Project IssueProperty   `json:"project"`
+	User    *IssueProperty  `json:"user,omitempty"`  // Set for user memberships
+	Group   *IssueProperty  `json:"group,omitempty"` // Set for group memberships
+	Roles   []IssueProperty `json:"roles"`
+}
+
+type MembershipsResponse struct {
+	Memberships []Membership `json:"memberships"`
+	TotalCount  int          `json:"total_count"`
+	Offset      int          `json:"offset"`
+	Limit       int          `json:"limit"`
+}
+
+// GetProject fetches a project by its numeric ID or its identifier.
+func (c *Client) GetProject(ctx context.Context, idOrIdentifier string) (*Project, error) {
+	var resp ProjectResponse
+	if err := c.get(ctx, "projects/"+url.PathEscape(idOrIdentifier)+".json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Project, nil
+}
+
+// ListProjects lists the projects visible to the current user.
+func (c *Client) ListProjects(ctx context.Context, query url.Values) (*ProjectsResponse, error) {
+	var resp ProjectsResponse
+	if err := c.get(ctx, "projects.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ListAllProjects lists the projects visible to the current user, following the offset/limit
+// pagination until total_count projects have been collected.
+func (c *Client) ListAllProjects(ctx context.Context) ([]Project, error) {
+	var projects []Project
+	for {
+		resp, err := c.ListProjects(ctx, url.Values{
+			"offset": {strconv.Itoa(len(projects))},
+			"limit":  {strconv.Itoa(MaxPageSize)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, resp.Projects...)
+
+		if len(resp.Projects) == 0 || len(projects) >= resp.TotalCount {
+			return projects, nil
+		}
+	}
+}
+
+// ListMemberships lists the members of a project, given by its numeric ID or identifier.
+func (c *Client) ListMemberships(ctx context.Context, project string, query url.Values) (*MembershipsResponse, error) {
+	var resp MembershipsResponse
+	if err := c.get(ctx, "projects/"+url.PathEscape(project)+"/memberships.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ListAllMemberships lists the members of a project like ListMemberships, following the
+// offset/limit pagination until total_count memberships have been collected.
+func (c *Client) ListAllMemberships(ctx context.Context, project string) ([]Membership, error) {
+	var memberships []Membership
+	for {
+		resp, err := c.ListMemberships(ctx, project, url.Values{
+			"offset": {strconv.Itoa(len(memberships))},
+			"limit":  {strconv.Itoa(MaxPageSize)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, resp.Memberships...)
+
+		if len(resp.Memberships) == 0 || len(memberships) >= resp.TotalCount {
+			return memberships, nil
+		}
+	}
+}
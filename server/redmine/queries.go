@@ -0,0 +1,32 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+)
+
+// Query is a saved issue query.
+type Query struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	IsPublic  bool   `json:"is_public"`
+	ProjectID *int   `json:"project_id,omitempty"` // Optional field, unset for global queries
+}
+
+type QueriesResponse struct {
+	Queries    []Query `json:"queries"`
+	TotalCount int     `json:"total_count"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
+}
+
+// ListQueries lists the saved queries visible to the user. Issues matching a query are listed
+// with ListIssues and the query_id parameter.
+func (c *Client) ListQueries(ctx context.Context, query url.Values) (*QueriesResponse, error) {
+	var resp QueriesResponse
+	if err := c.get(ctx, "queries.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
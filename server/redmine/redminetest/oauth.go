@@ -0,0 +1,72 @@
+package redminetest
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// oauthProvider fakes the token endpoints of the Redmine OAuth2 provider.
+type oauthProvider struct {
+	clientID      string
+	clientSecret  string
+	lifetime      int
+	codes         map[string]int
+	accessTokens  map[string]int
+	refreshTokens map[string]int
+	issued        int
+}
+
+func (o *oauthProvider) serveHTTP(w http.ResponseWriter, r *http.Request, path string) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+	if o.clientID == "" || r.PostForm.Get("client_id") != o.clientID || r.PostForm.Get("client_secret") != o.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client", "error_description": "Client authentication failed."})
+		return
+	}
+
+	if path == "/oauth/revoke" {
+		token := r.PostForm.Get("token")
+		delete(o.accessTokens, token)
+		delete(o.refreshTokens, token)
+		writeJSON(w, http.StatusOK, map[string]any{})
+		return
+	}
+
+	var userID int
+	var ok bool
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		userID, ok = o.codes[code]
+		delete(o.codes, code)
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		userID, ok = o.refreshTokens[refreshToken]
+		delete(o.refreshTokens, refreshToken)
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant", "error_description": "The provided authorization grant is invalid."})
+		return
+	}
+
+	o.issued++
+	accessToken := "access-" + strconv.Itoa(o.issued)
+	refreshToken := "refresh-" + strconv.Itoa(o.issued)
+	o.accessTokens[accessToken] = userID
+	o.refreshTokens[refreshToken] = userID
+
+	response := map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"refresh_token": refreshToken,
+	}
+	if o.lifetime > 0 {
+		response["expires_in"] = o.lifetime
+	}
+	writeJSON(w, http.StatusOK, response)
+}
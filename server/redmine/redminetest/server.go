@@ -0,0 +1,758 @@
+// Package redminetest provides an in-memory fake of the Redmine REST API for tests.
+package redminetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+// Server is a fake Redmine instance backed by in-memory fixtures.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	apiKey      string
+	userAPIKeys map[string]int
+	oauth       oauthProvider
+	pathPrefix  string
+	issues      map[int]redmine.Issue
+	projects    map[int]redmine.Project
+	users       map[int]redmine.User
+	versions    map[int]redmine.Version
+	wikiPages   map[string]redmine.WikiPage
+	queries     map[int]savedQuery
+	timeEntries map[int]redmine.TimeEntry
+	trackers    map[int]redmine.Tracker
+	priorities  map[int]redmine.Enumeration
+	memberships map[int]redmine.Membership
+	currentUser int
+	// privateIssues makes the created issues private.
+	privateIssues bool
+	viewer        viewer
+	failStatus    int
+	failBody      string
+	failIssues    map[int]failure
+	requests      []string
+}
+
+// NewServer starts a fake Redmine server. It is closed automatically at the end of the test.
+func NewServer(t interface{ Cleanup(func()) }) *Server {
+	s := &Server{
+		issues:      map[int]redmine.Issue{},
+		projects:    map[int]redmine.Project{},
+		users:       map[int]redmine.User{},
+		versions:    map[int]redmine.Version{},
+		wikiPages:   map[string]redmine.WikiPage{},
+		queries:     map[int]savedQuery{},
+		failIssues:  map[int]failure{},
+		timeEntries: map[int]redmine.TimeEntry{},
+		trackers:    map[int]redmine.Tracker{},
+		priorities:  map[int]redmine.Enumeration{},
+		memberships: map[int]redmine.Membership{},
+		userAPIKeys: map[string]int{},
+		oauth: oauthProvider{
+			codes:         map[string]int{},
+			accessTokens:  map[string]int{},
+			refreshTokens: map[string]int{},
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// HTTPClient returns an http.Client that sends every request to the fake server
+// regardless of the host in the request URL, so tests can keep using
+// production-looking links such as https://www.redmine.org/issues/1.
+func (s *Server) HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: roundTripperFunc(s.roundTrip),
+	}
+}
+
+// RoutingHTTPClient returns an http.Client that sends each request to the fake server
+// registered for its host name, so several Redmine instances can be faked at once.
+// Requests to unknown hosts fail.
+func RoutingHTTPClient(servers map[string]*Server) *http.Client {
+	return &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			server, ok := servers[req.URL.Hostname()]
+			if !ok {
+				return nil, fmt.Errorf("no fake Redmine server for host %q", req.URL.Hostname())
+			}
+
+			return server.roundTrip(req)
+		}),
+	}
+}
+
+func (s *Server) roundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(s.URL)
+
+	clone := req.Clone(req.Context())
+	clone.URL.Scheme = target.Scheme
+	clone.URL.Host = target.Host
+	clone.Host = target.Host
+
+	return http.DefaultTransport.RoundTrip(clone)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// SetAPIKey makes the given key required in the X-Redmine-API-Key header of every
+// request. An empty key allows anonymous access.
+func (s *Server) SetAPIKey(apiKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = apiKey
+}
+
+// AddUserAPIKey accepts the personal API key of a user in addition to the key set with
+// SetAPIKey. Requests made with it act as that user, who only sees private issues they
+// authored or are assigned to.
+func (s *Server) AddUserAPIKey(apiKey string, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userAPIKeys[apiKey] = userID
+}
+
+// SetOAuthClient registers the OAuth2 application accepted by the token endpoint. Access
+// tokens expire after the given lifetime in seconds, or never when it is zero.
+func (s *Server) SetOAuthClient(clientID, clientSecret string, lifetime int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oauth.clientID = clientID
+	s.oauth.clientSecret = clientSecret
+	s.oauth.lifetime = lifetime
+}
+
+// AddOAuthCode issues an authorization code for a user, as if they granted access on the
+// authorize page.
+func (s *Server) AddOAuthCode(code string, userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oauth.codes[code] = userID
+}
+
+// RevokeAccessTokens invalidates all access tokens issued so far, as if they expired.
+// Refresh tokens stay valid.
+func (s *Server) RevokeAccessTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oauth.accessTokens = map[string]int{}
+}
+
+// SetPathPrefix serves the API under a sub-path such as /redmine, like a Redmine deployed
+// behind a reverse proxy. Requests outside the prefix get a 404.
+func (s *Server) SetPathPrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathPrefix = strings.TrimSuffix(prefix, "/")
+}
+
+// AddIssue stores or replaces an issue fixture.
+func (s *Server) AddIssue(issue redmine.Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[issue.ID] = issue
+}
+
+// DeleteIssue removes an issue fixture.
+func (s *Server) DeleteIssue(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.issues, id)
+}
+
+// AddProject stores or replaces a project fixture.
+func (s *Server) AddProject(project redmine.Project) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projects[project.ID] = project
+}
+
+// AddUser stores or replaces a user fixture.
+func (s *Server) AddUser(user redmine.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// SetCurrentUser selects the user returned by users/current.json.
+func (s *Server) SetCurrentUser(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentUser = id
+}
+
+// SetPrivateIssues makes the issues created through the API private, like the issues of a
+// tracker Redmine marks private by default.
+func (s *Server) SetPrivateIssues(private bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.privateIssues = private
+}
+
+// AddVersion stores or replaces a version fixture.
+func (s *Server) AddVersion(version redmine.Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[version.ID] = version
+}
+
+// AddWikiPage stores or replaces a wiki page fixture of the project with the given identifier.
+func (s *Server) AddWikiPage(project string, page redmine.WikiPage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wikiPages[project+"/"+page.Title] = page
+}
+
+// savedQuery is a query fixture with the filter parameters it applies.
+type savedQuery struct {
+	redmine.Query
+	filter url.Values
+}
+
+// AddQuery stores or replaces a saved query fixture. Listing issues with its query_id applies
+// the given filter parameters.
+func (s *Server) AddQuery(query redmine.Query, filter url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[query.ID] = savedQuery{Query: query, filter: filter}
+}
+
+// AddTimeEntry stores or replaces a time entry fixture.
+func (s *Server) AddTimeEntry(entry redmine.TimeEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeEntries[entry.ID] = entry
+}
+
+// AddTracker stores or replaces a tracker fixture.
+func (s *Server) AddTracker(tracker redmine.Tracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trackers[tracker.ID] = tracker
+}
+
+// AddPriority stores or replaces an issue priority fixture.
+func (s *Server) AddPriority(priority redmine.Enumeration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.priorities[priority.ID] = priority
+}
+
+// AddMembership stores or replaces a project membership fixture.
+func (s *Server) AddMembership(membership redmine.Membership) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memberships[membership.ID] = membership
+}
+
+// FailWith makes every following request fail with the given status and raw body.
+// A zero status restores normal behavior.
+func (s *Server) FailWith(status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failStatus = status
+	s.failBody = body
+}
+
+// failure is a response given instead of the requested data.
+type failure struct {
+	status int
+	body   string
+}
+
+func (f failure) write(w http.ResponseWriter) {
+	w.WriteHeader(f.status)
+	_, _ = w.Write([]byte(f.body))
+}
+
+// FailIssue makes the requests returning the given issue, alone or in a list, fail with the
+// given status and raw body. A zero status restores normal behavior.
+func (s *Server) FailIssue(id, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failIssues, id)
+		return
+	}
+	s.failIssues[id] = failure{status: status, body: body}
+}
+
+// Requests returns the path and query of every request received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+var (
+	issuePath       = regexp.MustCompile(`^/issues/(\d+)\.json$`)
+	projectPath     = regexp.MustCompile(`^/projects/([^/]+)\.json$`)
+	projectVersions = regexp.MustCompile(`^/projects/([^/]+)/versions\.json$`)
+	projectMembers  = regexp.MustCompile(`^/projects/([^/]+)/memberships\.json$`)
+	wikiPagePath    = regexp.MustCompile(`^/projects/([^/]+)/wiki/([^/]+)\.json$`)
+	userPath        = regexp.MustCompile(`^/users/(\d+|current)\.json$`)
+	versionPath     = regexp.MustCompile(`^/versions/(\d+)\.json$`)
+	timeEntryPath   = regexp.MustCompile(`^/time_entries/(\d+)\.json$`)
+)
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.URL.RequestURI())
+
+	if s.failStatus != 0 {
+		failure{status: s.failStatus, body: s.failBody}.write(w)
+		return
+	}
+
+	path := r.URL.Path
+	if s.pathPrefix != "" {
+		if !strings.HasPrefix(path, s.pathPrefix+"/") {
+			writeNotFound(w)
+			return
+		}
+		path = strings.TrimPrefix(path, s.pathPrefix)
+	}
+
+	if r.Method == http.MethodPost && (path == "/oauth/token" || path == "/oauth/revoke") {
+		s.oauth.serveHTTP(w, r, path)
+		return
+	}
+
+	apiKey := r.Header.Get("X-Redmine-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		userID, ok := s.oauth.accessTokens[bearer]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.viewer = viewer{userID: userID, restricted: true}
+	} else if userID, ok := s.userAPIKeys[apiKey]; ok {
+		s.viewer = viewer{userID: userID, restricted: true}
+	} else if s.apiKey != "" && apiKey != s.apiKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	} else {
+		s.viewer = viewer{userID: s.currentUser, anonymous: apiKey == ""}
+	}
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodGet && path == "/issues.json":
+		if queryID := query.Get("query_id"); queryID != "" {
+			id, _ := strconv.Atoi(queryID)
+			saved, ok := s.queries[id]
+			if !ok {
+				writeNotFound(w)
+				return
+			}
+			for key, values := range saved.filter {
+				query[key] = values
+			}
+		}
+		issues := s.filterIssues(query)
+		offset, limit := pagination(query)
+		page := paginate(issues, offset, limit)
+		for _, issue := range page {
+			if failure, ok := s.failIssues[issue.ID]; ok {
+				failure.write(w)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issues":      page,
+			"total_count": len(issues),
+			"offset":      offset,
+			"limit":       limit,
+		})
+	case r.Method == http.MethodPost && path == "/issues.json":
+		s.createIssue(w, r)
+	case r.Method == http.MethodGet && issuePath.MatchString(path):
+		id, _ := strconv.Atoi(issuePath.FindStringSubmatch(path)[1])
+		issue, ok := s.issues[id]
+		if !ok || !s.viewer.canSee(issue) {
+			writeNotFound(w)
+			return
+		}
+		if failure, ok := s.failIssues[id]; ok {
+			failure.write(w)
+			return
+		}
+		if !strings.Contains(query.Get("include"), "journals") {
+			issue.Journals = nil
+		} else {
+			issue.Journals = s.viewer.visibleJournals(issue.Journals)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"issue": issue})
+	case r.Method == http.MethodGet && path == "/projects.json":
+		projects := sortedValues(s.projects)
+		offset, limit := pagination(query)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"projects":    paginate(projects, offset, limit),
+			"total_count": len(projects),
+			"offset":      offset,
+			"limit":       limit,
+		})
+	case r.Method == http.MethodGet && projectPath.MatchString(path):
+		project, ok := s.findProject(projectPath.FindStringSubmatch(path)[1])
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"project": project})
+	case r.Method == http.MethodGet && projectVersions.MatchString(path):
+		project, ok := s.findProject(projectVersions.FindStringSubmatch(path)[1])
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		versions := []redmine.Version{}
+		for _, version := range sortedValues(s.versions) {
+			if version.Project.ID == project.ID {
+				versions = append(versions, version)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+	case r.Method == http.MethodGet && projectMembers.MatchString(path):
+		project, ok := s.findProject(projectMembers.FindStringSubmatch(path)[1])
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		memberships := []redmine.Membership{}
+		for _, membership := range sortedValues(s.memberships) {
+			if membership.Project.ID == project.ID {
+				memberships = append(memberships, membership)
+			}
+		}
+		offset, limit := pagination(query)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"memberships": paginate(memberships, offset, limit),
+			"total_count": len(memberships),
+			"offset":      offset,
+			"limit":       limit,
+		})
+	case r.Method == http.MethodGet && wikiPagePath.MatchString(path):
+		match := wikiPagePath.FindStringSubmatch(path)
+		page, ok := s.wikiPages[match[1]+"/"+match[2]]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"wiki_page": page})
+	case r.Method == http.MethodGet && path == "/queries.json":
+		queries := []redmine.Query{}
+		for _, saved := range sortedValues(s.queries) {
+			queries = append(queries, saved.Query)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"queries": queries, "total_count": len(queries)})
+	case r.Method == http.MethodGet && path == "/trackers.json":
+		writeJSON(w, http.StatusOK, map[string]any{"trackers": sortedValues(s.trackers)})
+	case r.Method == http.MethodGet && path == "/enumerations/issue_priorities.json":
+		writeJSON(w, http.StatusOK, map[string]any{"issue_priorities": sortedValues(s.priorities)})
+	case r.Method == http.MethodGet && path == "/users.json":
+		users := []redmine.User{}
+		name := strings.ToLower(query.Get("name"))
+		for _, user := range sortedValues(s.users) {
+			if name != "" && !strings.Contains(strings.ToLower(strings.Join([]string{user.Login, user.Firstname, user.Lastname, user.Mail}, " ")), name) {
+				continue
+			}
+			users = append(users, user)
+		}
+		offset, limit := pagination(query)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"users":       paginate(users, offset, limit),
+			"total_count": len(users),
+			"offset":      offset,
+			"limit":       limit,
+		})
+	case r.Method == http.MethodGet && userPath.MatchString(path):
+		id := s.viewer.userID
+		if match := userPath.FindStringSubmatch(path)[1]; match != "current" {
+			id, _ = strconv.Atoi(match)
+		}
+		user, ok := s.users[id]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"user": user})
+	case r.Method == http.MethodGet && versionPath.MatchString(path):
+		id, _ := strconv.Atoi(versionPath.FindStringSubmatch(path)[1])
+		version, ok := s.versions[id]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"version": version})
+	case r.Method == http.MethodGet && path == "/time_entries.json":
+		entries := []redmine.TimeEntry{}
+		for _, entry := range sortedValues(s.timeEntries) {
+			if issueID := query.Get("issue_id"); issueID != "" && (entry.Issue == nil || strconv.Itoa(entry.Issue.ID) != issueID) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"time_entries": entries})
+	case r.Method == http.MethodGet && timeEntryPath.MatchString(path):
+		id, _ := strconv.Atoi(timeEntryPath.FindStringSubmatch(path)[1])
+		entry, ok := s.timeEntries[id]
+		if !ok {
+			writeNotFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"time_entry": entry})
+	default:
+		writeNotFound(w)
+	}
+}
+
+// createIssue stores a new issue the way POST /issues.json does, resolving the names of the
+// referenced project, tracker, priority and assignee from the fixtures.
+func (s *Server) createIssue(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Issue redmine.NewIssue `json:"issue"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []string{err.Error()}})
+		return
+	}
+
+	var errs []string
+	project, ok := s.projects[req.Issue.ProjectID]
+	if !ok {
+		errs = append(errs, "Project cannot be blank")
+	}
+	if strings.TrimSpace(req.Issue.Subject) == "" {
+		errs = append(errs, "Subject cannot be blank")
+	}
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"errors": errs})
+		return
+	}
+
+	id := 1
+	for existing := range s.issues {
+		id = max(id, existing+1)
+	}
+
+	issue := redmine.Issue{
+		ID:          id,
+		Project:     redmine.IssueProperty{ID: project.ID, Name: project.Name},
+		Tracker:     redmine.IssueProperty{ID: req.Issue.TrackerID, Name: s.trackers[req.Issue.TrackerID].Name},
+		Status:      redmine.Status{IssueProperty: redmine.IssueProperty{ID: 1, Name: "New"}},
+		Priority:    redmine.IssueProperty{ID: req.Issue.PriorityID, Name: s.priorities[req.Issue.PriorityID].Name},
+		Author:      redmine.IssueProperty{ID: s.viewer.userID, Name: s.userName(s.viewer.userID)},
+		Subject:     req.Issue.Subject,
+		Description: req.Issue.Description,
+		IsPrivate:   s.privateIssues,
+	}
+	if req.Issue.AssignedToID != 0 {
+		issue.AssignedTo = redmine.IssueProperty{ID: req.Issue.AssignedToID, Name: s.userName(req.Issue.AssignedToID)}
+	}
+	s.issues[id] = issue
+
+	writeJSON(w, http.StatusCreated, map[string]any{"issue": issue})
+}
+
+// viewer is the user a request is made as.
+type viewer struct {
+	userID int
+	// anonymous requests carry no API key and do not see private issues.
+	anonymous bool
+	// restricted requests are made with a personal API key and only see the private issues
+	// of their user.
+	restricted bool
+}
+
+func (v viewer) canSee(issue redmine.Issue) bool {
+	switch {
+	case !issue.IsPrivate:
+		return true
+	case v.anonymous:
+		return false
+	case v.restricted:
+		return issue.Author.ID == v.userID || issue.AssignedTo.ID == v.userID
+	default:
+		return true
+	}
+}
+
+// visibleJournals leaves out the private notes of other users for anonymous and restricted
+// viewers, who lack the permission to view private notes.
+func (v viewer) visibleJournals(journals []redmine.Journal) []redmine.Journal {
+	if !v.anonymous && !v.restricted {
+		return journals
+	}
+
+	visible := []redmine.Journal{}
+	for _, journal := range journals {
+		if !journal.PrivateNotes || (v.restricted && journal.User.ID == v.userID) {
+			visible = append(visible, journal)
+		}
+	}
+
+	return visible
+}
+
+func (s *Server) userName(id int) string {
+	user, ok := s.users[id]
+	if !ok {
+		return ""
+	}
+
+	return user.Name()
+}
+
+// filterIssues implements the subset of issues.json filters used by the plugin.
+func (s *Server) filterIssues(query url.Values) []redmine.Issue {
+	var ids map[string]bool
+	if issueIDs := query.Get("issue_id"); issueIDs != "" {
+		ids = map[string]bool{}
+		for _, id := range strings.Split(issueIDs, ",") {
+			ids[strings.TrimSpace(id)] = true
+		}
+	}
+
+	issues := []redmine.Issue{}
+	for _, issue := range sortedValues(s.issues) {
+		if ids != nil && !ids[strconv.Itoa(issue.ID)] {
+			continue
+		}
+		if !s.viewer.canSee(issue) {
+			continue
+		}
+		if !matchStatus(query.Get("status_id"), issue.Status) {
+			continue
+		}
+		if projectID := query.Get("project_id"); projectID != "" {
+			if project, ok := s.findProject(projectID); !ok || project.ID != issue.Project.ID {
+				continue
+			}
+		}
+		if versionID := query.Get("fixed_version_id"); versionID != "" && strconv.Itoa(issue.FixedVersion.ID) != versionID {
+			continue
+		}
+		if updatedOn := query.Get("updated_on"); strings.HasPrefix(updatedOn, ">=") && issue.UpdatedOn < strings.TrimPrefix(updatedOn, ">=") {
+			continue
+		}
+		if subject := query.Get("subject"); strings.HasPrefix(subject, "~") &&
+			!strings.Contains(strings.ToLower(issue.Subject), strings.ToLower(strings.TrimPrefix(subject, "~"))) {
+			continue
+		}
+		if assignee := query.Get("assigned_to_id"); assignee != "" {
+			if assignee == "me" {
+				assignee = strconv.Itoa(s.viewer.userID)
+			}
+			if strconv.Itoa(issue.AssignedTo.ID) != assignee {
+				continue
+			}
+		}
+		issue.Journals = nil
+		issues = append(issues, issue)
+	}
+
+	if order := query.Get("sort"); strings.HasPrefix(order, "updated_on") {
+		desc := strings.HasPrefix(order, "updated_on:desc")
+		sort.SliceStable(issues, func(i, j int) bool {
+			if desc {
+				return issues[i].UpdatedOn > issues[j].UpdatedOn
+			}
+			return issues[i].UpdatedOn < issues[j].UpdatedOn
+		})
+	}
+
+	return issues
+}
+
+func matchStatus(filter string, status redmine.Status) bool {
+	switch filter {
+	case "*":
+		return true
+	case "", "o":
+		return !status.IsClosed
+	case "c":
+		return status.IsClosed
+	default:
+		return filter == strconv.Itoa(status.ID)
+	}
+}
+
+// pagination parses offset and limit the way Redmine does: 25 items by default, 100 at most.
+func pagination(query url.Values) (offset, limit int) {
+	offset, _ = strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 25
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	return offset, min(limit, redmine.MaxPageSize)
+}
+
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+
+	return items[offset:min(offset+limit, len(items))]
+}
+
+func (s *Server) findProject(idOrIdentifier string) (redmine.Project, bool) {
+	for _, project := range s.projects {
+		if strconv.Itoa(project.ID) == idOrIdentifier || project.Identifier == idOrIdentifier {
+			return project, true
+		}
+	}
+
+	return redmine.Project{}, false
+}
+
+// sortedValues returns the map values ordered by key, mimicking Redmine's stable ordering.
+func sortedValues[T any](m map[int]T) []T {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	values := make([]T, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, m[key])
+	}
+
+	return values
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+type TimeEntry struct {
+	ID        int           `json:"id"`
+	Project   IssueProperty `json:"project"`
+	Issue     *Parent       `json:"issue,omitempty"` // Optional field
+	User      IssueProperty `json:"user"`
+	Activity  IssueProperty `json:"activity"`
+	Hours     float64       `json:"hours"`
+	Comments  string        `json:"comments"`
+	SpentOn   string        `json:"spent_on"`
+	CreatedOn string        `json:"created_on"`
+	UpdatedOn string        `json:"updated_on"`
+}
+
+type TimeEntryResponse struct {
+	TimeEntry TimeEntry `json:"time_entry"`
+}
+
+type TimeEntriesResponse struct {
+	TimeEntries []TimeEntry `json:"time_entries"`
+}
+
+// GetTimeEntry fetches a single time entry.
+func (c *Client) GetTimeEntry(ctx context.Context, id int) (*TimeEntry, error) {
+	var resp TimeEntryResponse
+	if err := c.get(ctx, "time_entries/"+strconv.Itoa(id)+".json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.TimeEntry, nil
+}
+
+// ListTimeEntries lists time entries matching the given filter parameters,
+// e.g. issue_id, project_id, user_id, from and to.
+func (c *Client) ListTimeEntries(ctx context.Context, query url.Values) (*TimeEntriesResponse, error) {
+	var resp TimeEntriesResponse
+	if err := c.get(ctx, "time_entries.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
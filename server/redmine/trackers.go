@@ -0,0 +1,26 @@
+package redmine
+
+import (
+	"context"
+)
+
+type Tracker struct {
+	ID            int            `json:"id"`
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	DefaultStatus *IssueProperty `json:"default_status,omitempty"`
+}
+
+type TrackersResponse struct {
+	Trackers []Tracker `json:"trackers"`
+}
+
+// ListTrackers lists all trackers.
+func (c *Client) ListTrackers(ctx context.Context) ([]Tracker, error) {
+	var resp TrackersResponse
+	if err := c.get(ctx, "trackers.json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Trackers, nil
+}
@@ -0,0 +1,73 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+type User struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Mail      string `json:"mail"`
+	Admin     bool   `json:"admin"`
+	CreatedOn string `json:"created_on"`
+	LastLogin string `json:"last_login_on"`
+}
+
+type UserResponse struct {
+	User User `json:"user"`
+}
+
+// Name returns the display name of the user the way Redmine renders it by default.
+func (u *User) Name() string {
+	switch {
+	case u.Firstname != "" && u.Lastname != "":
+		return u.Firstname + " " + u.Lastname
+	case u.Firstname != "" || u.Lastname != "":
+		return u.Firstname + u.Lastname
+	default:
+		return u.Login
+	}
+}
+
+// GetUser fetches a user by ID. Redmine only allows this for administrators
+// or for users sharing a project with the requester.
+func (c *Client) GetUser(ctx context.Context, id int) (*User, error) {
+	var resp UserResponse
+	if err := c.get(ctx, "users/"+strconv.Itoa(id)+".json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.User, nil
+}
+
+// GetCurrentUser fetches the user the client is authenticated as.
+func (c *Client) GetCurrentUser(ctx context.Context) (*User, error) {
+	var resp UserResponse
+	if err := c.get(ctx, "users/current.json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.User, nil
+}
+
+type UsersResponse struct {
+	Users      []User `json:"users"`
+	TotalCount int    `json:"total_count"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+}
+
+// ListUsers runs a users.json query, e.g. with a name filter matching the login, first
+// name, last name or email of users. Redmine only allows this for administrators.
+func (c *Client) ListUsers(ctx context.Context, query url.Values) (*UsersResponse, error) {
+	var resp UsersResponse
+	if err := c.get(ctx, "users.json", query, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,50 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+type Version struct {
+	ID             int           `json:"id"`
+	Project        IssueProperty `json:"project"`
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	Status         string        `json:"status"`
+	DueDate        *string       `json:"due_date,omitempty"` // Optional field
+	Sharing        string        `json:"sharing"`
+	WikiPageTitle  string        `json:"wiki_page_title"`
+	EstimatedHours float64       `json:"estimated_hours"`
+	SpentHours     float64       `json:"spent_hours"`
+	CreatedOn      string        `json:"created_on"`
+	UpdatedOn      string        `json:"updated_on"`
+}
+
+type VersionResponse struct {
+	Version Version `json:"version"`
+}
+
+type VersionsResponse struct {
+	Versions []Version `json:"versions"`
+}
+
+// GetVersion fetches a single version.
+func (c *Client) GetVersion(ctx context.Context, id int) (*Version, error) {
+	var resp VersionResponse
+	if err := c.get(ctx, "versions/"+strconv.Itoa(id)+".json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Version, nil
+}
+
+// ListVersions lists the versions available in a project, including shared ones.
+func (c *Client) ListVersions(ctx context.Context, project string) ([]Version, error) {
+	var resp VersionsResponse
+	if err := c.get(ctx, "projects/"+url.PathEscape(project)+"/versions.json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Versions, nil
+}
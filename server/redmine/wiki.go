@@ -0,0 +1,35 @@
+package redmine
+
+import (
+	"context"
+	"net/url"
+)
+
+type WikiPage struct {
+	Title     string         `json:"title"`
+	Parent    *WikiPageTitle `json:"parent,omitempty"` // Optional field
+	Text      string         `json:"text"`
+	Version   int            `json:"version"`
+	Author    IssueProperty  `json:"author"`
+	Comments  string         `json:"comments"`
+	CreatedOn string         `json:"created_on"`
+	UpdatedOn string         `json:"updated_on"`
+}
+
+type WikiPageTitle struct {
+	Title string `json:"title"`
+}
+
+type WikiPageResponse struct {
+	WikiPage WikiPage `json:"wiki_page"`
+}
+
+// GetWikiPage fetches the current version of a wiki page of a project.
+func (c *Client) GetWikiPage(ctx context.Context, project, title string) (*WikiPage, error) {
+	var resp WikiPageResponse
+	if err := c.get(ctx, "projects/"+url.PathEscape(project)+"/wiki/"+url.PathEscape(title)+".json", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.WikiPage, nil
+}
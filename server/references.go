@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+	"github.com/pkg/errors"
+)
+
+// shortReferenceTimeout bounds the time spent matching an administrator supplied pattern.
+const shortReferenceTimeout = 100 * time.Millisecond
+
+// shortReference is a shorthand for issues such as #1234 or PROJ-1234, configured in the
+// ShortReferences setting.
+type shortReference struct {
+	// Pattern is a regular expression capturing the issue ID, either in a group named id or
+	// in the first group, e.g. PROJ-(\d+).
+	Pattern string `json:"pattern"`
+	// Instance is the URL or label of the instance the issues belong to. Defaults to the
+	// first configured instance.
+	Instance string `json:"instance"`
+	// Teams restricts the shorthand to the given team names or IDs. Empty means all teams.
+	Teams []string `json:"teams"`
+	// Channels restricts the shorthand to the given channel names or IDs. Empty means all
+	// channels.
+	Channels []string `json:"channels"`
+
+	re       *regexp2.Regexp
+	instance *redmineInstance
+}
+
+// issueID returns the issue ID captured by a match of the shorthand.
+func (r *shortReference) issueID(match *regexp2.Match) string {
+	if group := match.GroupByName("id"); group != nil && group.Length > 0 {
+		return group.String()
+	}
+
+	return match.GroupByNumber(1).String()
+}
+
+// parseShortReferences parses the ShortReferences setting for the given instances.
+func (c *configuration) parseShortReferences(instances []*redmineInstance) ([]*shortReference, error) {
+	if strings.TrimSpace(c.ShortReferences) == "" {
+		return nil, nil
+	}
+
+	var references []*shortReference
+	if err := json.Unmarshal([]byte(c.ShortReferences), &references); err != nil {
+		return nil, errors.Wrap(err, "failed to parse short references")
+	}
+
+	for i, reference := range references {
+		if reference.Pattern == "" {
+			return nil, fmt.Errorf("short reference #%d has no pattern", i+1)
+		}
+
+		// Shorthands must stand on their own, and are ignored in the text of markdown links.
+		re, err := regexp2.Compile(`(?<![\w\[/#-])(?:`+reference.Pattern+`)(?![\w-])(?![^\[]*\])`, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "short reference %q has an invalid pattern", reference.Pattern)
+		}
+		if re.GroupNumberFromName("id") == -1 && len(re.GetGroupNumbers()) < 2 {
+			return nil, fmt.Errorf("short reference %q does not capture the issue ID", reference.Pattern)
+		}
+		re.MatchTimeout = shortReferenceTimeout
+		reference.re = re
+
+		reference.instance = findInstance(instances, reference.Instance)
+		if reference.instance == nil {
+			return nil, fmt.Errorf("short reference %q refers to an unknown instance %q", reference.Pattern, reference.Instance)
+		}
+	}
+
+	return references, nil
+}
+
+// findInstance returns the instance with the given URL or label, or the first instance when
+// nameOrURL is empty.
+func findInstance(instances []*redmineInstance, nameOrURL string) *redmineInstance {
+	if nameOrURL == "" {
+		if len(instances) == 0 {
+			return nil
+		}
+		return instances[0]
+	}
+
+	redmineURL, _ := getRedmineInstanceURL(nameOrURL)
+	for _, instance := range instances {
+		if instance.Label != "" && strings.EqualFold(instance.Label, nameOrURL) {
+			return instance
+		}
+		if instanceURL, _ := getRedmineInstanceURL(instance.URL); redmineURL != "" && instanceURL == redmineURL {
+			return instance
+		}
+	}
+
+	return nil
+}
+
+// getShortReferences returns the configured shorthands.
+func (c *configuration) getShortReferences() []*shortReference {
+	if c.shortReferences != nil {
+		return c.shortReferences
+	}
+
+	references, _ := c.parseShortReferences(c.getInstances())
+	return references
+}
+
+// issueReference is an issue mentioned in a message, either by its URL or with a shorthand.
+type issueReference struct {
+	// start and end are the byte offsets of the reference in the message.
+	start, end int
+	// text is the reference as written in the message.
+	text string
+
+	issueID string
+	// anchor is the fragment of the link including the hash, e.g. #note-4.
+	anchor string
+	// url is the target of the rendered link.
+	url string
+
+	instance *redmineInstance
+}
+
+// textMatch is a match of a regular expression with its byte offsets in the input.
+type textMatch struct {
+	start, end int
+	text       string
+	match      *regexp2.Match
+}
+
+// findAllMatches returns the matches of re in input. regexp2 reports positions in runes, which
+// are converted to byte offsets.
+func findAllMatches(re *regexp2.Regexp, input string) []textMatch {
+	var matches []textMatch
+	var offsets []int
+
+	match, _ := re.FindStringMatch(input)
+	for match != nil {
+		if offsets == nil {
+			offsets = make([]int, 0, len(input)+1)
+			for i := range input {
+				offsets = append(offsets, i)
+			}
+			offsets = append(offsets, len(input))
+		}
+
+		matches = append(matches, textMatch{
+			start: offsets[match.Index],
+			end:   offsets[match.Index+match.Length],
+			text:  match.String(),
+			match: match,
+		})
+		match, _ = re.FindNextMatch(match)
+	}
+
+	return matches
+}
+
+// findIssueReferences returns the issue links and shorthands in the plain text of a message, in
+// order of appearance and without overlaps.
+func (p *Plugin) findIssueReferences(message string, scope *channelScope) []issueReference {
+	var references []issueReference
+
+	for _, instance := range p.getInstancesForScope(scope) {
+		redmineURL, redmineHost := getRedmineInstanceURL(instance.URL)
+		if redmineURL == "" {
+			continue
+		}
+
+		for _, link := range extractTrackerLinks(message, redmineHost) {
+			parsedLink, err := parseLink(link.text)
+			if err != nil {
+				continue
+			}
+
+			anchor := ""
+			if parsedLink["Hash"] != "" {
+				anchor = "#" + parsedLink["Hash"]
+			}
+
+			references = append(references, issueReference{
+				start:    link.start,
+				end:      link.end,
+				text:     link.text,
+				issueID:  issueIDFromPath(parsedLink["Path"]),
+				anchor:   anchor,
+				url:      link.text,
+				instance: instance,
+			})
+		}
+	}
+
+	for _, shorthand := range p.getConfiguration().getShortReferences() {
+		if !scope.allows(shorthand.Teams, shorthand.Channels) || !scope.allows(shorthand.instance.Teams, nil) {
+			continue
+		}
+
+		redmineURL, _ := getRedmineInstanceURL(shorthand.instance.URL)
+		for _, match := range findAllMatches(shorthand.re, message) {
+			issueID := shorthand.issueID(match.match)
+			if _, err := strconv.Atoi(issueID); err != nil {
+				continue
+			}
+
+			references = append(references, issueReference{
+				start:    match.start,
+				end:      match.end,
+				text:     match.text,
+				issueID:  issueID,
+				url:      redmineURL + "issues/" + issueID,
+				instance: shorthand.instance,
+			})
+		}
+	}
+
+	return removeOverlappingReferences(filterPlainTextReferences(message, references))
+}
+
+// removeOverlappingReferences sorts references by position, keeping the longest of
+// references starting at the same position and dropping those inside an earlier one.
+func removeOverlappingReferences(references []issueReference) []issueReference {
+	sort.SliceStable(references, func(i, j int) bool {
+		if references[i].start != references[j].start {
+			return references[i].start < references[j].start
+		}
+		return references[i].end > references[j].end
+	})
+
+	kept := references[:0]
+	end := 0
+	for _, reference := range references {
+		if reference.start < end {
+			continue
+		}
+		kept = append(kept, reference)
+		end = reference.end
+	}
+
+	return kept
+}
+
+// extractTrackerLinks finds the issue links of the instance whose URL, without the scheme, is
+// redmineHost. redmineHost may include a port and a sub-path, e.g. example.com:8080/redmine.
+// Links that are part of markdown links or code are left to filterPlainTextReferences.
+func extractTrackerLinks(input string, redmineHost string) []textMatch {
+	pattern := `(?:https?:\/\/|(?<!\S)|(?<!\W))` + regexp.QuoteMeta(redmineHost) + `\/issues\/\d+(?:\?[\w-]+(?:=[\w-]*)?(?:&[\w-]+(?:=[\w-]*)?)*)?(?:#note-\d+)?`
+
+	return findAllMatches(regexp2.MustCompile(pattern, 0), input)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestParseShortReferences(t *testing.T) {
+	instances := []*redmineInstance{
+		{URL: "https://www.redmine.org"},
+		{URL: "https://redmine.example.com", Label: "Internal"},
+	}
+
+	t.Run("Instances by label and URL", func(t *testing.T) {
+		references, err := (&configuration{ShortReferences: `[
+			{"pattern": "#(\\d+)"},
+			{"pattern": "redmine#(?<id>\\d+)", "instance": "internal"},
+			{"pattern": "PROJ-(\\d+)", "instance": "https://redmine.example.com/", "teams": ["dev"], "channels": ["town-square"]}
+		]`}).parseShortReferences(instances)
+		require.NoError(t, err)
+		require.Len(t, references, 3)
+		assert.Same(t, instances[0], references[0].instance)
+		assert.Same(t, instances[1], references[1].instance)
+		assert.Same(t, instances[1], references[2].instance)
+		assert.Equal(t, []string{"town-square"}, references[2].Channels)
+	})
+
+	for _, tc := range []struct {
+		Description     string
+		ShortReferences string
+	}{
+		{Description: "Invalid JSON", ShortReferences: `{"pattern": "#(\\d+)"}`},
+		{Description: "Missing pattern", ShortReferences: `[{"instance": "Internal"}]`},
+		{Description: "Invalid pattern", ShortReferences: `[{"pattern": "#(\\d+"}]`},
+		{Description: "No capture group", ShortReferences: `[{"pattern": "#\\d+"}]`},
+		{Description: "Unknown instance", ShortReferences: `[{"pattern": "#(\\d+)", "instance": "Elsewhere"}]`},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			_, err := (&configuration{ShortReferences: tc.ShortReferences}).parseShortReferences(instances)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMessageWillBePostedShortReferences(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.AddIssue(redmine.Issue{ID: 12, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Login fails"})
+	server.AddIssue(redmine.Issue{ID: 34, Tracker: redmine.IssueProperty{Name: "Feature"}, Subject: "Dark mode"})
+	server.AddIssue(redmine.Issue{ID: 56, Tracker: redmine.IssueProperty{Name: "Task"}, Subject: "Release"})
+
+	api := &plugintest.API{}
+	api.On("GetChannel", "dev-channel-id").Return(&model.Channel{Id: "dev-channel-id", Name: "dev", TeamId: "team-id"}, nil)
+	api.On("GetChannel", "other-channel-id").Return(&model.Channel{Id: "other-channel-id", Name: "random", TeamId: "team-id"}, nil)
+	api.On("GetTeam", "team-id").Return(&model.Team{Id: "team-id", Name: "engineering"}, nil)
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			TooltipTemplate:    "#{{.ID}}",
+			ShortReferences: `[
+				{"pattern": "#(\\d+)", "channels": ["dev"]},
+				{"pattern": "PROJ-(\\d+)", "teams": ["engineering"]}
+			]`,
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(api)
+
+	t.Run("Expanded in scope", func(t *testing.T) {
+		newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{
+			ChannelId: "dev-channel-id",
+			Message:   "Fixed #12 and PROJ-34, see https://redmine.example.com/issues/56#note-2 or [#12](https://example.com) and abc#12",
+		})
+
+		assert.Equal(t,
+			`Fixed [Bug#12: Login fails](https://redmine.example.com/issues/12 "#12") and `+
+				`[Feature#34: Dark mode](https://redmine.example.com/issues/34 "#34"), see `+
+				`[Task#56: Release#note-2](https://redmine.example.com/issues/56#note-2 "#56") or [#12](https://example.com) and abc#12`,
+			newPost.Message,
+		)
+	})
+
+	t.Run("Out of scope", func(t *testing.T) {
+		newPost, _ := plugin.MessageWillBePosted(nil, &model.Post{
+			ChannelId: "other-channel-id",
+			Message:   "Fixed #12 and PROJ-34",
+		})
+
+		assert.Equal(t, "Fixed #12 and [Feature#34: Dark mode](https://redmine.example.com/issues/34 \"#34\")", newPost.Message)
+	})
+
+	t.Run("One request per instance", func(t *testing.T) {
+		requests := len(server.Requests())
+
+		_, _ = plugin.MessageWillBePosted(nil, &model.Post{
+			ChannelId: "dev-channel-id",
+			Message:   "#12 #34 https://redmine.example.com/issues/56 #12",
+		})
+
+		assert.Equal(t, []string{"/issues.json?issue_id=12%2C34%2C56&limit=100&offset=0&status_id=%2A"}, server.Requests()[requests:])
+	})
+}
+
+func TestRemoveOverlappingReferences(t *testing.T) {
+	references := removeOverlappingReferences([]issueReference{
+		{start: 10, end: 14, text: "#123"},
+		{start: 0, end: 4, text: "PROJ"},
+		{start: 0, end: 9, text: "PROJ-1234"},
+		{start: 5, end: 9, text: "1234"},
+	})
+
+	require.Len(t, references, 2)
+	assert.Equal(t, "PROJ-1234", references[0].text)
+	assert.Equal(t, "#123", references[1].text)
+}
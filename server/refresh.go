@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// originalMessageProp keeps the message as written by the user when its links are rewritten,
+	// so that the post can be rendered again when the issues change.
+	originalMessageProp = "redmine_original_message"
+
+	issuePostsKeyPrefix = "issue_posts_"
+
+	// maxIssuePosts is the number of most recent posts refreshed per issue.
+	maxIssuePosts = 50
+)
+
+// originalMessage returns the message of the post as written by the user.
+func originalMessage(post *model.Post) string {
+	if original, ok := post.GetProp(originalMessageProp).(string); ok {
+		return original
+	}
+
+	return post.Message
+}
+
+// issuePostsKey stores the IDs of the posts referring to an issue of an instance.
+func issuePostsKey(instanceURL string, issueID int) string {
+	return issuePostsKeyPrefix + instanceKeyHash(instanceURL) + "_" + strconv.Itoa(issueID)
+}
+
+func (p *Plugin) getIssuePosts(instance *redmineInstance, issueID int) ([]string, error) {
+	var postIDs []string
+	if err := p.kvStore.Get(issuePostsKey(instance.URL, issueID), &postIDs); err != nil {
+		return nil, fmt.Errorf("failed to load issue posts: %w", err)
+	}
+
+	return postIDs, nil
+}
+
+// updateIssuePosts applies update to the posts referring to the issue and stores the result.
+func (p *Plugin) updateIssuePosts(instance *redmineInstance, issueID int, update func([]string) []string) error {
+	p.issuePostsLock.Lock()
+	defer p.issuePostsLock.Unlock()
+
+	postIDs, err := p.getIssuePosts(instance, issueID)
+	if err != nil {
+		return err
+	}
+
+	key := issuePostsKey(instance.URL, issueID)
+	postIDs = update(postIDs)
+	if len(postIDs) == 0 {
+		if err := p.kvStore.Delete(key); err != nil {
+			return fmt.Errorf("failed to delete issue posts: %w", err)
+		}
+		return nil
+	}
+	if _, err := p.kvStore.Set(key, postIDs); err != nil {
+		return fmt.Errorf("failed to save issue posts: %w", err)
+	}
+
+	return nil
+}
+
+// trackIssuePosts remembers the post for each issue it refers to, if the plugin rendered any
+// issue in it. Only the maxIssuePosts most recent posts of an issue are kept.
+func (p *Plugin) trackIssuePosts(post *model.Post) {
+	if p.kvStore == nil || post.Id == "" || !p.getConfiguration().RefreshPosts {
+		return
+	}
+	if post.GetProp(originalMessageProp) == nil && post.GetProp(issuePreviewsProp) == nil && attachmentCount(post) == 0 {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, reference := range p.findIssueReferences(originalMessage(post), p.newChannelScope(post.ChannelId)) {
+		issueID, err := strconv.Atoi(reference.issueID)
+		key := reference.instance.URL + "#" + reference.issueID
+		if err != nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		err = p.updateIssuePosts(reference.instance, issueID, func(postIDs []string) []string {
+			for _, postID := range postIDs {
+				if postID == post.Id {
+					return postIDs
+				}
+			}
+			postIDs = append(postIDs, post.Id)
+			if len(postIDs) > maxIssuePosts {
+				postIDs = postIDs[len(postIDs)-maxIssuePosts:]
+			}
+			return postIDs
+		})
+		if err != nil {
+			p.API.LogWarn("Failed to track issue post", "post_id", post.Id, "instance", reference.instance.URL, "issue_id", issueID, "err", err.Error())
+		}
+	}
+}
+
+// refreshIssuePosts renders the posts referring to the issue again, so that they show its
+// current state. Posts that were deleted are forgotten.
+func (p *Plugin) refreshIssuePosts(instance *redmineInstance, issueID int) {
+	if p.kvStore == nil || !p.getConfiguration().RefreshPosts {
+		return
+	}
+
+	postIDs, err := p.getIssuePosts(instance, issueID)
+	if err != nil {
+		p.API.LogWarn("Failed to load issue posts", "instance", instance.URL, "issue_id", issueID, "err", err.Error())
+		return
+	}
+	if len(postIDs) == 0 {
+		return
+	}
+
+	// The cached issue predates the change.
+	if p.issueCache != nil {
+		redmineURL, _ := getRedmineInstanceURL(instance.URL)
+		if err := p.issueCache.Invalidate(redmineURL, issueID); err != nil {
+			p.API.LogWarn("Failed to invalidate cached issue", "instance", instance.URL, "issue_id", issueID, "err", err.Error())
+		}
+	}
+
+	deleted := map[string]bool{}
+	for _, postID := range postIDs {
+		post, appErr := p.API.GetPost(postID)
+		if appErr != nil {
+			if appErr.StatusCode == http.StatusNotFound {
+				deleted[postID] = true
+			} else {
+				p.API.LogWarn("Failed to get post", "post_id", postID, "instance", instance.URL, "issue_id", issueID, "err", appErr.Error())
+			}
+			continue
+		}
+		if post.DeleteAt != 0 {
+			deleted[postID] = true
+			continue
+		}
+
+		p.refreshPost(post)
+	}
+
+	if len(deleted) == 0 {
+		return
+	}
+	err = p.updateIssuePosts(instance, issueID, func(postIDs []string) []string {
+		kept := postIDs[:0]
+		for _, postID := range postIDs {
+			if !deleted[postID] {
+				kept = append(kept, postID)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to forget deleted posts", "instance", instance.URL, "issue_id", issueID, "err", err.Error())
+	}
+}
+
+// refreshPost renders the post again and updates it when the rendering changed.
+func (p *Plugin) refreshPost(post *model.Post) {
+	refreshed := p.renderPost(post.Clone())
+	if refreshed.Message == post.Message && sameProps(refreshed, post) {
+		return
+	}
+
+	p.refreshingPosts.Store(refreshed.Id, refreshed.Message)
+	defer p.refreshingPosts.Delete(refreshed.Id)
+
+	if _, appErr := p.API.UpdatePost(refreshed); appErr != nil {
+		p.API.LogWarn("Failed to refresh post", "post_id", post.Id, "err", appErr.Error())
+	}
+}
+
+// isRefreshUpdate reports whether the update of the post is made by refreshPost.
+func (p *Plugin) isRefreshUpdate(post *model.Post) bool {
+	message, ok := p.refreshingPosts.Load(post.Id)
+	return ok && message == post.Message
+}
+
+// sameProps reports whether the posts have the same props, regardless of whether they were
+// set by the plugin or decoded from the database.
+func sameProps(a, b *model.Post) bool {
+	aJSON, errA := normalizedJSON(a.GetProps())
+	bJSON, errB := normalizedJSON(b.GetProps())
+	return errA == nil && errB == nil && aJSON == bJSON
+}
+
+// normalizedJSON encodes v as JSON with the keys of all objects sorted.
+func normalizedJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(decoded)
+
+	return string(data), err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestRefreshIssuePosts(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.SetAPIKey("key")
+	inProgress := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 2, Name: "In Progress"}}
+	server.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Login fails", Status: inProgress})
+	server.AddIssue(redmine.Issue{ID: 2, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Logout fails", Status: inProgress})
+
+	posts := map[string]*model.Post{}
+	api := &plugintest.API{}
+	api.On("GetPost", mock.AnythingOfType("string")).Return(func(postID string) (*model.Post, *model.AppError) {
+		if post, ok := posts[postID]; ok {
+			return post.Clone(), nil
+		}
+		return nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound)
+	})
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL:   "https://redmine.example.com",
+			RedmineAPIKey:        "key",
+			AllowGlobalAPIKey:    true,
+			IssueCacheTTLMinutes: 5,
+			TooltipTemplate:      "{{.Status.Name}}",
+			RefreshPosts:         true,
+		},
+		httpClient: server.HTTPClient(),
+		kvStore:    &pluginapi.MemoryStore{},
+		issueCache: newIssueCache(&pluginapi.MemoryStore{}, issueCacheCapacity, 5*time.Minute),
+	}
+	plugin.SetAPI(api)
+	instance := plugin.getConfiguration().getInstances()[0]
+
+	var updates []*model.Post
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		// The server runs the update hook of the plugin, which must keep the refreshed post.
+		updated, _ := plugin.MessageWillBeUpdated(nil, post, posts[post.Id])
+		updates = append(updates, updated)
+		posts[post.Id] = updated
+		return updated, nil
+	})
+
+	create := func(id, message string) {
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{UserId: "user-id", ChannelId: "channel-id", Message: message})
+		post.Id = id
+		posts[id] = post
+		plugin.MessageHasBeenPosted(nil, post)
+	}
+
+	create("post-1", "See https://redmine.example.com/issues/1")
+	create("post-2", "https://redmine.example.com/issues/1 and https://redmine.example.com/issues/2")
+	create("post-3", "No issue here")
+	assert.Equal(t, `See [Bug#1: Login fails](https://redmine.example.com/issues/1 "In Progress")`, posts["post-1"].Message)
+	assert.Equal(t, "See https://redmine.example.com/issues/1", posts["post-1"].GetProp(originalMessageProp))
+	assert.Nil(t, posts["post-3"].GetProp(originalMessageProp))
+
+	postIDs, err := plugin.getIssuePosts(instance, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"post-1", "post-2"}, postIDs)
+
+	t.Run("Changed issue", func(t *testing.T) {
+		updates = nil
+		server.AddIssue(redmine.Issue{ID: 1, Tracker: redmine.IssueProperty{Name: "Bug"}, Subject: "Login fails on Safari",
+			Status: redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true}})
+
+		plugin.refreshIssuePosts(instance, 1)
+		require.Len(t, updates, 2)
+		assert.Equal(t, `See [Bug#1: Login fails on Safari](https://redmine.example.com/issues/1 "Closed")`, posts["post-1"].Message)
+		assert.Equal(t, "See https://redmine.example.com/issues/1", posts["post-1"].GetProp(originalMessageProp))
+		assert.Equal(t, `[Bug#1: Login fails on Safari](https://redmine.example.com/issues/1 "Closed") and `+
+			`[Bug#2: Logout fails](https://redmine.example.com/issues/2 "In Progress")`, posts["post-2"].Message)
+	})
+
+	t.Run("Unchanged issue", func(t *testing.T) {
+		updates = nil
+		plugin.refreshIssuePosts(instance, 2)
+		assert.Empty(t, updates)
+	})
+
+	t.Run("Edited message", func(t *testing.T) {
+		old := posts["post-1"]
+		edited := old.Clone()
+		edited.Message = "Fixed, see https://redmine.example.com/issues/2"
+
+		updated, _ := plugin.MessageWillBeUpdated(nil, edited, old)
+		assert.Equal(t, `Fixed, see [Bug#2: Logout fails](https://redmine.example.com/issues/2 "In Progress")`, updated.Message)
+		assert.Equal(t, "Fixed, see https://redmine.example.com/issues/2", updated.GetProp(originalMessageProp))
+
+		posts["post-1"] = updated
+		plugin.MessageHasBeenUpdated(nil, updated, old)
+		postIDs, err := plugin.getIssuePosts(instance, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"post-2", "post-1"}, postIDs)
+	})
+
+	t.Run("Deleted posts are forgotten", func(t *testing.T) {
+		updates = nil
+		delete(posts, "post-2")
+		posts["post-1"].DeleteAt = 1
+
+		plugin.refreshIssuePosts(instance, 2)
+		assert.Empty(t, updates)
+		postIDs, err := plugin.getIssuePosts(instance, 2)
+		require.NoError(t, err)
+		assert.Empty(t, postIDs)
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	defaultLinkTextTemplate = `{{.Tracker.Name}}#{{.ID}}: {{.Subject}}{{.Anchor}}`
+
+	defaultTooltipTemplate = `{{with .Instance}}Instance: {{.}}
+{{end}}Assignee: {{or .AssignedTo.Name "Unassigned"}}
+Priority: {{.Priority.Name}}
+Status: {{.Status.Name}}
+Author: {{.Author.Name}}
+Last update: {{.Date .UpdatedOn}}{{with .Note}}
+Note #{{.Number}} by {{.Author}}, {{$.Date .CreatedOn}}{{with .Notes}}:
+{{truncate 200 .}}{{end}}{{range .Changes}}
+{{.}}{{end}}{{end}}`
+
+	// tooltipLineSeparator is a carriage return entity, which browsers render as a line break
+	// in the title attribute of the link.
+	tooltipLineSeparator = "&#013;"
+)
+
+// linkData is the value link templates are executed against. All fields of the issue are
+// available directly, e.g. {{.Project.Name}}, {{.FixedVersion.Name}} or {{.DoneRatio}}.
+type linkData struct {
+	redmine.Issue
+
+	// Instance is the label of the Redmine instance the issue belongs to.
+	Instance string
+	// URL is the link as it was written in the message.
+	URL string
+	// Anchor is the fragment of the link including the hash, e.g. #note-4.
+	Anchor string
+	// Note is the journal linked by a #note-N anchor, nil for other links or when the note
+	// cannot be shown.
+	Note *linkNote
+
+	dates dateFormatter
+}
+
+// Date renders an API timestamp such as .UpdatedOn with the configured timezone and layout.
+func (d linkData) Date(value string) string {
+	return d.dates.format(value)
+}
+
+var linkTemplateFuncs = template.FuncMap{
+	// hours renders optional hour fields such as .EstimatedHours.
+	"hours": func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'f', -1, 64)
+	},
+	// truncate shortens text to at most n runes, adding an ellipsis when cut.
+	"truncate": truncate,
+}
+
+func truncate(n int, text string) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	if n <= 1 {
+		return string(runes[:n])
+	}
+
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// linkTemplates renders transformed issue links.
+type linkTemplates struct {
+	text    *template.Template
+	tooltip *template.Template
+}
+
+var defaultLinkTemplates = mustParseLinkTemplates(defaultLinkTextTemplate, defaultTooltipTemplate)
+
+// parseLinkTemplates parses the link text and tooltip templates, using the defaults for empty
+// ones. Both templates are executed against a sample issue, linked with and without a note, so
+// that references to unknown fields are reported right away instead of when a message is posted.
+func parseLinkTemplates(text, tooltip string) (*linkTemplates, error) {
+	if strings.TrimSpace(text) == "" {
+		text = defaultLinkTextTemplate
+	}
+	if strings.TrimSpace(tooltip) == "" {
+		tooltip = defaultTooltipTemplate
+	}
+
+	textTemplate, err := template.New("link").Funcs(linkTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse link text template")
+	}
+	tooltipTemplate, err := template.New("tooltip").Funcs(linkTemplateFuncs).Parse(tooltip)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse tooltip template")
+	}
+
+	templates := &linkTemplates{text: textTemplate, tooltip: tooltipTemplate}
+	sample := sampleLinkData()
+	if _, _, err := templates.render(sample); err != nil {
+		return nil, err
+	}
+	sample.Anchor = "#note-1"
+	sample.Note = &linkNote{
+		Number:    1,
+		Author:    "Redmine Admin",
+		CreatedOn: "2024-05-02T10:00:00Z",
+		Notes:     "Sample note",
+		Changes:   []journalChange{{Label: "Status", Value: "New"}},
+	}
+	if _, _, err := templates.render(sample); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+func mustParseLinkTemplates(text, tooltip string) *linkTemplates {
+	templates, err := parseLinkTemplates(text, tooltip)
+	if err != nil {
+		panic(err)
+	}
+
+	return templates
+}
+
+func sampleLinkData() linkData {
+	dueDate := "2024-05-31"
+	estimatedHours := 4.5
+
+	return linkData{
+		Issue: redmine.Issue{
+			ID:             1,
+			Project:        redmine.IssueProperty{ID: 1, Name: "Redmine"},
+			Tracker:        redmine.IssueProperty{ID: 1, Name: "Defect"},
+			Status:         redmine.Status{IssueProperty: redmine.IssueProperty{ID: 1, Name: "New"}},
+			Priority:       redmine.IssueProperty{ID: 2, Name: "Normal"},
+			Author:         redmine.IssueProperty{ID: 1, Name: "Redmine Admin"},
+			FixedVersion:   redmine.IssueProperty{ID: 1, Name: "1.0"},
+			Subject:        "Sample issue",
+			DueDate:        &dueDate,
+			DoneRatio:      50,
+			EstimatedHours: &estimatedHours,
+			CreatedOn:      "2024-05-01T10:00:00Z",
+			UpdatedOn:      "2024-05-02T10:00:00Z",
+		},
+		Instance: "Redmine",
+		URL:      "https://www.redmine.org/issues/1",
+		dates:    defaultDateFormatter,
+	}
+}
+
+// render executes both templates. Line breaks in the tooltip become tooltipLineSeparator.
+func (t *linkTemplates) render(data linkData) (string, string, error) {
+	var text, tooltip strings.Builder
+	if err := t.text.Execute(&text, data); err != nil {
+		return "", "", errors.Wrap(err, "failed to render link text template")
+	}
+	if err := t.tooltip.Execute(&tooltip, data); err != nil {
+		return "", "", errors.Wrap(err, "failed to render tooltip template")
+	}
+
+	lines := strings.Split(strings.TrimSpace(tooltip.String()), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.TrimSpace(text.String()), strings.Join(lines, tooltipLineSeparator), nil
+}
+
+// createTransformedLink renders a markdown link with the given text and tooltip.
+func createTransformedLink(text, url, tooltip string) string {
+	return fmt.Sprintf("[%s](%s %q)", text, url, tooltip)
+}
+
+// getLinkTemplates returns the configured templates, falling back to the defaults.
+func (c *configuration) getLinkTemplates() *linkTemplates {
+	if c.linkTemplates != nil {
+		return c.linkTemplates
+	}
+	if c.LinkTextTemplate == "" && c.TooltipTemplate == "" {
+		return defaultLinkTemplates
+	}
+
+	templates, err := parseLinkTemplates(c.LinkTextTemplate, c.TooltipTemplate)
+	if err != nil {
+		return defaultLinkTemplates
+	}
+
+	return templates
+}
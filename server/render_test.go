@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+func TestDefaultLinkTemplates(t *testing.T) {
+	assert.Equal(t,
+		`[Feature#40556: Focus on the textarea after clicking the Edit Journal button#note-2](https://www.redmine.org/issues/40556#note-2 "Assignee: Marius BĂLTEANU&#013;Priority: Normal&#013;Status: Closed&#013;Author: Yasu Saku&#013;Last update: Mon, 29 Apr 2024 19:23:49 UTC")`,
+		expectedLink("https://www.redmine.org/issues/40556#note-2", "#note-2", issue40556),
+	)
+	assert.Contains(t,
+		expectedLink("https://www.redmine.org/issues/40538", "", issue40538),
+		`"Assignee: Unassigned&#013;`,
+	)
+}
+
+func TestParseLinkTemplates(t *testing.T) {
+	dueDate := "2024-06-01"
+	estimatedHours := 2.5
+	data := linkData{
+		Issue: redmine.Issue{
+			ID:             42,
+			Project:        redmine.IssueProperty{Name: "Website"},
+			Tracker:        redmine.IssueProperty{Name: "Bug"},
+			Status:         redmine.Status{IssueProperty: redmine.IssueProperty{Name: "In Progress"}},
+			Priority:       redmine.IssueProperty{Name: "High"},
+			Author:         redmine.IssueProperty{Name: "Jane Doe"},
+			Subject:        "Broken login page on mobile devices",
+			DueDate:        &dueDate,
+			DoneRatio:      30,
+			EstimatedHours: &estimatedHours,
+			UpdatedOn:      "2024-05-20T08:15:00Z",
+		},
+		Instance: "Internal",
+		URL:      "https://redmine.example.com/issues/42",
+		dates:    defaultDateFormatter,
+	}
+
+	for _, tc := range []struct {
+		Description     string
+		Text            string
+		Tooltip         string
+		ExpectedText    string
+		ExpectedTooltip string
+	}{
+		{
+			Description:     "Custom fields and functions",
+			Text:            `[{{.Project.Name}}] #{{.ID}} {{truncate 13 .Subject}}`,
+			Tooltip:         "Done: {{.DoneRatio}}%\n  Due: {{.DueDate}}\nEstimated: {{hours .EstimatedHours}}h\n",
+			ExpectedText:    "[Website] #42 Broken login…",
+			ExpectedTooltip: "Done: 30%&#013;Due: 2024-06-01&#013;Estimated: 2.5h",
+		},
+		{
+			Description:     "Empty templates use the defaults",
+			ExpectedText:    "Bug#42: Broken login page on mobile devices",
+			ExpectedTooltip: "Instance: Internal&#013;Assignee: Unassigned&#013;Priority: High&#013;Status: In Progress&#013;Author: Jane Doe&#013;Last update: Mon, 20 May 2024 08:15:00 UTC",
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			templates, err := parseLinkTemplates(tc.Text, tc.Tooltip)
+			require.NoError(t, err)
+
+			text, tooltip, err := templates.render(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedText, text)
+			assert.Equal(t, tc.ExpectedTooltip, tooltip)
+		})
+	}
+
+	t.Run("Syntax error", func(t *testing.T) {
+		_, err := parseLinkTemplates(`{{.Subject`, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown field", func(t *testing.T) {
+		_, err := parseLinkTemplates("", `{{.Milestone}}`)
+		assert.Error(t, err)
+	})
+}
+
+func TestGetLinkTemplatesFallsBackToDefaults(t *testing.T) {
+	config := &configuration{LinkTextTemplate: `{{.Subject | nosuchfunc}}`}
+	assert.Same(t, defaultLinkTemplates, config.getLinkTemplates())
+}
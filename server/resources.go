@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	// maxResourceDescriptionLength is the number of characters of descriptions and comments
+	// shown in the tooltips of resource links.
+	maxResourceDescriptionLength = 200
+	// maxResourceRequests caps the Redmine requests made to render the resource links of one
+	// message, so that pasting many links does not stall posting. Links past the cap are left
+	// as written.
+	maxResourceRequests = 10
+)
+
+// resourceLink is the text and the tooltip lines of a rendered resource link.
+type resourceLink struct {
+	text    string
+	tooltip []string
+}
+
+// resourceOptions holds how the links of a message are rendered for its viewer.
+type resourceOptions struct {
+	dates dateFormatter
+	// hidePrivate leaves private issues out of rendered links.
+	hidePrivate bool
+}
+
+// resourceHandler recognises the links to one type of Redmine resource other than issues, and
+// renders them from the resource fetched with the API.
+type resourceHandler struct {
+	// kind names the resource type in logs.
+	kind string
+	// requests is the number of Redmine requests fetch makes at most.
+	requests int
+	// cacheTTL, when set, shortens the time the links are cached for resources that change
+	// more often than the Issue Cache TTL allows.
+	cacheTTL time.Duration
+	// path matches the path of a link after the URL of the instance, e.g. versions/12. Its
+	// submatches are passed to fetch.
+	path string
+	// fetch gets the resource of a link and renders it.
+	fetch func(ctx context.Context, client *redmine.Client, match []string, options resourceOptions) (*resourceLink, error)
+}
+
+// resourceHandlers lists the resources whose links are expanded in addition to issues. Redmine
+// does not expose documents through its REST API, so links to documents are left as written.
+var resourceHandlers = []*resourceHandler{
+	{kind: "project", requests: 1, path: `projects/([\w-]+)`, fetch: fetchProjectLink},
+	{kind: "version", requests: 3, path: `versions/(\d+)`, fetch: fetchVersionLink},
+	{kind: "wiki page", requests: 1, path: `projects/([\w-]+)/wiki/([\w%.:-]*[\w%-])`, fetch: fetchWikiPageLink},
+	{kind: "time entry", requests: 1, path: `time_entries/(\d+)`, fetch: fetchTimeEntryLink},
+	{kind: "issue query", requests: maxQueryPages + 1, cacheTTL: queryCacheTTL, path: `(?:projects/([\w-]+)/)?issues\?([^\s<>#]*[\w\]%=])`, fetch: fetchIssueQueryLink},
+}
+
+// resourceReference is a link to a resource other than an issue in a message.
+type resourceReference struct {
+	// start and end are the byte offsets of the link in the message.
+	start, end int
+	// text is the link as written in the message.
+	text string
+	// match holds the submatches of the path of the handler.
+	match []string
+
+	handler  *resourceHandler
+	instance *redmineInstance
+}
+
+// extractResourceLinks finds the links of the instance whose URL, without the scheme, is
+// redmineHost to the resources of the handler. Links continuing with another path segment, such
+// as projects/foo/settings for the project handler, are not matched.
+func extractResourceLinks(input, redmineHost string, handler *resourceHandler) []textMatch {
+	pattern := `(?:https?:\/\/|(?<!\S))` + regexp.QuoteMeta(redmineHost) + `\/` + handler.path + `(?:#[\w-]+)?(?![\w\/%-]|\?\w)`
+
+	return findAllMatches(regexp2.MustCompile(pattern, 0), input)
+}
+
+// findResourceReferences returns the resource links in the plain text of a message, in order
+// of appearance.
+func (p *Plugin) findResourceReferences(message string, scope *channelScope) []resourceReference {
+	var references []resourceReference
+
+	for _, instance := range p.getInstancesForScope(scope) {
+		_, redmineHost := getRedmineInstanceURL(instance.URL)
+		if redmineHost == "" {
+			continue
+		}
+
+		for _, handler := range resourceHandlers {
+			for _, link := range extractResourceLinks(message, redmineHost, handler) {
+				match := []string{}
+				for _, group := range link.match.Groups()[1:] {
+					match = append(match, group.String())
+				}
+
+				references = append(references, resourceReference{
+					start:    link.start,
+					end:      link.end,
+					text:     link.text,
+					match:    match,
+					handler:  handler,
+					instance: instance,
+				})
+			}
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	spans := plainTextSpans(message)
+	kept := references[:0]
+	for _, reference := range references {
+		if inPlainText(spans, reference.start, reference.end) {
+			kept = append(kept, reference)
+		}
+	}
+
+	return kept
+}
+
+// renderResourceReferences renders the links to resources the viewer may see. Links whose
+// resource cannot be fetched, and links past maxResourceRequests, are left out. Links fetched
+// with the API key of the instance are cached like issues.
+func (p *Plugin) renderResourceReferences(references []resourceReference, viewer issueViewer, dates dateFormatter) []linkReplacement {
+	var replacements []linkReplacement
+	clients := make(map[string]*userClient)
+	budget := maxResourceRequests
+
+	for _, reference := range references {
+		client, ok := clients[reference.instance.URL]
+		if !ok {
+			var err error
+			client, err = p.getViewerClient(reference.instance, viewer)
+			if err != nil {
+				p.API.LogWarn("Failed to create Redmine client", "instance", reference.instance.URL, "err", err.Error())
+			}
+			clients[reference.instance.URL] = client
+		}
+		if client == nil {
+			// Without credentials allowed for the viewer, keep the link
+			continue
+		}
+
+		options := resourceOptions{dates: dates, hidePrivate: viewer.hidePrivate}
+		cacheKey := resourceCacheKey(reference, options)
+		link, ok := p.getCachedResource(client, reference.handler, cacheKey)
+		if !ok {
+			if reference.handler.requests > budget {
+				p.API.LogDebug("Too many resource links, leaving the others as written", "instance", reference.instance.URL, "link", reference.text)
+				continue
+			}
+			budget -= reference.handler.requests
+
+			fetched, err := reference.handler.fetch(context.Background(), client.Client, reference.match, options)
+			if err != nil {
+				if !errors.Is(err, redmine.ErrNotFound) && !errors.Is(err, redmine.ErrForbidden) {
+					p.API.LogWarn("Failed to fetch Redmine resource", "instance", reference.instance.URL, "resource", reference.handler.kind, "link", reference.text, "err", err.Error())
+				}
+				continue
+			}
+			link = *fetched
+			p.cacheResource(client, cacheKey, link)
+		}
+
+		tooltip := link.tooltip
+		if reference.instance.Label != "" {
+			tooltip = append([]string{"Instance: " + reference.instance.Label}, tooltip...)
+		}
+		replacements = append(replacements, linkReplacement{
+			start: reference.start,
+			end:   reference.end,
+			link:  createTransformedLink(link.text, reference.text, strings.Join(tooltip, tooltipLineSeparator)),
+		})
+	}
+
+	return replacements
+}
+
+// resourceCacheKey identifies the rendering of a resource link with the given options.
+func resourceCacheKey(reference resourceReference, options resourceOptions) string {
+	return strings.Join([]string{
+		reference.instance.URL,
+		reference.handler.kind,
+		strings.Join(reference.match, "/"),
+		strconv.FormatBool(options.hidePrivate),
+		options.dates.location.String(),
+		options.dates.layout,
+		strconv.FormatBool(options.dates.relative),
+	}, "\n")
+}
+
+// resourceCacheTTL returns how long the links of the handler are cached. Zero disables the
+// cache.
+func (p *Plugin) resourceCacheTTL(handler *resourceHandler) time.Duration {
+	ttl := p.getConfiguration().issueCacheTTL()
+	if handler.cacheTTL > 0 {
+		ttl = min(ttl, handler.cacheTTL)
+	}
+
+	return ttl
+}
+
+// getCachedResource returns the cached link of a resource. Like issues, only the links fetched
+// with the API key of the instance are cached, as they do not depend on the user.
+func (p *Plugin) getCachedResource(client *userClient, handler *resourceHandler, key string) (resourceLink, bool) {
+	ttl := p.resourceCacheTTL(handler)
+	if p.resourceCache == nil || !client.shared || ttl <= 0 {
+		return resourceLink{}, false
+	}
+
+	return p.resourceCache.Get(key, ttl)
+}
+
+func (p *Plugin) cacheResource(client *userClient, key string, link resourceLink) {
+	if p.resourceCache == nil || !client.shared || p.getConfiguration().issueCacheTTL() <= 0 {
+		return
+	}
+
+	p.resourceCache.Set(key, link)
+}
+
+func fetchProjectLink(ctx context.Context, client *redmine.Client, match []string, options resourceOptions) (*resourceLink, error) {
+	project, err := client.GetProject(ctx, match[0])
+	if err != nil {
+		return nil, err
+	}
+
+	tooltip := []string{"Identifier: " + project.Identifier}
+	if description := excerpt(project.Description); description != "" {
+		tooltip = append(tooltip, description)
+	}
+	if project.CreatedOn != "" {
+		tooltip = append(tooltip, "Created: "+options.dates.format(project.CreatedOn))
+	}
+
+	return &resourceLink{text: "Project: " + project.Name, tooltip: tooltip}, nil
+}
+
+// fetchVersionLink renders a version with its due date and the share of its issues that are
+// closed.
+func fetchVersionLink(ctx context.Context, client *redmine.Client, match []string, _ resourceOptions) (*resourceLink, error) {
+	id, _ := strconv.Atoi(match[0])
+	version, err := client.GetVersion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	countIssues := func(status string) (int, error) {
+		resp, err := client.ListIssues(ctx, url.Values{
+			"fixed_version_id": {match[0]},
+			"status_id":        {status},
+			"limit":            {"1"},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.TotalCount, nil
+	}
+	total, err := countIssues("*")
+	if err != nil {
+		return nil, err
+	}
+	closed, err := countIssues("c")
+	if err != nil {
+		return nil, err
+	}
+
+	var details []string
+	if version.DueDate != nil && *version.DueDate != "" {
+		details = append(details, "due "+*version.DueDate)
+	}
+	if total > 0 {
+		details = append(details, fmt.Sprintf("%d%% closed", closed*100/total))
+	}
+	text := "Version " + version.Name
+	if len(details) > 0 {
+		text += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	tooltip := []string{
+		"Project: " + version.Project.Name,
+		"Status: " + version.Status,
+		fmt.Sprintf("Issues: %d of %d closed", closed, total),
+	}
+	if version.SpentHours > 0 {
+		tooltip = append(tooltip, "Spent time: "+formatHours(version.SpentHours))
+	}
+
+	return &resourceLink{text: text, tooltip: tooltip}, nil
+}
+
+func fetchWikiPageLink(ctx context.Context, client *redmine.Client, match []string, options resourceOptions) (*resourceLink, error) {
+	title, err := url.PathUnescape(match[1])
+	if err != nil {
+		title = match[1]
+	}
+	page, err := client.GetWikiPage(ctx, match[0], title)
+	if err != nil {
+		return nil, err
+	}
+
+	tooltip := []string{
+		"Project: " + match[0],
+		fmt.Sprintf("Version %d by %s, %s", page.Version, page.Author.Name, options.dates.format(page.UpdatedOn)),
+	}
+	if comments := excerpt(page.Comments); comments != "" {
+		tooltip = append(tooltip, comments)
+	}
+
+	return &resourceLink{text: "Wiki: " + strings.ReplaceAll(page.Title, "_", " "), tooltip: tooltip}, nil
+}
+
+func fetchTimeEntryLink(ctx context.Context, client *redmine.Client, match []string, _ resourceOptions) (*resourceLink, error) {
+	id, _ := strconv.Atoi(match[0])
+	entry, err := client.GetTimeEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Time entry: %s by %s on %s", formatHours(entry.Hours), entry.User.Name, entry.SpentOn)
+	tooltip := []string{"Project: " + entry.Project.Name}
+	if entry.Issue != nil {
+		tooltip = append(tooltip, fmt.Sprintf("Issue: #%d", entry.Issue.ID))
+	}
+	if entry.Activity.Name != "" {
+		tooltip = append(tooltip, "Activity: "+entry.Activity.Name)
+	}
+	if comments := excerpt(entry.Comments); comments != "" {
+		tooltip = append(tooltip, comments)
+	}
+
+	return &resourceLink{text: text, tooltip: tooltip}, nil
+}
+
+// excerpt shortens a description or comment to a single line of a tooltip.
+func excerpt(text string) string {
+	return truncate(maxResourceDescriptionLength, strings.Join(strings.Fields(text), " "))
+}
+
+// formatHours renders a number of hours such as 1.5 as 1.5 h.
+func formatHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', -1, 64) + " h"
+}
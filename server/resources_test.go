@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestResourceLinks(t *testing.T) {
+	server := redminetest.NewServer(t)
+	dueDate := "2024-06-30"
+	closed := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true}
+	server.AddProject(redmine.Project{ID: 2, Name: "Website", Identifier: "website", Description: "The public\nwebsite", CreatedOn: "2024-01-01T10:00:00Z"})
+	server.AddVersion(redmine.Version{ID: 3, Project: redmine.IssueProperty{ID: 2, Name: "Website"}, Name: "2.0", Status: "open", DueDate: &dueDate, SpentHours: 12.5})
+	server.AddIssue(redmine.Issue{ID: 1, Subject: "Login fails", FixedVersion: redmine.IssueProperty{ID: 3}, Status: closed})
+	server.AddIssue(redmine.Issue{ID: 2, Subject: "Dark mode", FixedVersion: redmine.IssueProperty{ID: 3}})
+	server.AddIssue(redmine.Issue{ID: 3, Subject: "Search", FixedVersion: redmine.IssueProperty{ID: 3}})
+	server.AddWikiPage("website", redmine.WikiPage{Title: "Release_notes", Version: 4, Author: redmine.IssueProperty{ID: 5, Name: "Jane Doe"}, UpdatedOn: "2024-06-01T10:00:00Z"})
+	server.AddTimeEntry(redmine.TimeEntry{ID: 99, Project: redmine.IssueProperty{ID: 2, Name: "Website"}, Issue: &redmine.Parent{ID: 1},
+		User: redmine.IssueProperty{ID: 5, Name: "Jane Doe"}, Activity: redmine.IssueProperty{ID: 9, Name: "Development"}, Hours: 1.5, SpentOn: "2024-06-01"})
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+		},
+		httpClient: server.HTTPClient(),
+	}
+	plugin.SetAPI(&plugintest.API{})
+
+	for _, tc := range []struct {
+		Description string
+		Message     string
+		Expected    string
+	}{
+		{
+			Description: "Project",
+			Message:     "See https://redmine.example.com/projects/website",
+			Expected:    `See [Project: Website](https://redmine.example.com/projects/website "Identifier: website&#013;The public website&#013;Created: Mon, 01 Jan 2024 10:00:00 UTC")`,
+		},
+		{
+			Description: "Version",
+			Message:     "Planned for https://redmine.example.com/versions/3.",
+			Expected:    `Planned for [Version 2.0 (due 2024-06-30, 33% closed)](https://redmine.example.com/versions/3 "Project: Website&#013;Status: open&#013;Issues: 1 of 3 closed&#013;Spent time: 12.5 h").`,
+		},
+		{
+			Description: "Wiki page",
+			Message:     "https://redmine.example.com/projects/website/wiki/Release_notes",
+			Expected:    `[Wiki: Release notes](https://redmine.example.com/projects/website/wiki/Release_notes "Project: website&#013;Version 4 by Jane Doe, Sat, 01 Jun 2024 10:00:00 UTC")`,
+		},
+		{
+			Description: "Time entry",
+			Message:     "https://redmine.example.com/time_entries/99",
+			Expected:    `[Time entry: 1.5 h by Jane Doe on 2024-06-01](https://redmine.example.com/time_entries/99 "Project: Website&#013;Issue: #1&#013;Activity: Development")`,
+		},
+		{
+			Description: "Unknown resources and other pages",
+			Message:     "https://redmine.example.com/versions/404 https://redmine.example.com/projects/website/settings https://redmine.example.com/documents/5",
+			Expected:    "https://redmine.example.com/versions/404 https://redmine.example.com/projects/website/settings https://redmine.example.com/documents/5",
+		},
+		{
+			Description: "Code",
+			Message:     "`https://redmine.example.com/projects/website`",
+			Expected:    "`https://redmine.example.com/projects/website`",
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: tc.Message})
+			assert.Equal(t, tc.Expected, post.Message)
+		})
+	}
+
+	t.Run("Link display modes", func(t *testing.T) {
+		defer func() { plugin.configuration.LinkDisplayMode = "" }()
+		message := "See https://redmine.example.com/projects/website and https://redmine.example.com/issues/1"
+		projectLink := `[Project: Website](https://redmine.example.com/projects/website "Identifier: website&#013;The public website&#013;Created: Mon, 01 Jan 2024 10:00:00 UTC")`
+
+		plugin.configuration.LinkDisplayMode = linkDisplayAttachments
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+		assert.Equal(t, "See "+projectLink+" and https://redmine.example.com/issues/1", post.Message)
+
+		plugin.configuration.LinkDisplayMode = linkDisplayPreview
+		post, _ = plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+		assert.Equal(t, message, post.Message)
+		previews, ok := post.GetProp(issuePreviewsProp).([]issuePreview)
+		if assert.True(t, ok) {
+			assert.Contains(t, previews, issuePreview{Start: 4, End: 48, Text: "https://redmine.example.com/projects/website", Link: projectLink})
+		}
+	})
+
+	t.Run("Request budget", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("LogDebug", "Too many resource links, leaving the others as written", "instance", "https://redmine.example.com", "link", "https://redmine.example.com/versions/3").Return()
+		plugin.SetAPI(api)
+		defer plugin.SetAPI(&plugintest.API{})
+		before := len(server.Requests())
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/versions/3 https://redmine.example.com/versions/3 " +
+			"https://redmine.example.com/versions/3 https://redmine.example.com/versions/3 https://redmine.example.com/projects/website"})
+		assert.Equal(t, 3, strings.Count(post.Message, "[Version 2.0"))
+		assert.Contains(t, post.Message, " https://redmine.example.com/versions/3 [Project: Website]")
+		assert.Len(t, server.Requests(), before+maxResourceRequests)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		plugin.configuration.IssueCacheTTLMinutes = 5
+		plugin.resourceCache = newResourceCache(resourceCacheCapacity)
+		defer func() {
+			plugin.configuration.IssueCacheTTLMinutes = 0
+			plugin.resourceCache = nil
+		}()
+		before := len(server.Requests())
+
+		for i := 0; i < 2; i++ {
+			post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/versions/3"})
+			assert.Contains(t, post.Message, "[Version 2.0 (due 2024-06-30, 33% closed)]")
+		}
+		assert.Len(t, server.Requests(), before+3)
+	})
+}
+
+func TestIssueQueryLinks(t *testing.T) {
+	server := redminetest.NewServer(t)
+	server.SetAPIKey("key")
+	bug := redmine.IssueProperty{ID: 1, Name: "Bug"}
+	newStatus := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 1, Name: "New"}}
+	inProgress := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 2, Name: "In Progress"}}
+	closed := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true}
+	website := redmine.IssueProperty{ID: 2, Name: "Website"}
+	server.AddIssue(redmine.Issue{ID: 1, Project: website, Tracker: bug, Subject: "Login fails", Status: inProgress, UpdatedOn: "2024-06-01T10:00:00Z"})
+	server.AddIssue(redmine.Issue{ID: 2, Project: website, Tracker: bug, Subject: "Dark mode", Status: newStatus, UpdatedOn: "2024-06-02T10:00:00Z"})
+	server.AddIssue(redmine.Issue{ID: 3, Project: website, Tracker: bug, Subject: "Search", Status: newStatus, UpdatedOn: "2024-06-03T10:00:00Z", IsPrivate: true})
+	server.AddIssue(redmine.Issue{ID: 4, Project: redmine.IssueProperty{ID: 3, Name: "Intranet"}, Tracker: bug, Subject: "Wiki", Status: closed})
+	server.AddProject(redmine.Project{ID: 2, Name: "Website", Identifier: "website"})
+	server.AddQuery(redmine.Query{ID: 42, Name: "Open bugs", IsPublic: true}, url.Values{"status_id": {"o"}})
+
+	newPlugin := func() *Plugin {
+		plugin := &Plugin{
+			configuration: &configuration{
+				RedmineInstanceURL: "https://redmine.example.com",
+				RedmineAPIKey:      "key",
+				AllowGlobalAPIKey:  true,
+			},
+			httpClient: server.HTTPClient(),
+		}
+		plugin.SetAPI(&plugintest.API{})
+		return plugin
+	}
+
+	for _, tc := range []struct {
+		Description string
+		Message     string
+		Expected    string
+	}{
+		{
+			Description: "Saved query",
+			Message:     "Stand-up: https://redmine.example.com/issues?query_id=42",
+			Expected: `Stand-up: [Open bugs: 3 issues (2 New, 1 In Progress)](https://redmine.example.com/issues?query_id=42 ` +
+				`"#1 Bug: Login fails (In Progress)&#013;#2 Bug: Dark mode (New)&#013;#3 Bug: Search (New)")`,
+		},
+		{
+			Description: "Filters of a project",
+			Message:     "https://redmine.example.com/projects/website/issues?set_filter=1&status_id=o&sort=updated_on:desc&per_page=1",
+			Expected: `[Issues: 3 issues (2 New, 1 In Progress)](https://redmine.example.com/projects/website/issues?set_filter=1&status_id=o&sort=updated_on:desc&per_page=1 ` +
+				`"Project: website&#013;#3 Bug: Search (New)&#013;#2 Bug: Dark mode (New)&#013;#1 Bug: Login fails (In Progress)")`,
+		},
+		{
+			Description: "Closed issues",
+			Message:     "https://redmine.example.com/issues?status_id=c",
+			Expected:    `[Issues: 1 issue (1 Closed)](https://redmine.example.com/issues?status_id=c "#4 Bug: Wiki (Closed)")`,
+		},
+		{
+			Description: "Unknown query and code",
+			Message:     "https://redmine.example.com/issues?query_id=7 `https://redmine.example.com/issues?query_id=42`",
+			Expected:    "https://redmine.example.com/issues?query_id=7 `https://redmine.example.com/issues?query_id=42`",
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			post, _ := newPlugin().MessageWillBePosted(nil, &model.Post{Message: tc.Message})
+			assert.Equal(t, tc.Expected, post.Message)
+		})
+	}
+
+	t.Run("Private issues hidden", func(t *testing.T) {
+		plugin := newPlugin()
+		plugin.configuration.IssueDetailPolicy = issueDetailsHidePrivate
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues?query_id=42"})
+		assert.Equal(t, `[Open bugs: 2 issues (1 In Progress, 1 New)](https://redmine.example.com/issues?query_id=42 `+
+			`"#1 Bug: Login fails (In Progress)&#013;#2 Bug: Dark mode (New)")`, post.Message)
+	})
+
+	t.Run("Request budget and cache", func(t *testing.T) {
+		plugin := newPlugin()
+		api := &plugintest.API{}
+		api.On("LogDebug", "Too many resource links, leaving the others as written", "instance", "https://redmine.example.com", "link", "https://redmine.example.com/issues?status_id=c").Return()
+		plugin.SetAPI(api)
+		plugin.configuration.IssueCacheTTLMinutes = 5
+		plugin.resourceCache = newResourceCache(resourceCacheCapacity)
+		message := "https://redmine.example.com/issues?query_id=42 https://redmine.example.com/issues?status_id=c"
+
+		before := len(server.Requests())
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+		assert.Equal(t, `[Open bugs: 3 issues (2 New, 1 In Progress)](https://redmine.example.com/issues?query_id=42 `+
+			`"#1 Bug: Login fails (In Progress)&#013;#2 Bug: Dark mode (New)&#013;#3 Bug: Search (New)") https://redmine.example.com/issues?status_id=c`, post.Message)
+		assert.Len(t, server.Requests(), before+2)
+
+		// The first link is cached, leaving the budget to the second one
+		post, _ = plugin.MessageWillBePosted(nil, &model.Post{Message: message})
+		assert.Contains(t, post.Message, `[Open bugs: 3 issues`)
+		assert.Contains(t, post.Message, `[Issues: 1 issue (1 Closed)]`)
+		assert.Len(t, server.Requests(), before+3)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("Partial counts", func(t *testing.T) {
+		server := redminetest.NewServer(t)
+		for id := 1; id <= 520; id++ {
+			server.AddIssue(redmine.Issue{ID: id, Tracker: bug, Subject: "Issue", Status: newStatus, IsPrivate: id%10 == 0})
+		}
+		plugin := newPlugin()
+		plugin.httpClient = server.HTTPClient()
+
+		post, _ := plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues?status_id=o"})
+		assert.Contains(t, post.Message, `[Issues: 520 issues (first 500: 500 New)]`)
+		assert.Contains(t, post.Message, `&#013;and 515 more")`)
+
+		plugin.configuration.IssueDetailPolicy = issueDetailsHidePrivate
+		post, _ = plugin.MessageWillBePosted(nil, &model.Post{Message: "https://redmine.example.com/issues?status_id=o"})
+		assert.Contains(t, post.Message, `[Issues: at least 450 issues (first 450: 450 New)]`)
+		assert.Contains(t, post.Message, `&#013;and at least 445 more")`)
+	})
+}
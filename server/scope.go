@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// channelScope describes the channel a post is made in. The channel and its team are only
+// looked up when a setting is restricted to some teams or channels.
+type channelScope struct {
+	api       plugin.API
+	channelID string
+
+	channelLoaded bool
+	channelName   string
+	teamID        string
+
+	teamLoaded bool
+	teamName   string
+}
+
+func (p *Plugin) newChannelScope(channelID string) *channelScope {
+	return &channelScope{api: p.API, channelID: channelID}
+}
+
+func (s *channelScope) loadChannel() {
+	if s.channelLoaded {
+		return
+	}
+	s.channelLoaded = true
+
+	if s.channelID == "" {
+		return
+	}
+	if channel, appErr := s.api.GetChannel(s.channelID); appErr == nil {
+		s.channelName = channel.Name
+		s.teamID = channel.TeamId
+	}
+}
+
+func (s *channelScope) loadTeam() {
+	s.loadChannel()
+	if s.teamLoaded {
+		return
+	}
+	s.teamLoaded = true
+
+	if s.teamID == "" {
+		return
+	}
+	if team, appErr := s.api.GetTeam(s.teamID); appErr == nil {
+		s.teamName = team.Name
+	}
+}
+
+// allows reports whether the channel belongs to one of teams and is one of channels, both
+// given as names or IDs. An empty list allows everything.
+func (s *channelScope) allows(teams, channels []string) bool {
+	if len(teams) > 0 {
+		s.loadTeam()
+		if !containsNameOrID(teams, s.teamID, s.teamName) {
+			return false
+		}
+	}
+	if len(channels) > 0 {
+		s.loadChannel()
+		if !containsNameOrID(channels, s.channelID, s.channelName) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsNameOrID(values []string, id, name string) bool {
+	for _, value := range values {
+		if (id != "" && value == id) || (name != "" && strings.EqualFold(value, name)) {
+			return true
+		}
+	}
+
+	return false
+}
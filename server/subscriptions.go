@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+const (
+	subscriptionsKeyPrefix = "subscriptions_"
+
+	manageSubscriptionsMessage = "Only the administrators of this channel can manage its Redmine subscriptions."
+)
+
+// Values of the status filter matching any open or any closed status.
+const (
+	subscriptionStatusOpen   = "open"
+	subscriptionStatusClosed = "closed"
+)
+
+// subscription delivers the issue events of a project into a channel. Empty filters match
+// every issue.
+type subscription struct {
+	ChannelID string `json:"channel_id"`
+	ProjectID int    `json:"project_id"`
+	// Project is the identifier of the project.
+	Project     string `json:"project"`
+	ProjectName string `json:"project_name"`
+	// Trackers, Statuses and Priorities are names matched case-insensitively. Statuses may
+	// also be "open" or "closed".
+	Trackers   []string `json:"trackers,omitempty"`
+	Statuses   []string `json:"statuses,omitempty"`
+	Priorities []string `json:"priorities,omitempty"`
+	// CreatorID is the Mattermost user who subscribed the channel. Only the issues they can see
+	// in Redmine are delivered.
+	CreatorID string `json:"creator_id"`
+}
+
+// matches reports whether events of the issue are delivered by the subscription.
+func (s *subscription) matches(issue redmine.Issue) bool {
+	if issue.Project.ID != s.ProjectID {
+		return false
+	}
+	if len(s.Trackers) > 0 && !containsFold(s.Trackers, issue.Tracker.Name) {
+		return false
+	}
+	if len(s.Priorities) > 0 && !containsFold(s.Priorities, issue.Priority.Name) {
+		return false
+	}
+	if len(s.Statuses) > 0 {
+		state := subscriptionStatusOpen
+		if issue.Status.IsClosed {
+			state = subscriptionStatusClosed
+		}
+		if !containsFold(s.Statuses, issue.Status.Name) && !containsFold(s.Statuses, state) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describe returns the project and filters of the subscription, e.g.
+// "**Website** (`website`), tracker: Bug, status: closed".
+func (s *subscription) describe() string {
+	parts := []string{fmt.Sprintf("**%s** (`%s`)", s.ProjectName, s.Project)}
+	for _, filter := range []struct {
+		name   string
+		values []string
+	}{
+		{"tracker", s.Trackers},
+		{"status", s.Statuses},
+		{"priority", s.Priorities},
+	} {
+		if len(filter.values) > 0 {
+			parts = append(parts, filter.name+": "+strings.Join(filter.values, " or "))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// refersTo reports whether the project is given by its identifier or ID.
+func (s *subscription) refersTo(project string) bool {
+	return strings.EqualFold(s.Project, project) || strconv.Itoa(s.ProjectID) == project
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscriptionsKey stores the subscriptions of an instance.
+func subscriptionsKey(instanceURL string) string {
+	return subscriptionsKeyPrefix + instanceKeyHash(instanceURL)
+}
+
+// getSubscriptions returns the subscriptions of all channels to projects of the instance.
+func (p *Plugin) getSubscriptions(instance *redmineInstance) ([]subscription, error) {
+	if p.kvStore == nil {
+		return nil, nil
+	}
+
+	var subscriptions []subscription
+	if err := p.kvStore.Get(subscriptionsKey(instance.URL), &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+// updateSubscriptions applies update to the subscriptions of the instance and stores the
+// result. The subscriptions are compared and set atomically, so that changes made at the same
+// time by other nodes of a cluster are not lost, and update runs again after a conflict.
+func (p *Plugin) updateSubscriptions(instance *redmineInstance, update func([]subscription) []subscription) error {
+	if p.kvStore == nil {
+		return errors.New("the KV store is not available")
+	}
+
+	err := p.kvStore.SetAtomicWithRetries(subscriptionsKey(instance.URL), func(oldValue []byte) (interface{}, error) {
+		var subscriptions []subscription
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &subscriptions); err != nil {
+				return nil, err
+			}
+		}
+
+		subscriptions = update(subscriptions)
+		if len(subscriptions) == 0 {
+			// A nil value deletes the key.
+			return nil, nil
+		}
+		return subscriptions, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save subscriptions: %w", err)
+	}
+
+	return nil
+}
+
+// saveSubscription adds the subscription, replacing the one of the channel to the same project.
+func (p *Plugin) saveSubscription(instance *redmineInstance, sub subscription) error {
+	return p.updateSubscriptions(instance, func(subscriptions []subscription) []subscription {
+		for i := range subscriptions {
+			if subscriptions[i].ChannelID == sub.ChannelID && subscriptions[i].ProjectID == sub.ProjectID {
+				subscriptions[i] = sub
+				return subscriptions
+			}
+		}
+		return append(subscriptions, sub)
+	})
+}
+
+// deleteSubscription removes the subscription of the channel to the project and reports
+// whether there was one. The poll mark of the project is deleted with its last subscription,
+// so that subscribing again does not replay the changes made in between.
+func (p *Plugin) deleteSubscription(instance *redmineInstance, channelID, project string) (bool, error) {
+	deletedProjectID, subscribed := 0, false
+	err := p.updateSubscriptions(instance, func(subscriptions []subscription) []subscription {
+		deletedProjectID, subscribed = 0, false
+		kept := subscriptions[:0]
+		for _, sub := range subscriptions {
+			if sub.ChannelID == channelID && sub.refersTo(project) {
+				deletedProjectID = sub.ProjectID
+				continue
+			}
+			kept = append(kept, sub)
+		}
+		for _, sub := range kept {
+			if sub.ProjectID == deletedProjectID {
+				subscribed = true
+			}
+		}
+		return kept
+	})
+	if err != nil || deletedProjectID == 0 {
+		return false, err
+	}
+
+	if !subscribed {
+		if err := p.kvStore.Delete(pollMarkKey(instance.URL, deletedProjectID)); err != nil {
+			return true, fmt.Errorf("failed to delete poll mark: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// splitCommandArguments splits the arguments of a command at spaces, keeping double quoted
+// values such as --status="In Progress" together.
+func splitCommandArguments(parameters []string) []string {
+	var arguments []string
+	var current strings.Builder
+	quoted, started := false, false
+	for _, r := range strings.Join(parameters, " ") {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case r == ' ' && !quoted:
+			if started {
+				arguments = append(arguments, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		arguments = append(arguments, current.String())
+	}
+
+	return arguments
+}
+
+// parseSubscribeArguments parses "<project> [--tracker=Bug] [--status=closed] [--priority=High]
+// [--instance=<instance>]". Filters may be repeated or given as comma separated lists.
+func parseSubscribeArguments(parameters []string) (sub subscription, instanceName string, err error) {
+	for _, argument := range splitCommandArguments(parameters) {
+		if !strings.HasPrefix(argument, "--") {
+			if sub.Project != "" {
+				return sub, "", fmt.Errorf("unexpected argument `%s`", argument)
+			}
+			sub.Project = argument
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(argument, "--"), "=")
+		if !ok || strings.TrimSpace(value) == "" {
+			return sub, "", fmt.Errorf("`%s` needs a value, e.g. `--%s=value`", argument, name)
+		}
+
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+
+		switch name {
+		case "tracker":
+			sub.Trackers = append(sub.Trackers, values...)
+		case "status":
+			sub.Statuses = append(sub.Statuses, values...)
+		case "priority":
+			sub.Priorities = append(sub.Priorities, values...)
+		case "instance":
+			instanceName = value
+		default:
+			return sub, "", fmt.Errorf("unknown option `--%s`", name)
+		}
+	}
+
+	if sub.Project == "" {
+		return sub, "", errors.New("please specify a project")
+	}
+
+	return sub, instanceName, nil
+}
+
+// subscriptionInstance returns the instance given by name, or the first instance enabled in
+// the channel. Like the default, an instance given by name must be enabled in the channel, so
+// that the projects of instances restricted to other teams are not posted into it.
+func (p *Plugin) subscriptionInstance(args *model.CommandArgs, instanceName string) (*redmineInstance, string) {
+	if instanceName == "" {
+		return p.commandInstance(args, nil)
+	}
+
+	instance, text := p.commandInstance(args, []string{instanceName})
+	if instance == nil {
+		return nil, text
+	}
+	for _, enabled := range p.getInstancesForScope(p.newChannelScope(args.ChannelId)) {
+		if enabled.URL == instance.URL {
+			return instance, ""
+		}
+	}
+
+	return nil, fmt.Sprintf("Redmine instance `%s` is not enabled in this channel.", instanceName)
+}
+
+// canManageSubscriptions reports whether the user may subscribe the channel to projects: users
+// allowed to manage the properties of public or private channels, and members of direct and
+// group messages.
+func (p *Plugin) canManageSubscriptions(userID, channelID string) bool {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get channel", "channel_id", channelID, "err", appErr.Error())
+		return false
+	}
+
+	switch channel.Type {
+	case model.ChannelTypeOpen:
+		return p.API.HasPermissionToChannel(userID, channelID, model.PermissionManagePublicChannelProperties)
+	case model.ChannelTypePrivate:
+		return p.API.HasPermissionToChannel(userID, channelID, model.PermissionManagePrivateChannelProperties)
+	default:
+		return p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel)
+	}
+}
+
+func (p *Plugin) executeSubscribeCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	if len(parameters) == 1 && parameters[0] == "list" {
+		return p.executeSubscriptionsListCommand(args)
+	}
+
+	sub, instanceName, err := parseSubscribeArguments(parameters)
+	if err != nil {
+		return ephemeralResponse(fmt.Sprintf("%s.\nUsage: `/redmine subscribe <project> [--tracker=Bug] [--status=closed] [--priority=High]`.", upperFirst(err.Error())))
+	}
+
+	if !p.canManageSubscriptions(args.UserId, args.ChannelId) {
+		return ephemeralResponse(manageSubscriptionsMessage)
+	}
+	instance, text := p.subscriptionInstance(args, instanceName)
+	if instance == nil {
+		return ephemeralResponse(text)
+	}
+
+	// The project is looked up as the user, so that only projects they can see are subscribed
+	// to. Issues are delivered as the user sees them too, never with the API key of the instance.
+	client, err := p.getPersonalClient(instance, args.UserId)
+	if err != nil {
+		p.API.LogWarn("Failed to create Redmine client", "instance", instance.URL, "err", err.Error())
+		return ephemeralResponse("Failed to connect to " + instance.displayName() + ".")
+	}
+	project, err := client.GetProject(context.Background(), sub.Project)
+	if errors.Is(err, redmine.ErrUnauthorized) && client.account == nil {
+		return ephemeralResponse(connectRequiredMessage)
+	}
+	if errors.Is(err, redmine.ErrNotFound) || errors.Is(err, redmine.ErrForbidden) {
+		return ephemeralResponse(fmt.Sprintf("Project `%s` was not found on %s.", sub.Project, instance.displayName()))
+	}
+	if err != nil {
+		p.API.LogWarn("Failed to get project", "instance", instance.URL, "project", sub.Project, "err", err.Error())
+		return ephemeralResponse(fmt.Sprintf("Failed to look up project `%s` on %s.", sub.Project, instance.displayName()))
+	}
+
+	sub.ChannelID = args.ChannelId
+	sub.ProjectID = project.ID
+	sub.Project = project.Identifier
+	sub.ProjectName = project.Name
+	sub.CreatorID = args.UserId
+	if err := p.saveSubscription(instance, sub); err != nil {
+		p.API.LogWarn("Failed to save subscription", "instance", instance.URL, "channel_id", args.ChannelId, "err", err.Error())
+		return ephemeralResponse("Failed to save the subscription.")
+	}
+
+	return ephemeralResponse(fmt.Sprintf("This channel is now subscribed to the issues of %s on %s.", sub.describe(), instance.displayName()))
+}
+
+func (p *Plugin) executeSubscriptionsListCommand(args *model.CommandArgs) *model.CommandResponse {
+	var lines []string
+	for _, instance := range p.getConfiguration().getInstances() {
+		subscriptions, err := p.getSubscriptions(instance)
+		if err != nil {
+			p.API.LogWarn("Failed to list subscriptions", "instance", instance.URL, "err", err.Error())
+			lines = append(lines, "- Failed to list the subscriptions on "+instance.displayName()+".")
+			continue
+		}
+		for _, sub := range subscriptions {
+			if sub.ChannelID == args.ChannelId {
+				lines = append(lines, fmt.Sprintf("- %s on %s", sub.describe(), instance.displayName()))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return ephemeralResponse("This channel is not subscribed to any Redmine project.")
+	}
+
+	return ephemeralResponse("Redmine subscriptions of this channel:\n" + strings.Join(lines, "\n"))
+}
+
+func (p *Plugin) executeUnsubscribeCommand(args *model.CommandArgs, parameters []string) *model.CommandResponse {
+	sub, instanceName, err := parseSubscribeArguments(parameters)
+	if err != nil || len(sub.Trackers)+len(sub.Statuses)+len(sub.Priorities) > 0 {
+		return ephemeralResponse("Usage: `/redmine unsubscribe <project>`.")
+	}
+	if !p.canManageSubscriptions(args.UserId, args.ChannelId) {
+		return ephemeralResponse(manageSubscriptionsMessage)
+	}
+
+	instance, text := p.subscriptionInstance(args, instanceName)
+	if instance == nil {
+		return ephemeralResponse(text)
+	}
+
+	deleted, err := p.deleteSubscription(instance, args.ChannelId, sub.Project)
+	if err != nil {
+		p.API.LogWarn("Failed to delete subscription", "instance", instance.URL, "channel_id", args.ChannelId, "err", err.Error())
+		return ephemeralResponse("Failed to delete the subscription.")
+	}
+	if !deleted {
+		return ephemeralResponse(fmt.Sprintf("This channel is not subscribed to `%s` on %s.", sub.Project, instance.displayName()))
+	}
+
+	return ephemeralResponse(fmt.Sprintf("This channel is no longer subscribed to `%s` on %s.", sub.Project, instance.displayName()))
+}
+
+func upperFirst(text string) string {
+	if text == "" {
+		return text
+	}
+
+	return strings.ToUpper(text[:1]) + text[1:]
+}
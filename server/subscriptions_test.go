@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine/redminetest"
+)
+
+func TestParseSubscribeArguments(t *testing.T) {
+	for _, tc := range []struct {
+		Description string
+		Parameters  []string
+		Expected    subscription
+		Instance    string
+		Error       string
+	}{
+		{
+			Description: "Project only",
+			Parameters:  []string{"website"},
+			Expected:    subscription{Project: "website"},
+		},
+		{
+			Description: "Filters",
+			Parameters:  []string{"website", "--tracker=Bug,Feature", "--status=closed", "--priority=High", "--priority=Urgent"},
+			Expected: subscription{
+				Project:    "website",
+				Trackers:   []string{"Bug", "Feature"},
+				Statuses:   []string{"closed"},
+				Priorities: []string{"High", "Urgent"},
+			},
+		},
+		{
+			Description: "Quoted value",
+			Parameters:  []string{"--status=\"In", "Progress\"", "website"},
+			Expected:    subscription{Project: "website", Statuses: []string{"In Progress"}},
+		},
+		{
+			Description: "Instance",
+			Parameters:  []string{"website", "--instance=Internal"},
+			Expected:    subscription{Project: "website"},
+			Instance:    "Internal",
+		},
+		{
+			Description: "No project",
+			Parameters:  []string{"--tracker=Bug"},
+			Error:       "please specify a project",
+		},
+		{
+			Description: "Two projects",
+			Parameters:  []string{"website", "api"},
+			Error:       "unexpected argument `api`",
+		},
+		{
+			Description: "Missing value",
+			Parameters:  []string{"website", "--tracker"},
+			Error:       "`--tracker` needs a value, e.g. `--tracker=value`",
+		},
+		{
+			Description: "Unknown option",
+			Parameters:  []string{"website", "--author=jdoe"},
+			Error:       "unknown option `--author`",
+		},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			sub, instance, err := parseSubscribeArguments(tc.Parameters)
+			if tc.Error != "" {
+				require.EqualError(t, err, tc.Error)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expected, sub)
+			assert.Equal(t, tc.Instance, instance)
+		})
+	}
+}
+
+func TestSubscriptionMatches(t *testing.T) {
+	issue := redmine.Issue{
+		Project:  redmine.IssueProperty{ID: 1},
+		Tracker:  redmine.IssueProperty{Name: "Bug"},
+		Status:   redmine.Status{IssueProperty: redmine.IssueProperty{Name: "Resolved"}, IsClosed: true},
+		Priority: redmine.IssueProperty{Name: "High"},
+	}
+
+	for _, tc := range []struct {
+		Description  string
+		Subscription subscription
+		Expected     bool
+	}{
+		{"No filters", subscription{ProjectID: 1}, true},
+		{"Other project", subscription{ProjectID: 2}, false},
+		{"Tracker", subscription{ProjectID: 1, Trackers: []string{"feature", "bug"}}, true},
+		{"Other tracker", subscription{ProjectID: 1, Trackers: []string{"Feature"}}, false},
+		{"Closed", subscription{ProjectID: 1, Statuses: []string{"closed"}}, true},
+		{"Open", subscription{ProjectID: 1, Statuses: []string{"open"}}, false},
+		{"Status name", subscription{ProjectID: 1, Statuses: []string{"resolved"}}, true},
+		{"Priority", subscription{ProjectID: 1, Priorities: []string{"Low"}}, false},
+		{"All filters", subscription{ProjectID: 1, Trackers: []string{"Bug"}, Statuses: []string{"closed"}, Priorities: []string{"High"}}, true},
+	} {
+		t.Run(tc.Description, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, tc.Subscription.matches(issue))
+		})
+	}
+}
+
+// newSubscriptionsTestServer returns a server open to anonymous users, who see the public
+// issues. Subscribers without a linked account see the issues they would see in Redmine.
+func newSubscriptionsTestServer(t *testing.T) *redminetest.Server {
+	server := redminetest.NewServer(t)
+	server.AddProject(redmine.Project{ID: 1, Identifier: "website", Name: "Website"})
+	server.AddProject(redmine.Project{ID: 2, Identifier: "api", Name: "API"})
+
+	return server
+}
+
+func newSubscriptionsTestPlugin(server *redminetest.Server) *Plugin {
+	return &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL:      "https://redmine.example.com",
+			RedmineAPIKey:           "key",
+			AllowGlobalAPIKey:       true,
+			TooltipTemplate:         "{{.Status.Name}}",
+			SubscriptionPollMinutes: 5,
+		},
+		httpClient: server.HTTPClient(),
+		kvStore:    &pluginapi.MemoryStore{},
+		botUserID:  "bot-id",
+	}
+}
+
+func TestSubscribeCommand(t *testing.T) {
+	server := newSubscriptionsTestServer(t)
+	plugin := newSubscriptionsTestPlugin(server)
+	api := &plugintest.API{}
+	api.On("GetChannel", "channel-id").Return(&model.Channel{Id: "channel-id", Type: model.ChannelTypeOpen}, nil)
+	api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionManagePublicChannelProperties).Return(true)
+	api.On("HasPermissionToChannel", "member-id", "channel-id", model.PermissionManagePublicChannelProperties).Return(false)
+	plugin.SetAPI(api)
+
+	runAs := func(userID, command string) string {
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: command, UserId: userID, ChannelId: "channel-id"})
+		require.Nil(t, appErr)
+		return response.Text
+	}
+	run := func(command string) string {
+		return runAs("user-id", command)
+	}
+
+	assert.Equal(t, "This channel is not subscribed to any Redmine project.", run("/redmine subscribe list"))
+
+	assert.Equal(t, "This channel is now subscribed to the issues of **Website** (`website`), tracker: Bug, status: In Progress or closed on https://redmine.example.com.",
+		run(`/redmine subscribe website --tracker=Bug --status="In Progress",closed`))
+	assert.Equal(t, "This channel is now subscribed to the issues of **API** (`api`) on https://redmine.example.com.", run("/redmine subscribe 2"))
+	assert.Equal(t, "Project `missing` was not found on https://redmine.example.com.", run("/redmine subscribe missing"))
+	assert.Equal(t, "Unknown option `--author`.\nUsage: `/redmine subscribe <project> [--tracker=Bug] [--status=closed] [--priority=High]`.", run("/redmine subscribe website --author=jdoe"))
+
+	// Subscribing again replaces the filters.
+	run("/redmine subscribe website --priority=High")
+	assert.Equal(t, "Redmine subscriptions of this channel:\n"+
+		"- **Website** (`website`), priority: High on https://redmine.example.com\n"+
+		"- **API** (`api`) on https://redmine.example.com", run("/redmine subscribe list"))
+
+	subscriptions, err := plugin.getSubscriptions(plugin.getConfiguration().getInstances()[0])
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 2)
+	assert.Equal(t, subscription{ChannelID: "channel-id", ProjectID: 1, Project: "website", ProjectName: "Website", Priorities: []string{"High"}, CreatorID: "user-id"}, subscriptions[0])
+
+	// Members who cannot manage the channel can only list its subscriptions.
+	assert.Equal(t, manageSubscriptionsMessage, runAs("member-id", "/redmine subscribe website"))
+	assert.Equal(t, manageSubscriptionsMessage, runAs("member-id", "/redmine unsubscribe website"))
+	assert.Contains(t, runAs("member-id", "/redmine subscribe list"), "Redmine subscriptions of this channel:")
+
+	assert.Equal(t, "This channel is no longer subscribed to `website` on https://redmine.example.com.", run("/redmine unsubscribe website"))
+	assert.Equal(t, "This channel is not subscribed to `website` on https://redmine.example.com.", run("/redmine unsubscribe website"))
+	assert.Equal(t, "This channel is no longer subscribed to `2` on https://redmine.example.com.", run("/redmine unsubscribe 2"))
+	assert.Equal(t, "This channel is not subscribed to any Redmine project.", run("/redmine subscribe list"))
+	assert.Equal(t, "Redmine instance `other` is not configured.", run("/redmine unsubscribe website --instance=other"))
+}
+
+func TestSubscribeInstanceScope(t *testing.T) {
+	server := newSubscriptionsTestServer(t)
+	api := &plugintest.API{}
+	api.On("GetChannel", "channel-id").Return(&model.Channel{Id: "channel-id", TeamId: "team-id", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetTeam", "team-id").Return(&model.Team{Id: "team-id", Name: "dev"}, nil)
+	api.On("HasPermissionToChannel", "user-id", "channel-id", model.PermissionManagePublicChannelProperties).Return(true)
+
+	plugin := newSubscriptionsTestPlugin(server)
+	plugin.configuration.RedmineInstances = `[
+		{"url": "https://internal.example.com", "label": "Internal", "teams": ["dev"]},
+		{"url": "https://tracker.customer.com", "label": "Customer", "teams": ["support"]}
+	]`
+	plugin.httpClient = redminetest.RoutingHTTPClient(map[string]*redminetest.Server{
+		"internal.example.com": server,
+		"tracker.customer.com": server,
+	})
+	plugin.SetAPI(api)
+
+	run := func(command string) string {
+		response, appErr := plugin.ExecuteCommand(nil, &model.CommandArgs{Command: command, UserId: "user-id", ChannelId: "channel-id"})
+		require.Nil(t, appErr)
+		return response.Text
+	}
+
+	assert.Equal(t, "Redmine instance `Customer` is not enabled in this channel.", run("/redmine subscribe website --instance=Customer"))
+	assert.Equal(t, "This channel is now subscribed to the issues of **Website** (`website`) on Internal.", run("/redmine subscribe website --instance=Internal"))
+}
+
+func TestSaveSubscriptionConcurrently(t *testing.T) {
+	plugin := newSubscriptionsTestPlugin(newSubscriptionsTestServer(t))
+	instance := plugin.getConfiguration().getInstances()[0]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(channelID string) {
+			defer wg.Done()
+			assert.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: channelID, ProjectID: 1, Project: "website"}))
+		}(fmt.Sprintf("channel-%d", i))
+	}
+	wg.Wait()
+
+	subscriptions, err := plugin.getSubscriptions(instance)
+	require.NoError(t, err)
+	assert.Len(t, subscriptions, 10)
+}
+
+func TestPollSubscriptions(t *testing.T) {
+	server := newSubscriptionsTestServer(t)
+	bug := redmine.IssueProperty{ID: 1, Name: "Bug"}
+	website := redmine.IssueProperty{ID: 1, Name: "Website"}
+	newStatus := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 1, Name: "New"}}
+	closedStatus := redmine.Status{IssueProperty: redmine.IssueProperty{ID: 5, Name: "Closed"}, IsClosed: true}
+
+	server.AddIssue(redmine.Issue{ID: 10, Project: website, Tracker: bug, Status: newStatus, Subject: "Old issue",
+		CreatedOn: "2024-05-01T09:00:00Z", UpdatedOn: "2024-05-01T09:00:00Z"})
+	server.AddIssue(redmine.Issue{ID: 12, Project: website, Tracker: bug, Status: newStatus, Subject: "Closed issue",
+		CreatedOn: "2024-05-01T08:00:00Z", UpdatedOn: "2024-05-01T08:00:00Z"})
+
+	api := &plugintest.API{}
+	var posts []*model.Post
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		posts = append(posts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+
+	plugin := newSubscriptionsTestPlugin(server)
+	plugin.SetAPI(api)
+
+	instance := plugin.getConfiguration().getInstances()[0]
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-a", ProjectID: 1, Project: "website"}))
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-b", ProjectID: 1, Project: "website", Trackers: []string{"Bug"}, Statuses: []string{"closed"}}))
+
+	// The first poll only records the most recent change.
+	plugin.pollSubscriptions(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	assert.Empty(t, posts)
+	mark, err := plugin.getPollMark(instance, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &pollMark{UpdatedOn: time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), IssueIDs: []int{10}}, mark)
+
+	server.AddIssue(redmine.Issue{ID: 11, Project: website, Tracker: bug, Status: newStatus, Subject: "New issue",
+		Author: redmine.IssueProperty{ID: 5, Name: "Jane Doe"}, CreatedOn: "2024-05-01T10:05:00Z", UpdatedOn: "2024-05-01T10:05:00Z"})
+	server.AddIssue(redmine.Issue{ID: 12, Project: website, Tracker: bug, Status: closedStatus, Subject: "Closed issue",
+		CreatedOn: "2024-05-01T08:00:00Z", UpdatedOn: "2024-05-01T10:10:00Z",
+		Journals: []redmine.Journal{
+			{ID: 1, User: redmine.IssueProperty{ID: 5, Name: "Jane Doe"}, Notes: "Old note", CreatedOn: "2024-05-01T08:30:00Z"},
+			{ID: 2, User: redmine.IssueProperty{ID: 6, Name: "John Smith"}, Notes: "Internal", PrivateNotes: true, CreatedOn: "2024-05-01T10:09:00Z"},
+			{ID: 3, User: redmine.IssueProperty{ID: 6, Name: "John Smith"}, Notes: "Done.", CreatedOn: "2024-05-01T10:10:00Z",
+				Details: []redmine.JournalDetail{{Property: "attr", Name: "status_id", OldValue: "1", NewValue: "5"}}},
+		}})
+	server.AddIssue(redmine.Issue{ID: 13, Project: website, Tracker: redmine.IssueProperty{ID: 2, Name: "Feature"}, Status: newStatus, Subject: "Feature",
+		CreatedOn: "2024-05-01T09:00:00Z", UpdatedOn: "2024-05-01T10:06:00Z"})
+	server.AddIssue(redmine.Issue{ID: 14, Project: redmine.IssueProperty{ID: 2, Name: "API"}, Tracker: bug, Status: newStatus, Subject: "Other project",
+		CreatedOn: "2024-05-01T10:07:00Z", UpdatedOn: "2024-05-01T10:07:00Z"})
+
+	type delivery struct{ ChannelID, Message string }
+	deliveries := func() []delivery {
+		var deliveries []delivery
+		for _, post := range posts {
+			deliveries = append(deliveries, delivery{post.ChannelId, post.Message})
+		}
+		posts = nil
+		return deliveries
+	}
+
+	plugin.pollSubscriptions(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC))
+	assert.Equal(t, []delivery{
+		{"channel-a", `Jane Doe created [Bug#11: New issue](https://redmine.example.com/issues/11 "New")`},
+		{"channel-a", `Someone updated [Feature#13: Feature](https://redmine.example.com/issues/13 "New")`},
+		{"channel-a", "John Smith closed [Bug#12: Closed issue](https://redmine.example.com/issues/12 \"Closed\")\n- **Status**: Closed\n\n> Done."},
+		{"channel-b", "John Smith closed [Bug#12: Closed issue](https://redmine.example.com/issues/12 \"Closed\")\n- **Status**: Closed\n\n> Done."},
+	}, deliveries())
+
+	// A restarted plugin resumes from the stored mark.
+	restarted := newSubscriptionsTestPlugin(server)
+	restarted.kvStore = plugin.kvStore
+	restarted.SetAPI(api)
+	restarted.pollSubscriptions(time.Date(2024, 5, 1, 10, 20, 0, 0, time.UTC))
+	assert.Empty(t, deliveries())
+
+	// Issues changed in the same second as the mark are posted once.
+	server.AddIssue(redmine.Issue{ID: 15, Project: website, Tracker: bug, Status: newStatus, Subject: "Same second",
+		CreatedOn: "2024-05-01T10:10:00Z", UpdatedOn: "2024-05-01T10:10:00Z"})
+	restarted.pollSubscriptions(time.Date(2024, 5, 1, 10, 25, 0, 0, time.UTC))
+	assert.Equal(t, []delivery{
+		{"channel-a", `Someone created [Bug#15: Same second](https://redmine.example.com/issues/15 "New")`},
+	}, deliveries())
+	restarted.pollSubscriptions(time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC))
+	assert.Empty(t, deliveries())
+
+	// Changes older than the poll window, made while polling stopped, are skipped.
+	server.AddIssue(redmine.Issue{ID: 17, Project: website, Tracker: bug, Status: newStatus, Subject: "While stopped",
+		CreatedOn: "2024-05-02T09:00:00Z", UpdatedOn: "2024-05-02T09:00:00Z"})
+	server.AddIssue(redmine.Issue{ID: 18, Project: website, Tracker: bug, Status: newStatus, Subject: "After restart",
+		CreatedOn: "2024-05-03T09:50:00Z", UpdatedOn: "2024-05-03T09:50:00Z"})
+	restarted.pollSubscriptions(time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, []delivery{
+		{"channel-a", `Someone created [Bug#18: After restart](https://redmine.example.com/issues/18 "New")`},
+	}, deliveries())
+
+	// Issues are only delivered to the channels of subscribers who can see them in Redmine.
+	restarted.configuration.EncryptionKey = "secret"
+	server.AddUserAPIKey("jane-key", 5)
+	account := newUserAccount(instance, &redmine.User{ID: 5, Login: "jdoe"})
+	require.NoError(t, account.setAPIKey("secret", "jane-key"))
+	require.NoError(t, restarted.saveUserAccount("jane-id", account))
+	require.NoError(t, restarted.saveSubscription(instance, subscription{ChannelID: "channel-c", ProjectID: 1, Project: "website", CreatorID: "jane-id"}))
+	server.AddIssue(redmine.Issue{ID: 16, Project: website, Tracker: bug, Status: newStatus, Subject: "Private issue", IsPrivate: true,
+		AssignedTo: redmine.IssueProperty{ID: 5, Name: "Jane Doe"}, CreatedOn: "2024-05-03T10:02:00Z", UpdatedOn: "2024-05-03T10:02:00Z"})
+	restarted.pollSubscriptions(time.Date(2024, 5, 3, 10, 5, 0, 0, time.UTC))
+	assert.Equal(t, []delivery{
+		{"channel-c", `Someone created [Bug#16: Private issue](https://redmine.example.com/issues/16 "New")`},
+	}, deliveries())
+	_, err = restarted.deleteSubscription(instance, "channel-c", "website")
+	require.NoError(t, err)
+
+	// The mark is deleted with the last subscription of the project.
+	_, err = restarted.deleteSubscription(instance, "channel-a", "website")
+	require.NoError(t, err)
+	mark, err = restarted.getPollMark(instance, 1)
+	require.NoError(t, err)
+	assert.NotNil(t, mark)
+	_, err = restarted.deleteSubscription(instance, "channel-b", "website")
+	require.NoError(t, err)
+	mark, err = restarted.getPollMark(instance, 1)
+	require.NoError(t, err)
+	assert.Nil(t, mark)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/moddi3/mattermost-plugin-redmine-link/server/redmine"
+)
+
+// maxWebhookBodySize caps the size of webhook payloads.
+const maxWebhookBodySize = 1 << 20
+
+// webhookPayload is the JSON sent by Redmine webhook plugins such as redmine_webhook, either
+// as is or wrapped in a "payload" object.
+type webhookPayload struct {
+	// Action is "opened" for new issues and "updated" for changes.
+	Action  string          `json:"action"`
+	Issue   *webhookIssue   `json:"issue"`
+	Journal *webhookJournal `json:"journal"`
+	// URL is the link to the issue.
+	URL string `json:"url"`
+}
+
+type webhookUser struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Name      string `json:"name"`
+}
+
+func (u *webhookUser) displayName() string {
+	if u == nil {
+		return ""
+	}
+	if u.Name != "" {
+		return u.Name
+	}
+
+	user := redmine.User{Login: u.Login, Firstname: u.Firstname, Lastname: u.Lastname}
+	return user.Name()
+}
+
+func (u *webhookUser) property() redmine.IssueProperty {
+	if u == nil {
+		return redmine.IssueProperty{}
+	}
+
+	return redmine.IssueProperty{ID: u.ID, Name: u.displayName()}
+}
+
+type webhookIssue struct {
+	ID             int                   `json:"id"`
+	Subject        string                `json:"subject"`
+	Description    string                `json:"description"`
+	Project        redmine.IssueProperty `json:"project"`
+	Tracker        redmine.IssueProperty `json:"tracker"`
+	Status         redmine.Status        `json:"status"`
+	Priority       redmine.IssueProperty `json:"priority"`
+	Author         *webhookUser          `json:"author"`
+	Assignee       *webhookUser          `json:"assignee"`
+	StartDate      string                `json:"start_date"`
+	DueDate        *string               `json:"due_date"`
+	DoneRatio      int                   `json:"done_ratio"`
+	IsPrivate      bool                  `json:"is_private"`
+	EstimatedHours *float64              `json:"estimated_hours"`
+	CreatedOn      string                `json:"created_on"`
+	UpdatedOn      string                `json:"updated_on"`
+	ClosedOn       *string               `json:"closed_on"`
+}
+
+// issue converts the payload to the issue returned by the REST API, so that it can be rendered
+// with the link templates.
+func (i *webhookIssue) issue() redmine.Issue {
+	issue := redmine.Issue{
+		ID:             i.ID,
+		Project:        i.Project,
+		Tracker:        i.Tracker,
+		Status:         i.Status,
+		Priority:       i.Priority,
+		Author:         i.Author.property(),
+		AssignedTo:     i.Assignee.property(),
+		Subject:        i.Subject,
+		Description:    i.Description,
+		StartDate:      i.StartDate,
+		DueDate:        i.DueDate,
+		DoneRatio:      i.DoneRatio,
+		IsPrivate:      i.IsPrivate,
+		EstimatedHours: i.EstimatedHours,
+		CreatedOn:      i.CreatedOn,
+		UpdatedOn:      i.UpdatedOn,
+		ClosedOn:       i.ClosedOn,
+	}
+	if i.ClosedOn != nil && *i.ClosedOn != "" {
+		issue.Status.IsClosed = true
+	}
+
+	return issue
+}
+
+type webhookJournal struct {
+	Notes        string                 `json:"notes"`
+	PrivateNotes bool                   `json:"private_notes"`
+	Author       *webhookUser           `json:"author"`
+	Details      []webhookJournalDetail `json:"details"`
+}
+
+type webhookJournalDetail struct {
+	Property string  `json:"property"`
+	PropKey  string  `json:"prop_key"`
+	OldValue *string `json:"old_value"`
+	Value    *string `json:"value"`
+}
+
+// details converts the journal details to the ones returned by the REST API.
+func (j *webhookJournal) details() []redmine.JournalDetail {
+	details := make([]redmine.JournalDetail, 0, len(j.Details))
+	for _, detail := range j.Details {
+		details = append(details, redmine.JournalDetail{
+			Property: detail.Property,
+			Name:     detail.PropKey,
+			OldValue: stringValue(detail.OldValue),
+			NewValue: stringValue(detail.Value),
+		})
+	}
+
+	return details
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+
+	return *value
+}
+
+// parseWebhookPayload decodes a payload, accepting it with or without the "payload" wrapper.
+func parseWebhookPayload(body []byte) (*webhookPayload, error) {
+	var wrapped struct {
+		Payload *webhookPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.Payload != nil {
+		return wrapped.Payload, nil
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// event converts the payload to an issue event of the given instance.
+func (w *webhookPayload) event(instance *redmineInstance) (*issueEvent, error) {
+	if w.Issue == nil || w.Issue.ID == 0 {
+		return nil, errors.New("the payload has no issue")
+	}
+
+	issue := w.Issue.issue()
+	event := &issueEvent{issue: issue, instance: instance}
+
+	switch w.Action {
+	case "opened", "created":
+		event.kind = issueEventCreated
+		event.actor = issue.Author.Name
+	case "updated":
+		event.kind = issueEventUpdated
+		if w.Journal != nil {
+			event.actor = w.Journal.Author.displayName()
+			if !w.Journal.PrivateNotes {
+				event.notes = w.Journal.Notes
+			}
+			event.changes = journalChanges(issue, w.Journal.details())
+
+			for _, detail := range w.Journal.Details {
+				if detail.Property == "attr" && detail.PropKey == "status_id" && issue.Status.IsClosed {
+					event.kind = issueEventClosed
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported action %q", w.Action)
+	}
+
+	return event, nil
+}
+
+// webhookInstance finds the instance an issue URL belongs to. The instance query parameter
+// takes precedence, and the first instance is used when neither matches.
+func (p *Plugin) webhookInstance(nameOrURL, issueURL string) *redmineInstance {
+	instances := p.getConfiguration().getInstances()
+	if nameOrURL != "" {
+		return findInstance(instances, nameOrURL)
+	}
+
+	if _, issueHost := getRedmineInstanceURL(issueURL); issueHost != "" {
+		for _, instance := range instances {
+			if _, host := getRedmineInstanceURL(instance.URL); strings.HasPrefix(issueHost, host+"/") {
+				return instance
+			}
+		}
+	}
+
+	return findInstance(instances, "")
+}
+
+// webhookResponse reports the number of posted events, and the requested channels that are
+// not subscribed to the project of the issue.
+type webhookResponse struct {
+	Posted   int      `json:"posted"`
+	Rejected []string `json:"rejected,omitempty"`
+}
+
+// webhookChannels splits the requested channels into those subscribed to the project of the
+// issue with /redmine subscribe, and the rejected others. Subscribed channels whose filters do
+// not match the issue are left out of both.
+func (p *Plugin) webhookChannels(instance *redmineInstance, issue redmine.Issue, channelIDs []string) (subscribed, rejected []string, err error) {
+	subscriptions, err := p.getSubscriptions(instance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, channelID := range channelIDs {
+		bound, matches := false, false
+		for _, sub := range subscriptions {
+			if sub.ChannelID == channelID && sub.ProjectID == issue.Project.ID {
+				bound = true
+				matches = matches || sub.matches(issue)
+			}
+		}
+
+		switch {
+		case !bound:
+			rejected = append(rejected, channelID)
+		case matches:
+			subscribed = append(subscribed, channelID)
+		}
+	}
+
+	return subscribed, rejected, nil
+}
+
+// handleWebhook receives issue events from Redmine and posts them into the channels given
+// by the channel query parameters. Requests must carry the WebhookSecret setting in the
+// secret query parameter, and the channels must be subscribed to the project of the issue,
+// so that the secret alone does not allow posting into any channel.
+func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := p.getConfiguration().WebhookSecret
+	if secret == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(secret)) != 1 {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read the request", http.StatusBadRequest)
+		return
+	}
+	payload, err := parseWebhookPayload(body)
+	if err != nil {
+		http.Error(w, "Invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	instance := p.webhookInstance(r.URL.Query().Get("instance"), payload.URL)
+	if instance == nil {
+		http.Error(w, "Unknown Redmine instance", http.StatusBadRequest)
+		return
+	}
+
+	event, err := payload.event(instance)
+	if err != nil {
+		http.Error(w, "Invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	channelIDs, rejected, err := p.webhookChannels(instance, event.issue, r.URL.Query()["channel"])
+	if err != nil {
+		p.API.LogWarn("Failed to load subscriptions", "instance", instance.URL, "err", err.Error())
+		http.Error(w, "Failed to load subscriptions", http.StatusInternalServerError)
+		return
+	}
+	if len(rejected) > 0 {
+		p.API.LogWarn("Webhook channels are not subscribed to the project", "instance", instance.URL, "project_id", event.issue.Project.ID, "channel_ids", strings.Join(rejected, ","))
+		if len(channelIDs) == 0 {
+			http.Error(w, "The channels are not subscribed to the project of the issue", http.StatusForbidden)
+			return
+		}
+	}
+
+	posted := p.handleIssueEvent(event, channelIDs)
+	writeJSON(w, webhookResponse{Posted: posted, Rejected: rejected})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const webhookOpenedPayload = `{"payload": {
+	"action": "opened",
+	"url": "https://redmine.example.com/issues/12",
+	"issue": {
+		"id": 12,
+		"subject": "Login fails",
+		"is_private": false,
+		"project": {"id": 1, "identifier": "website", "name": "Website"},
+		"tracker": {"id": 1, "name": "Bug"},
+		"status": {"id": 1, "name": "New"},
+		"priority": {"id": 4, "name": "Normal"},
+		"author": {"id": 5, "login": "jdoe", "firstname": "Jane", "lastname": "Doe"},
+		"assignee": null,
+		"created_on": "2024-05-01T10:00:00.000Z",
+		"updated_on": "2024-05-01T10:00:00.000Z",
+		"closed_on": null
+	}
+}}`
+
+const webhookClosedPayload = `{"payload": {
+	"action": "updated",
+	"url": "https://redmine.example.com/issues/12",
+	"issue": {
+		"id": 12,
+		"subject": "Login fails",
+		"project": {"id": 1, "identifier": "website", "name": "Website"},
+		"tracker": {"id": 1, "name": "Bug"},
+		"status": {"id": 5, "name": "Closed"},
+		"priority": {"id": 4, "name": "Normal"},
+		"author": {"id": 5, "login": "jdoe", "firstname": "Jane", "lastname": "Doe"},
+		"assignee": {"id": 6, "login": "jsmith", "firstname": "John", "lastname": "Smith"},
+		"done_ratio": 100,
+		"closed_on": "2024-05-02T10:00:00.000Z"
+	},
+	"journal": {
+		"id": 40,
+		"notes": "Fixed in r1234.\nPlease verify.",
+		"private_notes": false,
+		"author": {"id": 6, "login": "jsmith", "firstname": "John", "lastname": "Smith"},
+		"details": [
+			{"property": "attr", "prop_key": "status_id", "old_value": "1", "value": "5"},
+			{"property": "attr", "prop_key": "done_ratio", "old_value": "0", "value": "100"},
+			{"property": "attr", "prop_key": "lock_version", "old_value": "1", "value": "2"}
+		]
+	}
+}}`
+
+func TestHandleWebhook(t *testing.T) {
+	api := &plugintest.API{}
+	var posts []*model.Post
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		posts = append(posts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+
+	plugin := &Plugin{
+		configuration: &configuration{
+			RedmineInstanceURL: "https://redmine.example.com",
+			TooltipTemplate:    "{{.Status.Name}}",
+			WebhookSecret:      "secret",
+		},
+		botUserID: "bot-id",
+		kvStore:   &pluginapi.MemoryStore{},
+	}
+	plugin.SetAPI(api)
+
+	instance := plugin.getConfiguration().getInstances()[0]
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-a", ProjectID: 1, Project: "website"}))
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-b", ProjectID: 1, Project: "website"}))
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-c", ProjectID: 1, Project: "website", Trackers: []string{"Feature"}}))
+	require.NoError(t, plugin.saveSubscription(instance, subscription{ChannelID: "channel-d", ProjectID: 2, Project: "api"}))
+
+	send := func(query, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, routeWebhook+query, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	t.Run("Created", func(t *testing.T) {
+		posts = nil
+		w := send("?secret=secret&channel=channel-a&channel=channel-b", webhookOpenedPayload)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"posted": 2}`, w.Body.String())
+
+		require.Len(t, posts, 2)
+		assert.Equal(t, "channel-a", posts[0].ChannelId)
+		assert.Equal(t, "channel-b", posts[1].ChannelId)
+		assert.Equal(t, "bot-id", posts[0].UserId)
+		assert.Equal(t, `Jane Doe created [Bug#12: Login fails](https://redmine.example.com/issues/12 "New")`, posts[0].Message)
+		require.Len(t, posts[0].Attachments(), 1)
+		assert.Equal(t, "Bug #12: Login fails", posts[0].Attachments()[0].Title)
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		posts = nil
+		w := send("?secret=secret&channel=channel-a", webhookClosedPayload)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		require.Len(t, posts, 1)
+		assert.Equal(t, "John Smith closed [Bug#12: Login fails](https://redmine.example.com/issues/12 \"Closed\")\n"+
+			"- **Status**: Closed\n"+
+			"- **% Done**: 0 → 100\n"+
+			"\n"+
+			"> Fixed in r1234.\n> Please verify.", posts[0].Message)
+		assert.Empty(t, posts[0].Attachments())
+	})
+
+	t.Run("Private issues are hidden by the policy", func(t *testing.T) {
+		posts = nil
+		hiding := &Plugin{configuration: plugin.configuration.Clone(), botUserID: "bot-id", kvStore: plugin.kvStore}
+		hiding.configuration.IssueDetailPolicy = issueDetailsHidePrivate
+		hiding.SetAPI(api)
+
+		r := httptest.NewRequest(http.MethodPost, routeWebhook+"?secret=secret&channel=channel-a", strings.NewReader(strings.Replace(webhookOpenedPayload, `"is_private": false`, `"is_private": true`, 1)))
+		w := httptest.NewRecorder()
+		hiding.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"posted": 0}`, w.Body.String())
+		assert.Empty(t, posts)
+	})
+
+	t.Run("Channels not subscribed to the project", func(t *testing.T) {
+		api.On("LogWarn", "Webhook channels are not subscribed to the project", "instance", "https://redmine.example.com", "project_id", 1, "channel_ids", "channel-d,channel-e")
+
+		posts = nil
+		w := send("?secret=secret&channel=channel-a&channel=channel-c&channel=channel-d&channel=channel-e", webhookOpenedPayload)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"posted": 1, "rejected": ["channel-d", "channel-e"]}`, w.Body.String())
+		require.Len(t, posts, 1)
+		assert.Equal(t, "channel-a", posts[0].ChannelId)
+
+		posts = nil
+		w = send("?secret=secret&channel=channel-d&channel=channel-e", webhookOpenedPayload)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, posts)
+	})
+
+	t.Run("Unwrapped payload", func(t *testing.T) {
+		posts = nil
+		unwrapped := strings.TrimSuffix(strings.TrimPrefix(webhookOpenedPayload, `{"payload": `), "}")
+		w := send("?secret=secret&channel=channel-a", unwrapped)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, posts, 1)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("?channel=channel-a", webhookOpenedPayload).Code)
+		assert.Equal(t, http.StatusUnauthorized, send("?secret=wrong&channel=channel-a", webhookOpenedPayload).Code)
+		assert.Equal(t, http.StatusBadRequest, send("?secret=secret", "not json").Code)
+		assert.Equal(t, http.StatusBadRequest, send("?secret=secret", `{"payload": {"action": "deleted", "issue": {"id": 1}}}`).Code)
+		assert.Equal(t, http.StatusBadRequest, send("?secret=secret&instance=https://unknown.example.com", webhookOpenedPayload).Code)
+
+		r := httptest.NewRequest(http.MethodGet, routeWebhook+"?secret=secret", nil)
+		w := httptest.NewRecorder()
+		plugin.ServeHTTP(nil, w, r)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
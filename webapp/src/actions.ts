@@ -0,0 +1,25 @@
+import {Store, Action} from 'redux';
+
+import {GlobalState} from '@mattermost/types/lib/store';
+import {Client4} from 'mattermost-redux/client';
+import {getConfig} from 'mattermost-redux/selectors/entities/general';
+import {getPost} from 'mattermost-redux/selectors/entities/posts';
+import {getCurrentTeamId} from 'mattermost-redux/selectors/entities/teams';
+
+// Opens the create issue dialog prefilled from a post. Interactive dialogs need a trigger ID,
+// which the server hands out when the /redmine create command is executed.
+export async function createIssueFromPost(store: Store<GlobalState, Action<Record<string, unknown>>>, postId: string) {
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const state = store.getState() as any;
+    const post = getPost(state, postId);
+    if (!post) {
+        return;
+    }
+
+    Client4.setUrl(getConfig(state).SiteURL || '');
+    await Client4.executeCommand(`/redmine create --post ${postId}`, {
+        channel_id: post.channel_id,
+        team_id: getCurrentTeamId(state),
+        root_id: post.root_id,
+    });
+}
@@ -0,0 +1,53 @@
+import {Client4} from 'mattermost-redux/client';
+
+import manifest from '@/manifest';
+
+// Issue shown in the hover card of an issue link, as returned by the plugin server.
+export interface IssueDetails {
+    instance: string;
+    url: string;
+    id: number;
+    tracker: string;
+    subject: string;
+    project: string;
+    status: string;
+    is_closed: boolean;
+    color: string;
+    assignee: string;
+    priority: string;
+    done_ratio: number;
+    due_date?: string;
+    updated_on?: string;
+    last_note?: {
+        author: string;
+        notes: string;
+        created_on: string;
+    };
+}
+
+// Issue details are reused for a minute, so that hovering a link again does not reach Redmine.
+const issueDetailsTTL = 60 * 1000;
+const issueDetailsRequests = new Map<string, {request: Promise<IssueDetails | null>; expiresAt: number}>();
+
+// Fetches the issue linked by url in the channel as seen by the current user. Resolves to null
+// when the link is not a Redmine issue posted in the channel that the user may see.
+export function fetchIssueDetails(siteURL: string, url: string, channelId: string): Promise<IssueDetails | null> {
+    if (!channelId) {
+        return Promise.resolve(null);
+    }
+
+    const query = new URLSearchParams({url, channel_id: channelId});
+
+    const requestURL = `${siteURL}/plugins/${manifest.id}/api/v1/issue?${query.toString()}`;
+    const cached = issueDetailsRequests.get(requestURL);
+    if (cached && cached.expiresAt > Date.now()) {
+        return cached.request;
+    }
+
+    const request = fetch(requestURL, Client4.getOptions({method: 'get'})).
+        then((response) => (response.ok ? response.json() : null)).
+        catch(() => null);
+    issueDetailsRequests.set(requestURL, {request, expiresAt: Date.now() + issueDetailsTTL});
+
+    return request;
+}
@@ -6,10 +6,25 @@ import manifest from '@/manifest';
 
 import {PluginRegistry} from '@/types/mattermost-webapp';
 
+import {createIssueFromPost} from '@/actions';
+import IssueTooltip from '@/issue_tooltip';
+import {renderIssuePreviews} from '@/previews';
+
 export default class Plugin {
-    // eslint-disable-next-line @typescript-eslint/no-unused-vars, @typescript-eslint/no-empty-function
     public async initialize(registry: PluginRegistry, store: Store<GlobalState, Action<Record<string, unknown>>>) {
         // @see https://developers.mattermost.com/extend/plugins/webapp/reference/
+        registry.registerPostDropdownMenuAction(
+            'Create Redmine issue from message',
+            (postId: string) => createIssueFromPost(store, postId),
+        );
+
+        registry.registerLinkTooltipComponent(IssueTooltip);
+
+        // Renders the issue links of posts stored with their message as written. Older
+        // Mattermost versions without the hook show the plain links.
+        if (registry.registerMessageWillFormatHook) {
+            registry.registerMessageWillFormatHook(renderIssuePreviews);
+        }
     }
 }
 
@@ -0,0 +1,144 @@
+import React, {useEffect, useState} from 'react';
+import {useSelector} from 'react-redux';
+
+import {GlobalState} from '@mattermost/types/lib/store';
+import {getConfig} from 'mattermost-redux/selectors/entities/general';
+import {getCurrentChannelId} from 'mattermost-redux/selectors/entities/channels';
+
+import {IssueDetails, fetchIssueDetails} from '@/client';
+
+// Links that may point to a Redmine issue. The server decides whether they belong to a
+// configured instance.
+const issueLinkPattern = /\/issues\/\d+(?:[?#].*)?$/;
+
+interface Props {
+    href: string;
+    show?: boolean;
+}
+
+// Card shown when hovering a Redmine issue link, with the issue as seen by the current user.
+export default function IssueTooltip({href, show = true}: Props) {
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const siteURL = useSelector((state: GlobalState) => getConfig(state as any).SiteURL || '');
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const channelId = useSelector((state: GlobalState) => getCurrentChannelId(state as any));
+    const [issue, setIssue] = useState<IssueDetails | null>(null);
+
+    const isIssueLink = issueLinkPattern.test(href);
+    useEffect(() => {
+        if (!show || !isIssueLink) {
+            return undefined;
+        }
+
+        let cancelled = false;
+        fetchIssueDetails(siteURL, href, channelId).then((details) => {
+            if (!cancelled) {
+                setIssue(details);
+            }
+        });
+
+        return () => {
+            cancelled = true;
+        };
+    }, [href, show, isIssueLink, siteURL, channelId]);
+
+    if (!show || !isIssueLink || !issue) {
+        return null;
+    }
+
+    return (
+        <div style={{...styles.card, borderLeftColor: issue.color}}>
+            <div style={styles.instance}>{issue.instance}{issue.project ? ` · ${issue.project}` : ''}</div>
+            <div style={styles.title}>
+                {`${issue.tracker} #${issue.id}: ${issue.subject}`}
+            </div>
+            <dl style={styles.fields}>
+                <Field
+                    label='Status'
+                    value={issue.status}
+                />
+                <Field
+                    label='Assignee'
+                    value={issue.assignee || 'Unassigned'}
+                />
+                <Field
+                    label='Priority'
+                    value={issue.priority}
+                />
+                <Field
+                    label='Progress'
+                    value={`${issue.done_ratio}%`}
+                />
+                {issue.due_date && (
+                    <Field
+                        label='Due date'
+                        value={issue.due_date}
+                    />
+                )}
+            </dl>
+            {issue.last_note && (
+                <div style={styles.note}>
+                    <div style={styles.noteHeader}>{`${issue.last_note.author}, ${issue.last_note.created_on}`}</div>
+                    <div style={styles.noteText}>{issue.last_note.notes}</div>
+                </div>
+            )}
+        </div>
+    );
+}
+
+function Field({label, value}: {label: string; value: string}) {
+    return (
+        <div style={styles.field}>
+            <dt style={styles.fieldLabel}>{label}</dt>
+            <dd style={styles.fieldValue}>{value}</dd>
+        </div>
+    );
+}
+
+const styles: Record<string, React.CSSProperties> = {
+    card: {
+        maxWidth: 360,
+        padding: '12px 16px',
+        borderLeft: '4px solid',
+        fontSize: 12,
+        lineHeight: '16px',
+    },
+    instance: {
+        opacity: 0.64,
+        marginBottom: 4,
+    },
+    title: {
+        fontSize: 14,
+        fontWeight: 600,
+        lineHeight: '20px',
+        marginBottom: 8,
+    },
+    fields: {
+        display: 'flex',
+        flexWrap: 'wrap',
+        margin: 0,
+    },
+    field: {
+        width: '50%',
+        marginBottom: 6,
+    },
+    fieldLabel: {
+        fontWeight: 600,
+    },
+    fieldValue: {
+        margin: 0,
+    },
+    note: {
+        marginTop: 4,
+        paddingTop: 8,
+        borderTop: '1px solid rgba(0, 0, 0, 0.08)',
+    },
+    noteHeader: {
+        opacity: 0.64,
+        marginBottom: 2,
+    },
+    noteText: {
+        whiteSpace: 'pre-wrap',
+        overflowWrap: 'anywhere',
+    },
+};
@@ -0,0 +1,44 @@
+import {Post} from '@mattermost/types/lib/posts';
+
+import {ISSUE_PREVIEWS_PROP, IssuePreview, renderIssuePreviews} from './previews';
+
+const link = '[Bug#1: Login fails](https://redmine.example.com/issues/1 "Closed")';
+
+function preview(start: number, text: string): IssuePreview {
+    return {
+        start,
+        end: start + text.length,
+        text,
+        link,
+        instance: 'https://redmine.example.com',
+        issue_id: 1,
+        subject: 'Login fails',
+        status: 'Closed',
+        is_closed: true,
+    };
+}
+
+function post(previews?: IssuePreview[]): Post {
+    return {props: previews ? {[ISSUE_PREVIEWS_PROP]: previews} : {}} as unknown as Post;
+}
+
+describe('renderIssuePreviews', () => {
+    const url = 'https://redmine.example.com/issues/1';
+
+    test('replaces references with the rendered links', () => {
+        const message = `🚀 Fixed ${url} and ${url}`;
+        const previews = [preview(9 + url.length + 5, url), preview(9, url)];
+
+        expect(renderIssuePreviews(post(previews), message)).toEqual(`🚀 Fixed ${link} and ${link}`);
+    });
+
+    test('keeps posts without previews', () => {
+        expect(renderIssuePreviews(post(), `See ${url}`)).toEqual(`See ${url}`);
+    });
+
+    test('keeps references that moved', () => {
+        const message = `Now see ${url}`;
+
+        expect(renderIssuePreviews(post([preview(4, url)]), message)).toEqual(message);
+    });
+});
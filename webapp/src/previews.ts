@@ -0,0 +1,52 @@
+import {Post} from '@mattermost/types/lib/posts';
+
+// Post prop holding the issue and resource links rendered by the server when the Link Display
+// Mode setting is "Render-time previews". The stored message is left as written.
+export const ISSUE_PREVIEWS_PROP = 'redmine_previews';
+
+export interface IssuePreview {
+
+    // Location of the reference in the message, in UTF-16 code units.
+    start: number;
+    end: number;
+
+    // Reference as written, used to check that the message was not changed since.
+    text: string;
+
+    // Markdown link replacing the reference.
+    link: string;
+
+    // Issue fields, left out for links to projects, versions, wiki pages and queries.
+    instance?: string;
+    issue_id?: number;
+    subject?: string;
+    status?: string;
+    is_closed?: boolean;
+}
+
+export function getIssuePreviews(post: Post): IssuePreview[] {
+    const previews = post.props?.[ISSUE_PREVIEWS_PROP];
+    return Array.isArray(previews) ? previews as IssuePreview[] : [];
+}
+
+// Replaces the issue references of the message with the links rendered by the server, before the
+// message is formatted. References that moved since the post was rendered are kept as written.
+export function renderIssuePreviews(post: Post, message: string): string {
+    const previews = getIssuePreviews(post).slice().sort((a, b) => a.start - b.start);
+    if (previews.length === 0) {
+        return message;
+    }
+
+    let rendered = '';
+    let index = 0;
+    for (const preview of previews) {
+        if (preview.start < index || message.slice(preview.start, preview.end) !== preview.text) {
+            continue;
+        }
+
+        rendered += message.slice(index, preview.start) + preview.link;
+        index = preview.end;
+    }
+
+    return rendered + message.slice(index);
+}
@@ -1,5 +1,10 @@
+import {Post} from '@mattermost/types/lib/posts';
+
 export interface PluginRegistry {
     registerPostTypeComponent(typeName: string, component: React.ElementType)
+    registerPostDropdownMenuAction(text: React.ReactNode, action: (postId: string) => void, filter?: (postId: string) => boolean)
+    registerLinkTooltipComponent(component: React.ElementType)
+    registerMessageWillFormatHook?(hook: (post: Post, message: string) => string)
 
     // Add more if needed from https://developers.mattermost.com/extend/plugins/webapp/reference
 }